@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxAIASize is the largest issuer certificate response we're willing to
+	// download.
+	maxAIASize = 1 << 20
+
+	// maxAIAFetches limits how many issuer URLs are followed for one signature.
+	maxAIAFetches = 8
+)
+
+// aiaHTTPClient is used for fetching issuer certificates. It is changed in
+// tests.
+var aiaHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchIntermediates downloads issuer certificates that are missing from the
+// signature and from opts, following the CA Issuers URLs in the certificates'
+// Authority Information Access extension. Downloaded certificates are added to
+// opts.Intermediates. They are only ever used as intermediates, so trust still
+// depends on opts.Roots. Failures are reported as warnings, leaving
+// verification to fail as it would have otherwise. Nothing is downloaded
+// unless --fetch-intermediates is given, since the URLs are chosen by whoever
+// made the signature.
+func fetchIntermediates(sd *cms.SignedData, opts x509.VerifyOptions) {
+	if !*fetchIntermediatesFlag {
+		return
+	}
+
+	certs, err := sd.GetCertificates()
+	if err != nil {
+		return
+	}
+
+	// The certificates in the signature are also available for building chains.
+	pool := opts.Intermediates.Clone()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	chainOpts := opts
+	chainOpts.Intermediates = pool
+
+	var (
+		pending = certs
+		fetched = map[string]bool{}
+	)
+
+	for len(pending) > 0 && len(fetched) < maxAIAFetches {
+		cert := pending[0]
+		pending = pending[1:]
+
+		if len(cert.IssuingCertificateURL) == 0 {
+			continue
+		}
+		if _, err := cert.Verify(chainOpts); err == nil {
+			continue
+		}
+
+		for _, url := range cert.IssuingCertificateURL {
+			if fetched[url] || len(fetched) >= maxAIAFetches {
+				continue
+			}
+			fetched[url] = true
+
+			logf("fetching issuer of \"%s\" from %s", cert.Subject.String(), url)
+			issuers, err := getIssuerCerts(url)
+			if err != nil {
+				fmt.Fprintf(stderr, "smimesign: WARNING: failed to fetch issuer certificate (%s): %s\n", url, err)
+				continue
+			}
+
+			for _, issuer := range issuers {
+				debugf(debugBasic, "fetched issuer certificate \"%s\" (0x%s)", issuer.Subject.String(), certHexFingerprint(issuer))
+				opts.Intermediates.AddCert(issuer)
+				pool.AddCert(issuer)
+			}
+			pending = append(pending, issuers...)
+		}
+	}
+}
+
+// getIssuerCerts gets the certificates at url, using the cached copy if none
+// of them have expired.
+func getIssuerCerts(url string) ([]*x509.Certificate, error) {
+	cachePath := aiaCachePath(url)
+
+	if data, err := ioutil.ReadFile(cachePath); err == nil {
+		if certs, err := parseIssuerCerts(data); err == nil && !anyExpired(certs) {
+			debugf(debugAdvanced, "using cached issuer certificates from %s", cachePath)
+			return certs, nil
+		}
+	}
+
+	data, err := fetchIssuerCerts(url)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := parseIssuerCerts(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Caching is best-effort.
+	if err = os.MkdirAll(filepath.Dir(cachePath), 0700); err == nil {
+		ioutil.WriteFile(cachePath, data, 0600)
+	}
+
+	return certs, nil
+}
+
+// parseIssuerCerts parses a DER or PEM encoded certificate or a PKCS#7
+// certs-only message, as served from CA Issuers URLs.
+func parseIssuerCerts(data []byte) ([]*x509.Certificate, error) {
+	der := derFromMaybePEM(data)
+
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		return []*x509.Certificate{cert}, nil
+	}
+
+	ci, err := protocol.ParseContentInfo(der)
+	if err != nil {
+		return nil, errors.New("not a certificate or PKCS#7 message")
+	}
+
+	psd, err := ci.SignedDataContent()
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := psd.X509Certificates()
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates in PKCS#7 message")
+	}
+
+	return certs, nil
+}
+
+// fetchIssuerCerts downloads the certificates at url.
+func fetchIssuerCerts(url string) ([]byte, error) {
+	resp, err := aiaHTTPClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad HTTP status: %s", resp.Status)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err = io.Copy(buf, io.LimitReader(resp.Body, maxAIASize+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxAIASize {
+		return nil, errors.New("issuer certificate response too large")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// anyExpired checks if any of the certs have expired.
+func anyExpired(certs []*x509.Certificate) bool {
+	now := time.Now()
+	for _, cert := range certs {
+		if now.After(cert.NotAfter) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// aiaCachePath gets the path at which the certificates from url are cached.
+func aiaCachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(cacheDir(), "aia", hex.EncodeToString(sum[:]))
+}
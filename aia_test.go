@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+// serveAIA makes aiaHTTPClient respond to all requests with body. The returned
+// function restores the original client.
+func serveAIA(t *testing.T, body []byte) func() {
+	t.Helper()
+
+	orig := aiaHTTPClient
+	aiaHTTPClient = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		if body == nil {
+			return nil, errors.New("offline")
+		}
+
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		}, nil
+	})}
+
+	return func() { aiaHTTPClient = orig }
+}
+
+// certsOnly creates a PKCS#7 certs-only message containing certs.
+func certsOnly(t *testing.T, certs ...*x509.Certificate) []byte {
+	t.Helper()
+
+	eci, err := protocol.NewDataEncapsulatedContentInfo(nil)
+	require.NoError(t, err)
+
+	psd, err := protocol.NewSignedData(eci)
+	require.NoError(t, err)
+
+	for _, cert := range certs {
+		require.NoError(t, psd.AddCertificate(cert))
+	}
+
+	der, err := psd.ContentInfoDER()
+	require.NoError(t, err)
+
+	return der
+}
+
+func TestParseIssuerCerts(t *testing.T) {
+	certs, err := parseIssuerCerts(intermediate.Certificate.Raw)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(certs))
+	require.True(t, certs[0].Equal(intermediate.Certificate))
+
+	certs, err = parseIssuerCerts(certsOnly(t, intermediate.Certificate, ca.Certificate))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(certs))
+
+	_, err = parseIssuerCerts(certsOnly(t))
+	require.Error(t, err)
+
+	_, err = parseIssuerCerts([]byte("hello"))
+	require.Error(t, err)
+}
+
+func TestVerifyFetchIntermediates(t *testing.T) {
+	// The signature only includes the leaf, which has an AIA URL.
+	sig, err := cms.Sign([]byte("hello, world!"), []*x509.Certificate{aiaLeaf.Certificate}, aiaLeaf.PrivateKey)
+	require.NoError(t, err)
+
+	caFile := writeCertsFile(t, os.TempDir(), "smimesign-aia-ca.pem", ca.Certificate)
+	defer os.Remove(caFile)
+
+	func() {
+		defer testCacheDir(t, "--verify", "--no-system-roots", "--trust-anchors", caFile, "--fetch-intermediates")()
+		defer serveAIA(t, intermediate.Certificate.Raw)()
+
+		stdinBuf.Write(sig)
+		require.NoError(t, commandVerify())
+	}()
+
+	func() {
+		defer testCacheDir(t, "--verify", "--no-system-roots", "--trust-anchors", caFile, "--fetch-intermediates")()
+		defer serveAIA(t, certsOnly(t, intermediate.Certificate))()
+
+		stdinBuf.Write(sig)
+		require.NoError(t, commandVerify())
+	}()
+
+	func() {
+		defer testCacheDir(t, "--verify", "--no-system-roots", "--trust-anchors", caFile)()
+		defer serveAIA(t, intermediate.Certificate.Raw)()
+
+		stdinBuf.Write(sig)
+		require.Error(t, commandVerify())
+	}()
+
+	func() {
+		defer testCacheDir(t, "--verify", "--no-system-roots", "--trust-anchors", caFile, "--fetch-intermediates")()
+
+		func() {
+			defer serveAIA(t, nil)()
+
+			stdinBuf.Write(sig)
+			require.Error(t, commandVerify())
+			require.Contains(t, stderrBuf.String(), "failed to fetch issuer certificate")
+		}()
+
+		func() {
+			defer serveAIA(t, intermediate.Certificate.Raw)()
+
+			stdinBuf.Write(sig)
+			require.NoError(t, commandVerify())
+		}()
+
+		// The cached certificate is used while offline.
+		defer serveAIA(t, nil)()
+
+		stdinBuf.Write(sig)
+		require.NoError(t, commandVerify())
+	}()
+}
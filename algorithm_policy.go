@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"strconv"
+	"strings"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/pkg/errors"
+)
+
+// Algorithm policies are given as whitespace separated options, each
+// overriding part of the default policy:
+//
+//	digests=sha256,sha384,sha512 min-rsa-bits=2048 curves=P-256,P-384,P-521
+
+// digestAlgorithms maps the lower case names of digest algorithms, as used
+// by --algorithm-policy and --digest-algo, to their crypto.Hash values.
+var digestAlgorithms = map[string]crypto.Hash{
+	"md5":        crypto.MD5,
+	"sha1":       crypto.SHA1,
+	"sha224":     crypto.SHA224,
+	"sha256":     crypto.SHA256,
+	"sha384":     crypto.SHA384,
+	"sha512":     crypto.SHA512,
+	"sha512-256": crypto.SHA512_256,
+	"sha3-256":   crypto.SHA3_256,
+	"sha3-384":   crypto.SHA3_384,
+	"sha3-512":   crypto.SHA3_512,
+}
+
+// digestAlgorithmName gets the name of hash in digestAlgorithms, or an empty
+// string if it doesn't have one.
+func digestAlgorithmName(hash crypto.Hash) string {
+	for name, h := range digestAlgorithms {
+		if h == hash {
+			return name
+		}
+	}
+
+	return ""
+}
+
+var policyCurves = map[string]elliptic.Curve{
+	"P-224": elliptic.P224(),
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// algorithmPolicyFromOpt gets the algorithm policy for verification, applying
+// any overrides from --algorithm-policy to the default policy.
+func algorithmPolicyFromOpt() (*cms.AlgorithmPolicy, error) {
+	policy, err := parseAlgorithmPolicy(*algorithmPolicyOpt)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad algorithm policy")
+	}
+
+	return policy, nil
+}
+
+// parseAlgorithmPolicy parses an algorithm policy, starting from the default.
+func parseAlgorithmPolicy(spec string) (*cms.AlgorithmPolicy, error) {
+	policy := cms.DefaultAlgorithmPolicy
+
+	for _, opt := range strings.Fields(spec) {
+		parts := strings.SplitN(opt, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("expected name=value, got %q", opt)
+		}
+		name, value := parts[0], parts[1]
+
+		switch name {
+		case "digests":
+			policy.Hashes = []crypto.Hash{}
+			for _, v := range strings.Split(value, ",") {
+				hash, ok := digestAlgorithms[strings.ToLower(v)]
+				if !ok {
+					return nil, errors.Errorf("unknown digest algorithm %q", v)
+				}
+				policy.Hashes = append(policy.Hashes, hash)
+			}
+		case "min-rsa-bits":
+			bits, err := strconv.Atoi(value)
+			if err != nil || bits < 0 {
+				return nil, errors.Errorf("bad RSA key size %q", value)
+			}
+			policy.MinRSABits = bits
+		case "curves":
+			policy.Curves = []elliptic.Curve{}
+			for _, v := range strings.Split(value, ",") {
+				curve, ok := policyCurves[strings.ToUpper(v)]
+				if !ok {
+					return nil, errors.Errorf("unknown curve %q", v)
+				}
+				policy.Curves = append(policy.Curves, curve)
+			}
+		default:
+			return nil, errors.Errorf("unknown option %q", name)
+		}
+	}
+
+	return &policy, nil
+}
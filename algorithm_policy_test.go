@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"testing"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAlgorithmPolicy(t *testing.T) {
+	policy, err := parseAlgorithmPolicy("")
+	require.NoError(t, err)
+	require.Equal(t, cms.DefaultAlgorithmPolicy, *policy)
+
+	policy, err = parseAlgorithmPolicy("digests=SHA1,sha256  min-rsa-bits=1024\tcurves=p-384")
+	require.NoError(t, err)
+	require.Equal(t, []crypto.Hash{crypto.SHA1, crypto.SHA256}, policy.Hashes)
+	require.Equal(t, 1024, policy.MinRSABits)
+	require.Equal(t, []elliptic.Curve{elliptic.P384()}, policy.Curves)
+
+	// The default policy isn't modified.
+	require.Equal(t, 2048, cms.DefaultAlgorithmPolicy.MinRSABits)
+
+	policy, err = parseAlgorithmPolicy("digests=sha3-256,SHA512-256")
+	require.NoError(t, err)
+	require.Equal(t, []crypto.Hash{crypto.SHA3_256, crypto.SHA512_256}, policy.Hashes)
+
+	for _, bad := range []string{"digests", "digests=md4", "min-rsa-bits=big", "curves=P-192", "foo=bar"} {
+		_, err = parseAlgorithmPolicy(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestVerifyAlgorithmPolicy(t *testing.T) {
+	sig, err := cms.Sign([]byte("hello, world!"), leaf.Chain(), leaf.PrivateKey)
+	require.NoError(t, err)
+
+	func() {
+		defer testSetup(t, "--verify", "--trust-local-certs")()
+
+		stdinBuf.Write(sig)
+		require.NoError(t, commandVerify())
+	}()
+
+	func() {
+		defer testSetup(t, "--verify", "--trust-local-certs", "--algorithm-policy", "min-rsa-bits=4096")()
+
+		stdinBuf.Write(sig)
+		require.Error(t, commandVerify())
+	}()
+
+	func() {
+		defer testSetup(t, "--verify", "--trust-local-certs", "--algorithm-policy", "min-rsa-bits")()
+
+		stdinBuf.Write(sig)
+		require.EqualError(t, commandVerify(), `bad algorithm policy: expected name=value, got "min-rsa-bits"`)
+	}()
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements the allowed-signers policy file. It serves a similar
+// purpose to ssh's allowed_signers file, restricting which certificates may
+// make signatures for which email addresses. Each non-empty line that doesn't
+// start with "#" has the format
+//
+//   <email-pattern>[,<email-pattern>...] <option> [<option>...]
+//
+// Email patterns are matched case-insensitively using path.Match syntax. The
+// following options are supported and all given options must match:
+//
+//   - fingerprint=<hex> :: SHA1 fingerprint of the signing certificate.
+//   - ca=<hex>          :: SHA1 fingerprint of a CA in the certificate's chain.
+//   - subject=<pattern> :: path.Match pattern for the signing certificate's
+//                          subject. Quote the value if it contains spaces.
+//   - valid-after=<t>   :: The entry only applies after this time.
+//   - valid-before=<t>  :: The entry only applies before this time.
+//
+// At least one of fingerprint, ca or subject is required. Times are given as
+// YYYYMMDD or YYYYMMDDhhmmss in UTC.
+
+// allowedSigner is a single entry from the allowed-signers file.
+type allowedSigner struct {
+	emailPatterns  []string
+	fingerprint    []byte
+	caFingerprint  []byte
+	subjectPattern string
+	validAfter     time.Time
+	validBefore    time.Time
+}
+
+// allowedSigners is a parsed allowed-signers file.
+type allowedSigners []allowedSigner
+
+// loadAllowedSigners reads the allowed-signers file at the given path.
+func loadAllowedSigners(filename string) (allowedSigners, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open allowed-signers file (%s)", filename)
+	}
+	defer f.Close()
+
+	as, err := parseAllowedSigners(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse allowed-signers file (%s)", filename)
+	}
+
+	return as, nil
+}
+
+// parseAllowedSigners parses an allowed-signers file.
+func parseAllowedSigners(r io.Reader) (allowedSigners, error) {
+	var (
+		as      = allowedSigners{}
+		scanner = bufio.NewScanner(r)
+		lineNo  int
+	)
+
+	for scanner.Scan() {
+		lineNo++
+
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := parseAllowedSigner(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", lineNo)
+		}
+
+		as = append(as, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return as, nil
+}
+
+// parseAllowedSigner parses a single line of an allowed-signers file.
+func parseAllowedSigner(line string) (allowedSigner, error) {
+	var entry allowedSigner
+
+	fields, err := splitQuoted(line)
+	if err != nil {
+		return entry, err
+	}
+	if len(fields) < 2 {
+		return entry, errors.New("expected email pattern and options")
+	}
+
+	for _, pattern := range strings.Split(fields[0], ",") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return entry, errors.Wrapf(err, "bad email pattern (%s)", pattern)
+		}
+		entry.emailPatterns = append(entry.emailPatterns, strings.ToLower(pattern))
+	}
+
+	for _, opt := range fields[1:] {
+		eq := strings.IndexByte(opt, '=')
+		if eq < 0 {
+			return entry, fmt.Errorf("bad option: %s", opt)
+		}
+
+		switch name, value := opt[:eq], opt[eq+1:]; name {
+		case "fingerprint":
+			if entry.fingerprint = normalizeFingerprint(value); entry.fingerprint == nil {
+				return entry, fmt.Errorf("bad fingerprint: %s", value)
+			}
+		case "ca":
+			if entry.caFingerprint = normalizeFingerprint(value); entry.caFingerprint == nil {
+				return entry, fmt.Errorf("bad ca fingerprint: %s", value)
+			}
+		case "subject":
+			if _, err := path.Match(value, ""); err != nil {
+				return entry, errors.Wrapf(err, "bad subject pattern (%s)", value)
+			}
+			entry.subjectPattern = value
+		case "valid-after":
+			if entry.validAfter, err = parseAllowedSignerTime(value); err != nil {
+				return entry, err
+			}
+		case "valid-before":
+			if entry.validBefore, err = parseAllowedSignerTime(value); err != nil {
+				return entry, err
+			}
+		default:
+			return entry, fmt.Errorf("unknown option: %s", name)
+		}
+	}
+
+	if entry.fingerprint == nil && entry.caFingerprint == nil && len(entry.subjectPattern) == 0 {
+		return entry, errors.New("one of fingerprint, ca or subject is required")
+	}
+
+	return entry, nil
+}
+
+// parseAllowedSignerTime parses a YYYYMMDD or YYYYMMDDhhmmss time.
+func parseAllowedSignerTime(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102", "20060102150405"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("bad time: %s", value)
+}
+
+// splitQuoted splits a line on whitespace, keeping double quoted sections
+// together and removing the quotes.
+func splitQuoted(line string) ([]string, error) {
+	var (
+		fields  []string
+		field   = new(bytes.Buffer)
+		inField bool
+		quoted  bool
+	)
+
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields, nil
+}
+
+// allows checks if any entry allows a certificate with one of the given chains
+// to sign for email at time t.
+func (as allowedSigners) allows(email string, chains [][]*x509.Certificate, t time.Time) bool {
+	for _, entry := range as {
+		for _, chain := range chains {
+			if entry.allows(email, chain, t) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// allows checks if the entry allows the leaf certificate of chain to sign for
+// email at time t.
+func (entry allowedSigner) allows(email string, chain []*x509.Certificate, t time.Time) bool {
+	if len(chain) == 0 {
+		return false
+	}
+	cert := chain[0]
+
+	if !entry.matchesEmail(email) {
+		return false
+	}
+	if !entry.validAfter.IsZero() && t.Before(entry.validAfter) {
+		return false
+	}
+	if !entry.validBefore.IsZero() && !t.Before(entry.validBefore) {
+		return false
+	}
+	if entry.fingerprint != nil && !bytes.Equal(certFingerprint(cert), entry.fingerprint) {
+		return false
+	}
+	if len(entry.subjectPattern) > 0 {
+		if ok, _ := path.Match(entry.subjectPattern, cert.Subject.String()); !ok {
+			return false
+		}
+	}
+	if entry.caFingerprint != nil {
+		var found bool
+		for _, ca := range chain[1:] {
+			if bytes.Equal(certFingerprint(ca), entry.caFingerprint) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesEmail checks if any of the entry's email patterns match email.
+func (entry allowedSigner) matchesEmail(email string) bool {
+	email = strings.ToLower(email)
+
+	for _, pattern := range entry.emailPatterns {
+		if ok, _ := path.Match(pattern, email); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowedSignersFromOpt loads the file given with --allowed-signers. Nil is
+// returned if no file was given, in which case any signer is allowed.
+func allowedSignersFromOpt() (allowedSigners, error) {
+	if len(*allowedSignersOpt) == 0 {
+		return nil, nil
+	}
+
+	return loadAllowedSigners(*allowedSignersOpt)
+}
+
+// check checks that the signer with the given chains was allowed to sign for
+// any of the given email addresses when the signature was made at
+// signingTime. The current time is used if signingTime is zero, because the
+// signature has no verified timestamp.
+func (as allowedSigners) check(emails []string, chains [][]*x509.Certificate, signingTime time.Time) error {
+	if as == nil {
+		return nil
+	}
+
+	if signingTime.IsZero() {
+		signingTime = time.Now()
+	}
+
+	for _, email := range emails {
+		if as.allows(email, chains, signingTime) {
+			return nil
+		}
+	}
+
+	return errNotAllowed
+}
+
+var errNotAllowed = errors.New("signer is not allowed by allowed-signers file")
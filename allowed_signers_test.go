@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/smimesign/fakeca"
+	"github.com/stretchr/testify/require"
+)
+
+var aliceLeaf = intermediate.Issue(fakeca.Subject(pkix.Name{CommonName: "alice@example.com"}))
+
+func TestParseAllowedSigners(t *testing.T) {
+	as, err := parseAllowedSigners(strings.NewReader(`
+# comment
+alice@example.com,*@example.org fingerprint=0xabcd valid-after=20200101 valid-before=20300101120000
+*@example.com subject="CN=Alice *" ca=1234
+`))
+	require.NoError(t, err)
+	require.Equal(t, 2, len(as))
+
+	require.Equal(t, []string{"alice@example.com", "*@example.org"}, as[0].emailPatterns)
+	require.Equal(t, []byte{0xab, 0xcd}, as[0].fingerprint)
+	require.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), as[0].validAfter)
+	require.Equal(t, time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC), as[0].validBefore)
+
+	require.Equal(t, "CN=Alice *", as[1].subjectPattern)
+	require.Equal(t, []byte{0x12, 0x34}, as[1].caFingerprint)
+
+	for _, bad := range []string{
+		"alice@example.com",
+		"alice@example.com valid-after=20200101",
+		"alice@example.com fingerprint=xyz",
+		"alice@example.com foo=bar",
+		"alice@example.com subject=\"CN=Alice",
+	} {
+		_, err = parseAllowedSigners(strings.NewReader(bad))
+		require.Error(t, err, bad)
+	}
+
+	as, err = parseAllowedSigners(strings.NewReader(""))
+	require.NoError(t, err)
+	require.NotNil(t, as)
+}
+
+func TestAllowedSignersCheck(t *testing.T) {
+	chains := [][]*x509.Certificate{aliceLeaf.Chain()}
+
+	allowed := func(policy string) bool {
+		as, err := parseAllowedSigners(strings.NewReader(policy))
+		require.NoError(t, err)
+		return as.check(certEmails(aliceLeaf.Certificate), chains, time.Time{}) == nil
+	}
+
+	// No policy allows everything.
+	require.NoError(t, allowedSigners(nil).check(nil, chains, time.Time{}))
+
+	require.True(t, allowed("alice@example.com fingerprint="+certHexFingerprint(aliceLeaf.Certificate)))
+	require.True(t, allowed("*@EXAMPLE.com ca="+certHexFingerprint(intermediate.Certificate)))
+	require.True(t, allowed("*@example.com ca="+certHexFingerprint(ca.Certificate)))
+	require.True(t, allowed("*@example.com subject=*CN=alice@example.com*"))
+	require.True(t, allowed("*@example.com subject=* valid-after=20000101 valid-before=29990101"))
+
+	require.False(t, allowed(""))
+	require.False(t, allowed("bob@example.com fingerprint="+certHexFingerprint(aliceLeaf.Certificate)))
+	require.False(t, allowed("alice@example.com fingerprint="+certHexFingerprint(leaf.Certificate)))
+	require.False(t, allowed("alice@example.com ca="+certHexFingerprint(aliceLeaf.Certificate)))
+	require.False(t, allowed("alice@example.com subject=*CN=bob*"))
+	require.False(t, allowed("alice@example.com subject=* valid-after=29990101"))
+	require.False(t, allowed("alice@example.com subject=* valid-before=20000101"))
+	require.False(t, allowed("alice@example.com subject=* ca="+certHexFingerprint(fakeca.New(fakeca.IsCA).Certificate)))
+
+	// Validity windows apply at the time the signature was made.
+	as, err := parseAllowedSigners(strings.NewReader("alice@example.com subject=* valid-after=20000101 valid-before=20100101"))
+	require.NoError(t, err)
+	emails := certEmails(aliceLeaf.Certificate)
+	require.NoError(t, as.check(emails, chains, time.Date(2005, 1, 1, 0, 0, 0, 0, time.UTC)))
+	require.Equal(t, errNotAllowed, as.check(emails, chains, time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)))
+	require.Equal(t, errNotAllowed, as.check(emails, chains, time.Time{}))
+}
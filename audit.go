@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	"github.com/github/smimesign/ietf-cms/timestamp"
+	"github.com/pkg/errors"
+)
+
+// auditRecord is a line in the audit log, recording a signature made by
+// commandSign. Digests are hex encoded.
+type auditRecord struct {
+	// Time is when the signature was made.
+	Time time.Time `json:"time"`
+
+	// Signer is the fingerprint of the signer's certificate.
+	Signer string `json:"signer"`
+
+	// Subject is the subject of the signer's certificate.
+	Subject string `json:"subject"`
+
+	// DigestAlgorithm is the signer's digest algorithm, which is used for
+	// ContentDigest.
+	DigestAlgorithm string `json:"digest_algorithm"`
+
+	// ContentDigest is the digest of the signed content.
+	ContentDigest string `json:"content_digest"`
+
+	// SignatureDigest is the SHA-256 digest of the DER encoded signature. It
+	// identifies the signature when verifying the audit log.
+	SignatureDigest string `json:"signature_digest"`
+
+	// Detached is whether the signature is detached from the content.
+	Detached bool `json:"detached"`
+
+	// TSA is the URL of the timestamp authority, if the signature was
+	// timestamped.
+	TSA string `json:"tsa,omitempty"`
+
+	// TimestampSerial is the hex encoded serial number of the signature's
+	// timestamp, if it has one.
+	TimestampSerial string `json:"timestamp_serial,omitempty"`
+}
+
+// newAuditRecord creates an audit record for a signature made by cert.
+// signatureDigest is the SHA-256 digest of the encoded signature and si is its
+// SignerInfo. The content digest is taken from si's signed message digest, so
+// that it uses the signer's digest algorithm and can be checked against
+// detached signatures.
+func newAuditRecord(cert *x509.Certificate, signatureDigest []byte, si protocol.SignerInfo, detached bool, tsa string) (auditRecord, error) {
+	hash, err := si.Hash()
+	if err != nil {
+		return auditRecord{}, err
+	}
+
+	contentDigest, err := si.GetMessageDigestAttribute()
+	if err != nil {
+		return auditRecord{}, err
+	}
+
+	return auditRecord{
+		Time:            time.Now().UTC(),
+		Signer:          certHexFingerprint(cert),
+		Subject:         cert.Subject.String(),
+		DigestAlgorithm: digestAlgorithmName(hash),
+		ContentDigest:   hex.EncodeToString(contentDigest),
+		SignatureDigest: hex.EncodeToString(signatureDigest),
+		Detached:        detached,
+		TSA:             tsa,
+		TimestampSerial: timestampSerial(si),
+	}, nil
+}
+
+// writeAuditRecord appends rec to the --audit-log file, if one was given. The
+// record is written with a single append so that concurrent signers don't
+// interleave their records.
+func writeAuditRecord(rec auditRecord) error {
+	if len(*auditLogOpt) == 0 {
+		return nil
+	}
+
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(*auditLogOpt, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// readAuditLog reads the records from an audit log.
+func readAuditLog(path string) ([]auditRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		records []auditRecord
+		scanner = bufio.NewScanner(f)
+		lineNo  int
+	)
+
+	for scanner.Scan() {
+		lineNo++
+
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec auditRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, errors.Wrapf(err, "bad audit record on line %d", lineNo)
+		}
+
+		records = append(records, rec)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// timestampSerial gets the hex encoded serial number of si's timestamp, or an
+// empty string if si doesn't have one. The timestamp isn't verified.
+func timestampSerial(si protocol.SignerInfo) string {
+	rawValue, err := si.UnsignedAttrs.GetOnlyAttributeValueBytes(oid.AttributeTimeStampToken)
+	if err != nil {
+		return ""
+	}
+
+	ci, err := protocol.ParseContentInfo(rawValue.FullBytes)
+	if err != nil {
+		return ""
+	}
+
+	psd, err := ci.SignedDataContent()
+	if err != nil {
+		return ""
+	}
+
+	tsti, err := timestamp.ParseInfo(psd.EncapContentInfo)
+	if err != nil || tsti.SerialNumber == nil {
+		return ""
+	}
+
+	return strings.ToUpper(tsti.SerialNumber.Text(16))
+}
+
+// sha256Hex gets the hex encoded SHA-256 digest of data.
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		auditLog = filepath.Join(dir, "audit.jsonl")
+		fpr      = certHexFingerprint(leaf.Certificate)
+	)
+
+	sign := func(name string, args ...string) string {
+		defer testSetup(t, append([]string{"--sign", "-u", fpr, "--audit-log", auditLog}, args...)...)()
+
+		stdinBuf.WriteString("hello, world!")
+		require.NoError(t, commandSign())
+
+		path := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(path, stdoutBuf.Bytes(), 0644))
+		return path
+	}
+
+	attached := sign("attached.p7s")
+	detached := sign("detached.p7s", "--detach-sign", "--armor")
+
+	records, err := readAuditLog(auditLog)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(records))
+	for i, rec := range records {
+		require.Equal(t, fpr, rec.Signer)
+		require.Equal(t, "sha256", rec.DigestAlgorithm)
+		require.Equal(t, sha256Hex([]byte("hello, world!")), rec.ContentDigest)
+		require.Equal(t, i == 1, rec.Detached)
+		require.Empty(t, rec.TSA)
+		require.Empty(t, rec.TimestampSerial)
+	}
+
+	verify := func(paths ...string) error {
+		defer testSetup(t, append([]string{"--verify-audit-log", "--audit-log", auditLog}, paths...)...)()
+		return commandVerifyAuditLog()
+	}
+
+	require.NoError(t, verify(attached, detached))
+
+	// Signatures that weren't recorded fail.
+	unrecorded := filepath.Join(dir, "unrecorded.p7s")
+	der, err := cms.Sign([]byte("hello, world!"), leaf.Chain(), leaf.PrivateKey)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(unrecorded, der, 0644))
+	require.EqualError(t, verify(attached, unrecorded), "1 of 2 signatures failed audit log verification")
+
+	// Records that don't match the signature fail.
+	records[0].ContentDigest = sha256Hex([]byte("goodbye, world!"))
+	f, err := os.Create(auditLog)
+	require.NoError(t, err)
+	for _, rec := range records {
+		require.NoError(t, json.NewEncoder(f).Encode(rec))
+	}
+	require.NoError(t, f.Close())
+	require.Error(t, verify(attached))
+	require.NoError(t, verify(detached))
+
+	// Records use the signer's digest algorithm, which is checked for detached
+	// signatures too.
+	require.NoError(t, os.Remove(auditLog))
+	attached = sign("attached384.p7s", "--digest-algo", "sha384")
+	detached = sign("detached384.p7s", "--digest-algo", "sha384", "--detach-sign")
+
+	records, err = readAuditLog(auditLog)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(records))
+	for _, rec := range records {
+		require.Equal(t, "sha384", rec.DigestAlgorithm)
+		require.Equal(t, "6f9f238425eca2439ed4581ac1fdb45fc76379e7fba94bc0a7624fa3e7ab1ec3701b4bfcdda376ca755192e6f45f2a4e", rec.ContentDigest)
+	}
+	require.NoError(t, verify(attached, detached))
+
+	records[1].ContentDigest = records[0].ContentDigest[1:] + "0"
+	f, err = os.Create(auditLog)
+	require.NoError(t, err)
+	for _, rec := range records {
+		require.NoError(t, json.NewEncoder(f).Encode(rec))
+	}
+	require.NoError(t, f.Close())
+	require.NoError(t, verify(attached))
+	require.Error(t, verify(detached))
+
+	// Without --audit-log, nothing is recorded.
+	require.NoError(t, os.Remove(auditLog))
+	func() {
+		defer testSetup(t, "--sign", "-u", fpr)()
+		stdinBuf.WriteString("hello, world!")
+		require.NoError(t, commandSign())
+	}()
+	_, err = os.Stat(auditLog)
+	require.True(t, os.IsNotExist(err))
+}
@@ -2,14 +2,26 @@ package certstore
 
 import (
 	"crypto"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/x509"
 	"errors"
+	"io"
 )
 
 var (
 	// ErrUnsupportedHash is returned by Signer.Sign() when the provided hash
 	// algorithm isn't supported.
 	ErrUnsupportedHash = errors.New("unsupported hash algorithm")
+
+	// ErrUnsupportedSignerOpts is returned by Signer.Sign() when the provided
+	// options, such as an RSA-PSS salt length, aren't supported.
+	ErrUnsupportedSignerOpts = errors.New("unsupported signer options")
+
+	// ErrUnsupportedDecrypterOpts is returned by Decrypter.Decrypt() when the
+	// provided options aren't supported.
+	ErrUnsupportedDecrypterOpts = errors.New("unsupported decryption options")
 )
 
 // Open opens the system's certificate store.
@@ -38,12 +50,58 @@ type Identity interface {
 	// CertificateChain attempts to get the identity's full certificate chain.
 	CertificateChain() ([]*x509.Certificate, error)
 
-	// Signer gets a crypto.Signer that uses the identity's private key.
+	// Signer gets a crypto.Signer that uses the identity's private key. RSA
+	// keys make RSASSA-PSS signatures when passed *rsa.PSSOptions. Only salts
+	// as long as the digest are supported.
 	Signer() (crypto.Signer, error)
 
+	// Decrypter gets a crypto.Decrypter that uses the identity's private key.
+	// RSA keys support PKCS #1 v1.5 and OAEP decryption. EC keys can't
+	// decrypt, but their crypto.Decrypter implements KeyAgreer.
+	Decrypter() (crypto.Decrypter, error)
+
 	// Delete deletes this identity from the system.
 	Delete() error
 
 	// Close any manually managed memory held by the Identity.
 	Close()
 }
+
+// KeyAgreer is implemented by the crypto.Decrypter of identities with EC keys.
+type KeyAgreer interface {
+	// ECDH computes the raw shared secret with the remote public key.
+	ECDH(remote *ecdh.PublicKey) ([]byte, error)
+}
+
+// pssOptions gets the RSA-PSS options from opts, or nil if opts doesn't ask
+// for RSA-PSS. ErrUnsupportedSignerOpts is returned for salt lengths other than
+// the digest length, since the system APIs don't all support them.
+func pssOptions(opts crypto.SignerOpts) (*rsa.PSSOptions, error) {
+	pss, ok := opts.(*rsa.PSSOptions)
+	if !ok {
+		return nil, nil
+	}
+
+	switch pss.SaltLength {
+	case rsa.PSSSaltLengthEqualsHash, pss.HashFunc().Size():
+		return pss, nil
+	default:
+		return nil, ErrUnsupportedSignerOpts
+	}
+}
+
+// randomSessionKey returns a random key of length n. Like
+// rsa.DecryptPKCS1v15SessionKey, it is returned instead of an error when
+// decrypting a session key fails, so that padding errors aren't revealed.
+func randomSessionKey(random io.Reader, n int) ([]byte, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+
+	key := make([]byte, n)
+	if _, err := io.ReadFull(random, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
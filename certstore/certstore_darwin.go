@@ -9,6 +9,7 @@ package certstore
 import "C"
 import (
 	"crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
@@ -206,6 +207,17 @@ func (i *macIdentity) Signer() (crypto.Signer, error) {
 	return i, nil
 }
 
+// Decrypter implements the Identity interface.
+func (i *macIdentity) Decrypter() (crypto.Decrypter, error) {
+	// pre-load the certificate so Public() is less likely to return nil
+	// unexpectedly.
+	if _, err := i.Certificate(); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
 // Delete implements the Identity interface.
 func (i *macIdentity) Delete() error {
 	itemList := []C.SecIdentityRef{i.ref}
@@ -279,7 +291,12 @@ func (i *macIdentity) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts
 	}
 	defer C.CFRelease(C.CFTypeRef(cdigest))
 
-	algo, err := i.getAlgo(hash)
+	pss, err := pssOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	algo, err := i.getAlgo(hash, pss != nil)
 	if err != nil {
 		return nil, err
 	}
@@ -305,8 +322,165 @@ func (i *macIdentity) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts
 	return sig, nil
 }
 
+// Decrypt implements the crypto.Decrypter interface.
+func (i *macIdentity) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	crt, err := i.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	if _, isRSA := crt.PublicKey.(*rsa.PublicKey); !isRSA {
+		return nil, errors.New("only RSA keys can decrypt")
+	}
+
+	var (
+		algo          C.SecKeyAlgorithm
+		sessionKeyLen int
+	)
+
+	switch o := opts.(type) {
+	case nil:
+		algo = C.kSecKeyAlgorithmRSAEncryptionPKCS1
+	case *rsa.PKCS1v15DecryptOptions:
+		algo = C.kSecKeyAlgorithmRSAEncryptionPKCS1
+		sessionKeyLen = o.SessionKeyLen
+	case *rsa.OAEPOptions:
+		// The Security framework doesn't support labels or different hashes
+		// for OAEP and MGF1.
+		if len(o.Label) > 0 || (o.MGFHash != 0 && o.MGFHash != o.Hash) {
+			return nil, ErrUnsupportedDecrypterOpts
+		}
+
+		switch o.Hash {
+		case crypto.SHA1:
+			algo = C.kSecKeyAlgorithmRSAEncryptionOAEPSHA1
+		case crypto.SHA256:
+			algo = C.kSecKeyAlgorithmRSAEncryptionOAEPSHA256
+		case crypto.SHA384:
+			algo = C.kSecKeyAlgorithmRSAEncryptionOAEPSHA384
+		case crypto.SHA512:
+			algo = C.kSecKeyAlgorithmRSAEncryptionOAEPSHA512
+		default:
+			return nil, ErrUnsupportedHash
+		}
+	default:
+		return nil, ErrUnsupportedDecrypterOpts
+	}
+
+	kref, err := i.getKeyRef()
+	if err != nil {
+		return nil, err
+	}
+
+	cmsg, err := bytesToCFData(msg)
+	if err != nil {
+		return nil, err
+	}
+	defer C.CFRelease(C.CFTypeRef(cmsg))
+
+	var cerr C.CFErrorRef
+	cplain := C.SecKeyCreateDecryptedData(kref, algo, cmsg, &cerr)
+
+	if err := cfErrorError(cerr); err != nil {
+		defer C.CFRelease(C.CFTypeRef(cerr))
+
+		// Like rsa.DecryptPKCS1v15SessionKey, don't reveal padding errors.
+		if sessionKeyLen > 0 {
+			return randomSessionKey(rand, sessionKeyLen)
+		}
+
+		return nil, err
+	}
+
+	if cplain == nilCFDataRef {
+		return nil, errors.New("nil plaintext from SecKeyCreateDecryptedData")
+	}
+
+	defer C.CFRelease(C.CFTypeRef(cplain))
+
+	plain := cfDataToBytes(cplain)
+
+	if sessionKeyLen > 0 && len(plain) != sessionKeyLen {
+		return randomSessionKey(rand, sessionKeyLen)
+	}
+
+	return plain, nil
+}
+
+// ECDH implements the KeyAgreer interface.
+func (i *macIdentity) ECDH(remote *ecdh.PublicKey) ([]byte, error) {
+	crt, err := i.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	if _, isEC := crt.PublicKey.(*ecdsa.PublicKey); !isEC {
+		return nil, errors.New("only EC keys can do key agreement")
+	}
+
+	kref, err := i.getKeyRef()
+	if err != nil {
+		return nil, err
+	}
+
+	// Import the remote public key from its uncompressed point.
+	cpub, err := bytesToCFData(remote.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer C.CFRelease(C.CFTypeRef(cpub))
+
+	attrs := mapToCFDictionary(map[C.CFTypeRef]C.CFTypeRef{
+		C.CFTypeRef(C.kSecAttrKeyType):  C.CFTypeRef(C.kSecAttrKeyTypeECSECPrimeRandom),
+		C.CFTypeRef(C.kSecAttrKeyClass): C.CFTypeRef(C.kSecAttrKeyClassPublic),
+	})
+	if attrs == nilCFDictionaryRef {
+		return nil, errors.New("error creating CFDictionary")
+	}
+	defer C.CFRelease(C.CFTypeRef(attrs))
+
+	var cerr C.CFErrorRef
+	pubRef := C.SecKeyCreateWithData(cpub, attrs, &cerr)
+
+	if err := cfErrorError(cerr); err != nil {
+		defer C.CFRelease(C.CFTypeRef(cerr))
+
+		return nil, err
+	}
+
+	if pubRef == nilSecKeyRef {
+		return nil, errors.New("nil key from SecKeyCreateWithData")
+	}
+
+	defer C.CFRelease(C.CFTypeRef(pubRef))
+
+	// The standard algorithm returns the raw shared secret and takes no
+	// parameters.
+	params := C.CFDictionaryCreate(nilCFAllocatorRef, nil, nil, 0, nil, nil)
+	if params == nilCFDictionaryRef {
+		return nil, errors.New("error creating CFDictionary")
+	}
+	defer C.CFRelease(C.CFTypeRef(params))
+
+	csecret := C.SecKeyCopyKeyExchangeResult(kref, C.kSecKeyAlgorithmECDHKeyExchangeStandard, pubRef, params, &cerr)
+
+	if err := cfErrorError(cerr); err != nil {
+		defer C.CFRelease(C.CFTypeRef(cerr))
+
+		return nil, err
+	}
+
+	if csecret == nilCFDataRef {
+		return nil, errors.New("nil secret from SecKeyCopyKeyExchangeResult")
+	}
+
+	defer C.CFRelease(C.CFTypeRef(csecret))
+
+	return cfDataToBytes(csecret), nil
+}
+
 // getAlgo decides which algorithm to use with this key type for the given hash.
-func (i *macIdentity) getAlgo(hash crypto.Hash) (algo C.SecKeyAlgorithm, err error) {
+// RSA keys use RSASSA-PSS if pss is set, which the Security framework always
+// does with a salt as long as the digest.
+func (i *macIdentity) getAlgo(hash crypto.Hash, pss bool) (algo C.SecKeyAlgorithm, err error) {
 	var crt *x509.Certificate
 	if crt, err = i.Certificate(); err != nil {
 		return
@@ -314,6 +488,11 @@ func (i *macIdentity) getAlgo(hash crypto.Hash) (algo C.SecKeyAlgorithm, err err
 
 	switch crt.PublicKey.(type) {
 	case *ecdsa.PublicKey:
+		if pss {
+			err = ErrUnsupportedSignerOpts
+			return
+		}
+
 		switch hash {
 		case crypto.SHA1:
 			algo = C.kSecKeyAlgorithmECDSASignatureDigestX962SHA1
@@ -327,6 +506,22 @@ func (i *macIdentity) getAlgo(hash crypto.Hash) (algo C.SecKeyAlgorithm, err err
 			err = ErrUnsupportedHash
 		}
 	case *rsa.PublicKey:
+		if pss {
+			switch hash {
+			case crypto.SHA1:
+				algo = C.kSecKeyAlgorithmRSASignatureDigestPSSSHA1
+			case crypto.SHA256:
+				algo = C.kSecKeyAlgorithmRSASignatureDigestPSSSHA256
+			case crypto.SHA384:
+				algo = C.kSecKeyAlgorithmRSASignatureDigestPSSSHA384
+			case crypto.SHA512:
+				algo = C.kSecKeyAlgorithmRSASignatureDigestPSSSHA512
+			default:
+				err = ErrUnsupportedHash
+			}
+			return
+		}
+
 		switch hash {
 		case crypto.SHA1:
 			algo = C.kSecKeyAlgorithmRSASignatureDigestPKCS1v15SHA1
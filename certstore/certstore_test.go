@@ -1,7 +1,9 @@
 package certstore
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
@@ -254,6 +256,88 @@ func TestSignerECDSA(t *testing.T) {
 	})
 }
 
+func TestDecrypterRSA(t *testing.T) {
+	withIdentity(t, leafRSA, func(ident Identity) {
+		decrypter, err := ident.Decrypter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// PKCS #1 v1.5
+		ct, err := rsa.EncryptPKCS1v15(rand.Reader, &leafKeyRSA.PublicKey, []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pt, err := decrypter.Decrypt(rand.Reader, ct, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(pt) != "hello" {
+			t.Fatalf("bad plaintext. Got %q, expected %q", pt, "hello")
+		}
+
+		// PKCS #1 v1.5 session key with bad padding
+		pt, err = decrypter.Decrypt(rand.Reader, ct[1:], &rsa.PKCS1v15DecryptOptions{SessionKeyLen: 16})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pt) != 16 {
+			t.Fatalf("bad session key length. Got %d, expected 16", len(pt))
+		}
+
+		// OAEP with SHA-256
+		ct, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, &leafKeyRSA.PublicKey, []byte("hello"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pt, err = decrypter.Decrypt(rand.Reader, ct, &rsa.OAEPOptions{Hash: crypto.SHA256})
+		if err == ErrUnsupportedDecrypterOpts || err == ErrUnsupportedHash {
+			// CryptoAPI only supports OAEP with SHA-1. Pass...
+		} else if err != nil {
+			t.Fatal(err)
+		} else if string(pt) != "hello" {
+			t.Fatalf("bad plaintext. Got %q, expected %q", pt, "hello")
+		}
+	})
+}
+
+func TestDecrypterEC(t *testing.T) {
+	withIdentity(t, leafEC, func(ident Identity) {
+		decrypter, err := ident.Decrypter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ka, ok := decrypter.(KeyAgreer)
+		if !ok {
+			t.Fatal("expected EC decrypter to implement KeyAgreer")
+		}
+
+		remote, err := ecdh.P256().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := ka.ECDH(remote.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		local, err := leafKeyEC.ECDH()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := remote.ECDH(local.PublicKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(secret, expected) {
+			t.Fatalf("bad shared secret. Got %x, expected %x", secret, expected)
+		}
+	})
+}
+
 func TestCertificateRSA(t *testing.T) {
 	CertificateHelper(t, leafRSA)
 }
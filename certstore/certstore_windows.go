@@ -32,10 +32,12 @@ import "C"
 
 import (
 	"crypto"
+	"crypto/ecdh"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/asn1"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"math/big"
@@ -246,6 +248,11 @@ func (i *winIdentity) Signer() (crypto.Signer, error) {
 	return i.getPrivateKey()
 }
 
+// Decrypter implements the Identity interface.
+func (i *winIdentity) Decrypter() (crypto.Decrypter, error) {
+	return i.getPrivateKey()
+}
+
 // getPrivateKey gets this identity's private *winPrivateKey.
 func (i *winIdentity) getPrivateKey() (*winPrivateKey, error) {
 	if i.signer != nil {
@@ -358,17 +365,27 @@ func (wpk *winPrivateKey) Public() crypto.PublicKey {
 
 // Sign implements the crypto.Signer interface.
 func (wpk *winPrivateKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	pss, err := pssOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
 	if wpk.capiProv != 0 {
+		// CryptoAPI can't make RSA-PSS signatures.
+		if pss != nil {
+			return nil, ErrUnsupportedSignerOpts
+		}
 		return wpk.capiSignHash(opts.HashFunc(), digest)
 	} else if wpk.cngHandle != 0 {
-		return wpk.cngSignHash(opts.HashFunc(), digest)
+		return wpk.cngSignHash(opts.HashFunc(), digest, pss != nil)
 	} else {
 		return nil, errors.New("bad private key")
 	}
 }
 
-// cngSignHash signs a digest using the CNG APIs.
-func (wpk *winPrivateKey) cngSignHash(hash crypto.Hash, digest []byte) ([]byte, error) {
+// cngSignHash signs a digest using the CNG APIs. RSA keys use RSASSA-PSS with a
+// salt as long as the digest if pss is set.
+func (wpk *winPrivateKey) cngSignHash(hash crypto.Hash, digest []byte, pss bool) ([]byte, error) {
 	if len(digest) != hash.Size() {
 		return nil, errors.New("bad digest for hash")
 	}
@@ -384,26 +401,41 @@ func (wpk *winPrivateKey) cngSignHash(hash crypto.Hash, digest []byte) ([]byte,
 		sigLen = C.DWORD(0)
 	)
 
-	// setup pkcs1v1.5 padding for RSA
-	if _, isRSA := wpk.publicKey.(*rsa.PublicKey); isRSA {
-		flags |= C.BCRYPT_PAD_PKCS1
-		padInfo := C.BCRYPT_PKCS1_PADDING_INFO{}
-		padPtr = unsafe.Pointer(&padInfo)
+	_, isRSA := wpk.publicKey.(*rsa.PublicKey)
+	if pss && !isRSA {
+		return nil, ErrUnsupportedSignerOpts
+	}
 
+	var algID C.LPCWSTR
+	if isRSA {
 		switch hash {
 		case crypto.SHA1:
-			padInfo.pszAlgId = BCRYPT_SHA1_ALGORITHM
+			algID = BCRYPT_SHA1_ALGORITHM
 		case crypto.SHA256:
-			padInfo.pszAlgId = BCRYPT_SHA256_ALGORITHM
+			algID = BCRYPT_SHA256_ALGORITHM
 		case crypto.SHA384:
-			padInfo.pszAlgId = BCRYPT_SHA384_ALGORITHM
+			algID = BCRYPT_SHA384_ALGORITHM
 		case crypto.SHA512:
-			padInfo.pszAlgId = BCRYPT_SHA512_ALGORITHM
+			algID = BCRYPT_SHA512_ALGORITHM
 		default:
 			return nil, ErrUnsupportedHash
 		}
 	}
 
+	// setup pss or pkcs1v1.5 padding for RSA
+	if isRSA && pss {
+		flags |= C.BCRYPT_PAD_PSS
+		padInfo := C.BCRYPT_PSS_PADDING_INFO{}
+		padPtr = unsafe.Pointer(&padInfo)
+		padInfo.pszAlgId = algID
+		padInfo.cbSalt = C.ULONG(hash.Size())
+	} else if isRSA {
+		flags |= C.BCRYPT_PAD_PKCS1
+		padInfo := C.BCRYPT_PKCS1_PADDING_INFO{}
+		padPtr = unsafe.Pointer(&padInfo)
+		padInfo.pszAlgId = algID
+	}
+
 	// get signature length
 	if err := checkStatus(C.NCryptSignHash(wpk.cngHandle, padPtr, digestPtr, digestLen, nil, 0, &sigLen, flags)); err != nil {
 		return nil, errors.Wrap(err, "failed to get signature length")
@@ -521,6 +553,227 @@ func (wpk *winPrivateKey) capiSignHash(hash crypto.Hash, digest []byte) ([]byte,
 	return sig, nil
 }
 
+// Decrypt implements the crypto.Decrypter interface.
+func (wpk *winPrivateKey) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if _, isRSA := wpk.publicKey.(*rsa.PublicKey); !isRSA {
+		return nil, errors.New("only RSA keys can decrypt")
+	}
+	if len(msg) == 0 {
+		return nil, errors.New("empty ciphertext")
+	}
+
+	var (
+		sessionKeyLen int
+		oaep          *rsa.OAEPOptions
+	)
+
+	switch o := opts.(type) {
+	case nil:
+	case *rsa.PKCS1v15DecryptOptions:
+		sessionKeyLen = o.SessionKeyLen
+	case *rsa.OAEPOptions:
+		if o.MGFHash != 0 && o.MGFHash != o.Hash {
+			return nil, ErrUnsupportedDecrypterOpts
+		}
+		oaep = o
+	default:
+		return nil, ErrUnsupportedDecrypterOpts
+	}
+
+	var (
+		plain []byte
+		err   error
+	)
+
+	if wpk.capiProv != 0 {
+		plain, err = wpk.capiDecrypt(msg, oaep)
+	} else if wpk.cngHandle != 0 {
+		plain, err = wpk.cngDecrypt(msg, oaep)
+	} else {
+		return nil, errors.New("bad private key")
+	}
+
+	// Like rsa.DecryptPKCS1v15SessionKey, don't reveal padding errors.
+	if sessionKeyLen > 0 && (err != nil || len(plain) != sessionKeyLen) {
+		return randomSessionKey(rand, sessionKeyLen)
+	}
+
+	return plain, err
+}
+
+// cngDecrypt decrypts a message using the CNG APIs.
+func (wpk *winPrivateKey) cngDecrypt(msg []byte, oaep *rsa.OAEPOptions) ([]byte, error) {
+	var (
+		// input
+		padPtr = unsafe.Pointer(nil)
+		msgPtr = (*C.BYTE)(&msg[0])
+		msgLen = C.DWORD(len(msg))
+		flags  = C.DWORD(C.NCRYPT_PAD_PKCS1_FLAG)
+
+		// output
+		plainLen = C.DWORD(0)
+	)
+
+	// setup OAEP padding
+	if oaep != nil {
+		flags = C.NCRYPT_PAD_OAEP_FLAG
+		padInfo := C.BCRYPT_OAEP_PADDING_INFO{}
+		padPtr = unsafe.Pointer(&padInfo)
+
+		switch oaep.Hash {
+		case crypto.SHA1:
+			padInfo.pszAlgId = BCRYPT_SHA1_ALGORITHM
+		case crypto.SHA256:
+			padInfo.pszAlgId = BCRYPT_SHA256_ALGORITHM
+		case crypto.SHA384:
+			padInfo.pszAlgId = BCRYPT_SHA384_ALGORITHM
+		case crypto.SHA512:
+			padInfo.pszAlgId = BCRYPT_SHA512_ALGORITHM
+		default:
+			return nil, ErrUnsupportedHash
+		}
+
+		// The label is copied to C memory, since cgo doesn't allow passing Go
+		// pointers inside the padding info.
+		if len(oaep.Label) > 0 {
+			label := C.CBytes(oaep.Label)
+			defer C.free(label)
+
+			padInfo.pbLabel = (*C.UCHAR)(label)
+			padInfo.cbLabel = C.ULONG(len(oaep.Label))
+		}
+	}
+
+	// get plaintext length
+	if err := checkStatus(C.NCryptDecrypt(wpk.cngHandle, msgPtr, msgLen, padPtr, nil, 0, &plainLen, flags)); err != nil {
+		return nil, errors.Wrap(err, "failed to get plaintext length")
+	}
+
+	// get plaintext
+	plain := make([]byte, plainLen)
+	plainPtr := (*C.BYTE)(&plain[0])
+	if err := checkStatus(C.NCryptDecrypt(wpk.cngHandle, msgPtr, msgLen, padPtr, plainPtr, plainLen, &plainLen, flags)); err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt message")
+	}
+
+	return plain[:plainLen], nil
+}
+
+// capiDecrypt decrypts a message using the CryptoAPI APIs.
+func (wpk *winPrivateKey) capiDecrypt(msg []byte, oaep *rsa.OAEPOptions) ([]byte, error) {
+	var flags C.DWORD
+
+	// CryptoAPI only supports OAEP with SHA-1 and no label.
+	if oaep != nil {
+		if oaep.Hash != crypto.SHA1 || len(oaep.Label) > 0 {
+			return nil, ErrUnsupportedDecrypterOpts
+		}
+		flags = C.CRYPT_OAEP
+	}
+
+	var key C.HCRYPTKEY
+	if ok := C.CryptGetUserKey(wpk.capiProv, wpk.keySpec, &key); ok == winFalse {
+		return nil, lastError("failed to get private key")
+	}
+	defer C.CryptDestroyKey(key)
+
+	// Ciphertext is big endian, but CryptoAPI wants little endian. Reverse it.
+	buf := make([]byte, len(msg))
+	for i := range msg {
+		buf[i] = msg[len(msg)-1-i]
+	}
+
+	var (
+		bufPtr = (*C.BYTE)(&buf[0])
+		bufLen = C.DWORD(len(buf))
+	)
+
+	if ok := C.CryptDecrypt(key, 0, winTrue, flags, bufPtr, &bufLen); ok == winFalse {
+		return nil, lastError("failed to decrypt message")
+	}
+
+	return buf[:bufLen], nil
+}
+
+// ECDH implements the KeyAgreer interface.
+func (wpk *winPrivateKey) ECDH(remote *ecdh.PublicKey) ([]byte, error) {
+	if _, isEC := wpk.publicKey.(*ecdsa.PublicKey); !isEC {
+		return nil, errors.New("only EC keys can do key agreement")
+	}
+	if wpk.cngHandle == 0 {
+		return nil, errors.New("key agreement requires a CNG key")
+	}
+
+	var magic uint32
+	switch remote.Curve() {
+	case ecdh.P256():
+		magic = C.BCRYPT_ECDH_PUBLIC_P256_MAGIC
+	case ecdh.P384():
+		magic = C.BCRYPT_ECDH_PUBLIC_P384_MAGIC
+	case ecdh.P521():
+		magic = C.BCRYPT_ECDH_PUBLIC_P521_MAGIC
+	default:
+		return nil, errors.New("unsupported curve")
+	}
+
+	// Build a BCRYPT_ECCKEY_BLOB from the uncompressed point. The header is
+	// followed by the X and Y coordinates.
+	var (
+		point = remote.Bytes()
+		size  = (len(point) - 1) / 2
+		blob  = make([]byte, 8+2*size)
+	)
+
+	binary.LittleEndian.PutUint32(blob[0:], magic)
+	binary.LittleEndian.PutUint32(blob[4:], uint32(size))
+	copy(blob[8:], point[1:])
+
+	// Import the remote public key into the private key's provider.
+	var (
+		prov    C.NCRYPT_PROV_HANDLE
+		provPtr = (*C.BYTE)(unsafe.Pointer(&prov))
+		provLen = C.DWORD(unsafe.Sizeof(prov))
+	)
+
+	if err := checkStatus(C.NCryptGetProperty(C.NCRYPT_HANDLE(wpk.cngHandle), NCRYPT_PROVIDER_HANDLE_PROPERTY, provPtr, provLen, &provLen, 0)); err != nil {
+		return nil, errors.Wrap(err, "failed to get key provider")
+	}
+	defer C.NCryptFreeObject(C.NCRYPT_HANDLE(prov))
+
+	var pub C.NCRYPT_KEY_HANDLE
+	if err := checkStatus(C.NCryptImportKey(prov, 0, BCRYPT_ECCPUBLIC_BLOB, nil, &pub, (*C.BYTE)(&blob[0]), C.DWORD(len(blob)), 0)); err != nil {
+		return nil, errors.Wrap(err, "failed to import public key")
+	}
+	defer C.NCryptFreeObject(C.NCRYPT_HANDLE(pub))
+
+	var secret C.NCRYPT_SECRET_HANDLE
+	if err := checkStatus(C.NCryptSecretAgreement(wpk.cngHandle, pub, &secret, 0)); err != nil {
+		return nil, errors.Wrap(err, "failed to agree on secret")
+	}
+	defer C.NCryptFreeObject(C.NCRYPT_HANDLE(secret))
+
+	// get secret length
+	var secretLen C.DWORD
+	if err := checkStatus(C.NCryptDeriveKey(secret, BCRYPT_KDF_RAW_SECRET, nil, nil, 0, &secretLen, 0)); err != nil {
+		return nil, errors.Wrap(err, "failed to get secret length")
+	}
+
+	// get secret
+	out := make([]byte, secretLen)
+	if err := checkStatus(C.NCryptDeriveKey(secret, BCRYPT_KDF_RAW_SECRET, nil, (*C.BYTE)(&out[0]), secretLen, &secretLen, 0)); err != nil {
+		return nil, errors.Wrap(err, "failed to get secret")
+	}
+	out = out[:secretLen]
+
+	// Secret is little endian, but we want big endian. Reverse it.
+	for i := len(out)/2 - 1; i >= 0; i-- {
+		opp := len(out) - 1 - i
+		out[i], out[opp] = out[opp], out[i]
+	}
+
+	return out, nil
+}
+
 func (wpk *winPrivateKey) Delete() error {
 	if wpk.cngHandle != 0 {
 		// Delete CNG key
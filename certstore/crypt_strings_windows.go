@@ -114,6 +114,13 @@ LPCWSTR GET_BCRYPT_PBKDF2_ALGORITHM() { return BCRYPT_PBKDF2_ALGORITHM; }
 LPCWSTR GET_BCRYPT_ECDSA_ALGORITHM() { return BCRYPT_ECDSA_ALGORITHM; }
 LPCWSTR GET_BCRYPT_ECDH_ALGORITHM() { return BCRYPT_ECDH_ALGORITHM; }
 LPCWSTR GET_BCRYPT_XTS_AES_ALGORITHM() { return BCRYPT_XTS_AES_ALGORITHM; }
+
+// BCRYPT KDF Names
+#ifndef BCRYPT_KDF_RAW_SECRET
+#define BCRYPT_KDF_RAW_SECRET L"TRUNCATE"
+#endif
+
+LPCWSTR GET_BCRYPT_KDF_RAW_SECRET() { return BCRYPT_KDF_RAW_SECRET; }
 */
 import "C"
 
@@ -204,4 +211,7 @@ var (
 	BCRYPT_ECDSA_ALGORITHM             = C.GET_BCRYPT_ECDSA_ALGORITHM()
 	BCRYPT_ECDH_ALGORITHM              = C.GET_BCRYPT_ECDH_ALGORITHM()
 	BCRYPT_XTS_AES_ALGORITHM           = C.GET_BCRYPT_XTS_AES_ALGORITHM()
+
+	// BCRYPT KDF Names
+	BCRYPT_KDF_RAW_SECRET = C.GET_BCRYPT_KDF_RAW_SECRET()
 )
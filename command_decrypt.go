@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/github/smimesign/certstore"
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/pkg/errors"
+)
+
+func commandDecrypt() (err error) {
+	failure := gpgErrGeneral
+	defer func() {
+		if err != nil {
+			emitFailure("decrypt", failure)
+		}
+	}()
+
+	var f io.ReadCloser
+	if len(fileArgs) == 1 {
+		if f, err = os.Open(fileArgs[0]); err != nil {
+			return errors.Wrapf(err, "failed to open message file (%s)", fileArgs[0])
+		}
+		defer f.Close()
+	} else {
+		f = stdin
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err = io.Copy(buf, f); err != nil {
+		return errors.Wrap(err, "failed to read message")
+	}
+
+	ed, err := cms.ParseEnvelopedData(derFromMaybePEM(buf.Bytes()))
+	if err != nil {
+		failure = gpgErrNoData
+		return errors.Wrap(err, "failed to parse encrypted message")
+	}
+
+	ident, err := findRecipientIdentity(ed)
+	if err != nil {
+		failure = gpgErrNoSecKey
+		return err
+	}
+
+	cert, err := ident.Certificate()
+	if err != nil {
+		return errors.Wrap(err, "failed to get idenity certificate")
+	}
+
+	decrypter, err := ident.Decrypter()
+	if err != nil {
+		sNoSecKey.emitf("%s", certHexFingerprint(cert))
+		failure = gpgErrNoSecKey
+		return errors.Wrap(err, "failed to get idenity decrypter")
+	}
+
+	sBeginDecryption.emit()
+	defer sEndDecryption.emit()
+
+	plaintext, err := ed.Decrypt(cert, decrypter)
+	if err != nil {
+		sDecryptionFailed.emit()
+		failure = gpgErrDecryptFailed
+		return errors.Wrap(err, "failed to decrypt message")
+	}
+	sDecryptionOkay.emit()
+
+	if _, err = stdout.Write(plaintext); err != nil {
+		return errors.New("failed to write decrypted message")
+	}
+
+	return nil
+}
+
+// findRecipientIdentity finds an identity in the certstore that the message was
+// encrypted to.
+func findRecipientIdentity(ed *cms.EnvelopedData) (certstore.Identity, error) {
+	for _, ident := range idents {
+		cert, err := ident.Certificate()
+		if err != nil {
+			debugf(debugBasic, "skipping identity whose certificate can't be loaded: %s", err)
+			continue
+		}
+
+		if ed.IsRecipient(cert) {
+			logf("decrypting with certificate \"%s\" (0x%s)", cert.Subject.String(), certHexFingerprint(cert))
+			return ident, nil
+		}
+	}
+
+	return nil, errors.New("message isn't encrypted to any identity in the certificate store")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+func commandEncrypt() (err error) {
+	failure := gpgErrGeneral
+	defer func() {
+		if err != nil {
+			emitFailure("encrypt", failure)
+		}
+	}()
+
+	recipients, err := findRecipientCerts()
+	if err != nil {
+		failure = gpgErrNoPubKey
+		return err
+	}
+
+	var f io.ReadCloser
+	if len(fileArgs) == 1 {
+		if f, err = os.Open(fileArgs[0]); err != nil {
+			return errors.Wrapf(err, "failed to open message file (%s)", fileArgs[0])
+		}
+		defer f.Close()
+	} else {
+		f = stdin
+	}
+
+	dataBuf := new(bytes.Buffer)
+	if _, err = io.Copy(dataBuf, f); err != nil {
+		return errors.Wrap(err, "failed to read message")
+	}
+
+	// smimesign always uses AES-256 and doesn't add an MDC.
+	sBeginEncryption.emitf("0 %d", packet.CipherAES256)
+
+	der, err := cms.EncryptWithOptions(dataBuf.Bytes(), recipients, cms.EncryptOptions{OAEP: *rsaOAEPFlag})
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt message")
+	}
+
+	if *armorFlag {
+		err = pem.Encode(stdout, &pem.Block{
+			Type:  "ENCRYPTED MESSAGE",
+			Bytes: der,
+		})
+	} else {
+		_, err = stdout.Write(der)
+	}
+	if err != nil {
+		return errors.New("failed to write encrypted message")
+	}
+
+	sEndEncryption.emit()
+
+	return nil
+}
+
+// findRecipientCerts finds a certificate for each of the --recipient user-ids
+// among the certificates of the identities in the certstore and those loaded
+// from --recipient-certs.
+func findRecipientCerts() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	for _, ident := range idents {
+		cert, err := ident.Certificate()
+		if err != nil {
+			debugf(debugBasic, "skipping identity whose certificate can't be loaded: %s", err)
+			continue
+		}
+
+		certs = append(certs, cert)
+	}
+
+	extra, err := loadCerts(*recipientCertsOpt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load recipient certificates")
+	}
+	certs = append(certs, extra...)
+
+	var recipients []*x509.Certificate
+
+	for _, userID := range *recipientOpt {
+		// getopt gives us an empty element for an unset list option.
+		if len(userID) == 0 {
+			continue
+		}
+
+		var (
+			email string
+			fpr   []byte
+		)
+
+		if strings.ContainsRune(userID, '@') {
+			email = normalizeEmail(userID)
+		} else {
+			fpr = normalizeFingerprint(userID)
+		}
+
+		if len(email) == 0 && len(fpr) == 0 {
+			emitInvRecp(invSgnrSyntaxError, userID)
+			return nil, fmt.Errorf("bad user-id format: %s", userID)
+		}
+
+		var found *x509.Certificate
+		for _, cert := range certs {
+			if certHasEmail(cert, email) || certHasFingerprint(cert, fpr) {
+				found = cert
+				break
+			}
+		}
+
+		if found == nil {
+			emitInvRecp(invSgnrNotFound, userID)
+			return nil, fmt.Errorf("could not find certificate matching recipient: %s", userID)
+		}
+
+		logf("encrypting to certificate \"%s\" (0x%s) for recipient %s", found.Subject.String(), certHexFingerprint(found), userID)
+		recipients = append(recipients, found)
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("specify a recipient to encrypt to")
+	}
+
+	return recipients, nil
+}
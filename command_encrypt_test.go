@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/github/smimesign/certstore"
+	"github.com/github/smimesign/fakeca"
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecLeaf := identity{intermediate.Issue(fakeca.PrivateKey(ecKey))}
+
+	for _, tc := range []struct {
+		name  string
+		ident identity
+		args  []string
+	}{
+		{"rsa", wrappedLeaf, nil},
+		{"rsa-oaep", wrappedLeaf, []string{"--rsa-oaep"}},
+		{"rsa-armor", wrappedLeaf, []string{"--armor"}},
+		{"ec", ecLeaf, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fpr := certHexFingerprint(tc.ident.Identity.Certificate)
+
+			var ciphertext []byte
+			func() {
+				defer testSetup(t, append([]string{"--encrypt", "-r", fpr}, tc.args...)...)()
+				idents = append(idents, ecLeaf)
+
+				stdinBuf.WriteString("hello, world!")
+				require.NoError(t, commandEncrypt())
+				ciphertext = append(ciphertext, stdoutBuf.Bytes()...)
+			}()
+
+			ed, err := cms.ParseEnvelopedData(derFromMaybePEM(ciphertext))
+			require.NoError(t, err)
+			require.True(t, ed.IsRecipient(tc.ident.Identity.Certificate))
+
+			defer testSetup(t, "--decrypt")()
+			idents = append(idents, ecLeaf)
+			read, reset := captureStatus(t)
+			defer reset()
+
+			stdinBuf.Write(ciphertext)
+			require.NoError(t, commandDecrypt())
+			require.Equal(t, "hello, world!", stdoutBuf.String())
+			require.Equal(t, ""+
+				"[GNUPG:] BEGIN_DECRYPTION\n"+
+				"[GNUPG:] DECRYPTION_OKAY\n"+
+				"[GNUPG:] END_DECRYPTION\n",
+				read())
+		})
+	}
+}
+
+func TestEncryptRecipientCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	other := intermediate.Issue()
+	certsFile := filepath.Join(dir, "certs.pem")
+	require.NoError(t, ioutil.WriteFile(certsFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: other.Certificate.Raw,
+	}), 0644))
+
+	defer testSetup(t, "--encrypt", "-r", certHexFingerprint(other.Certificate), "--recipient-certs", certsFile)()
+	read, reset := captureStatus(t)
+	defer reset()
+
+	stdinBuf.WriteString("hello, world!")
+	require.NoError(t, commandEncrypt())
+	require.Equal(t, ""+
+		"[GNUPG:] BEGIN_ENCRYPTION 0 9\n"+
+		"[GNUPG:] END_ENCRYPTION\n",
+		read())
+
+	ed, err := cms.ParseEnvelopedData(stdoutBuf.Bytes())
+	require.NoError(t, err)
+	require.True(t, ed.IsRecipient(other.Certificate))
+	require.False(t, ed.IsRecipient(leaf.Certificate))
+
+	data, err := ed.Decrypt(other.Certificate, other.PrivateKey)
+	require.NoError(t, err)
+	require.Equal(t, "hello, world!", string(data))
+}
+
+func TestEncryptStatus(t *testing.T) {
+	func() {
+		defer testSetup(t, "--encrypt", "-r", "nobody@example.com")()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		require.Error(t, commandEncrypt())
+		require.Equal(t, ""+
+			"[GNUPG:] INV_RECP 1 nobody@example.com\n"+
+			"[GNUPG:] FAILURE encrypt 50331657\n",
+			read())
+	}()
+
+	func() {
+		defer testSetup(t, "--encrypt", "-r", "zz")()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		require.Error(t, commandEncrypt())
+		require.Equal(t, ""+
+			"[GNUPG:] INV_RECP 14 zz\n"+
+			"[GNUPG:] FAILURE encrypt 50331657\n",
+			read())
+	}()
+}
+
+func TestDecryptStatus(t *testing.T) {
+	other := intermediate.Issue()
+	der, err := cms.Encrypt([]byte("hello, world!"), []*x509.Certificate{other.Certificate})
+	require.NoError(t, err)
+
+	func() {
+		defer testSetup(t, "--decrypt")()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		stdinBuf.Write(der)
+		require.Error(t, commandDecrypt())
+		require.Equal(t, "[GNUPG:] FAILURE decrypt 50331665\n", read())
+	}()
+
+	func() {
+		defer testSetup(t, "--decrypt")()
+		idents = []certstore.Identity{noKeyIdentity{identity{other}}}
+		read, reset := captureStatus(t)
+		defer reset()
+
+		stdinBuf.Write(der)
+		require.Error(t, commandDecrypt())
+		require.Equal(t, ""+
+			"[GNUPG:] NO_SECKEY "+certHexFingerprint(other.Certificate)+"\n"+
+			"[GNUPG:] FAILURE decrypt 50331665\n",
+			read())
+	}()
+
+	func() {
+		defer testSetup(t, "--decrypt")()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		stdinBuf.WriteString("not a message")
+		require.Error(t, commandDecrypt())
+		require.Equal(t, "[GNUPG:] FAILURE decrypt 50331706\n", read())
+	}()
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"fmt"
 	"io"
@@ -14,29 +17,102 @@ import (
 	"github.com/pkg/errors"
 )
 
-func commandSign() error {
+// signingHashes are the digest algorithms that every certstore backend can sign
+// with. The macOS keychain and the Windows certificate store reject other
+// digests, such as SHA-224 and SHA-3.
+var signingHashes = []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+// signingDigestAlgorithm gets the digest algorithm named by --digest-algo,
+// checking that it can be used for signing.
+func signingDigestAlgorithm(name string) (crypto.Hash, error) {
+	hash, ok := digestAlgorithms[strings.ToLower(name)]
+	if !ok {
+		return 0, errors.Errorf("unknown digest algorithm %q", name)
+	}
+
+	// Don't make signatures that verification would reject by default.
+	var allowed bool
+	for _, h := range cms.DefaultAlgorithmPolicy.Hashes {
+		allowed = allowed || h == hash
+	}
+	if !allowed {
+		return 0, errors.Errorf("digest algorithm %q is not allowed", name)
+	}
+
+	for _, h := range signingHashes {
+		if h == hash {
+			return hash, nil
+		}
+	}
+
+	return 0, errors.Errorf("digest algorithm %q is not supported for signing", name)
+}
+
+func commandSign() (err error) {
+	// Report failures to status-fd consumers, who otherwise wouldn't see
+	// anything after BEGIN_SIGNING.
+	failure := gpgErrGeneral
+	defer func() {
+		if err != nil {
+			emitFailure("sign", failure)
+		}
+	}()
+
 	userIdent, err := findUserIdentity()
 	if err != nil {
+		emitInvSgnr(invSgnrSyntaxError)
+		failure = gpgErrInvUserID
 		return errors.Wrap(err, "failed to get identity matching specified user-id")
 	}
 	if userIdent == nil {
+		emitInvSgnr(invSgnrNotFound)
+		failure = gpgErrNotFound
 		return fmt.Errorf("could not find identity matching specified user-id: %s", *localUserOpt)
 	}
 
-	// Git is looking for "\n[GNUPG:] SIG_CREATED ", meaning we need to print a
-	// line before SIG_CREATED. BEGIN_SIGNING seems appropraite. GPG emits this,
-	// though GPGSM does not.
-	sBeginSigning.emit()
-
 	cert, err := userIdent.Certificate()
 	if err != nil {
+		emitInvSgnr(invSgnrMissingCert)
+		failure = gpgErrMissingCert
 		return errors.Wrap(err, "failed to get idenity certificate")
 	}
 
 	signer, err := userIdent.Signer()
 	if err != nil {
+		emitKeyConsidered(cert, false)
+		sNoSecKey.emitf("%s", certHexFingerprint(cert))
+		emitInvSgnr(invSgnrNoSecretKey)
+		failure = gpgErrNoSecKey
 		return errors.Wrap(err, "failed to get idenity signer")
 	}
+	emitKeyConsidered(cert, true)
+
+	if *rsaPSSFlag {
+		if cert.PublicKeyAlgorithm != x509.RSA {
+			emitInvSgnr(invSgnrWrongKeyUsage)
+			failure = gpgErrWrongPubKeyAlgo
+			return errors.New("rsa-pss requires an RSA signing key")
+		}
+		signer = cms.RSAPSSSigner(signer)
+	}
+
+	if len(*digestAlgoOpt) > 0 {
+		hash, err := signingDigestAlgorithm(*digestAlgoOpt)
+		if err != nil {
+			emitInvSgnr(invSgnrNoReason)
+			failure = gpgErrDigestAlgo
+			return err
+		}
+
+		signer = cms.DigestSigner(signer, hash)
+	}
+
+	// Git is looking for "\n[GNUPG:] SIG_CREATED ", meaning we need to print a
+	// line before SIG_CREATED. BEGIN_SIGNING seems appropraite. GPG emits this,
+	// though GPGSM does not.
+	sBeginSigning.emit()
+
+	warnUsage(cert)
 
 	var f io.ReadCloser
 	if len(fileArgs) == 1 {
@@ -48,35 +124,53 @@ func commandSign() error {
 		f = stdin
 	}
 
-	dataBuf := new(bytes.Buffer)
-	if _, err = io.Copy(dataBuf, f); err != nil {
-		return errors.Wrap(err, "failed to read message from stdin")
-	}
-
-	sd, err := cms.NewSignedData(dataBuf.Bytes())
+	chain, err := userIdent.CertificateChain()
 	if err != nil {
-		return errors.Wrap(err, "failed to create signed data")
+		return errors.Wrap(err, "failed to get idenity certificate chain")
 	}
-	if err = sd.Sign([]*x509.Certificate{cert}, signer); err != nil {
-		return errors.Wrap(err, "failed to sign message")
+	if chain, err = certsForSignature(chain); err != nil {
+		return err
+	}
+	debugf(debugBasic, "including %d certificates in signature", len(chain))
+
+	// Timestamps are requested for a finished signature, so only attached
+	// signatures without one can be streamed.
+	if !*detachSignFlag && len(*tsaOpt) == 0 {
+		return signStream(cert, chain, signer, f)
 	}
+
+	var sd *cms.SignedData
+
 	if *detachSignFlag {
-		sd.Detached()
+		// Detached signatures are streamed, so that signing large files doesn't
+		// need as much memory.
+		if sd, err = cms.NewDetachedSignedData(); err != nil {
+			return errors.Wrap(err, "failed to create signed data")
+		}
+		if err = sd.SignReader(f, []*x509.Certificate{cert}, signer); err != nil {
+			return errors.Wrap(err, "failed to sign message")
+		}
+	} else {
+		dataBuf := new(bytes.Buffer)
+		if _, err = io.Copy(dataBuf, f); err != nil {
+			return errors.Wrap(err, "failed to read message from stdin")
+		}
+
+		if sd, err = cms.NewSignedData(dataBuf.Bytes()); err != nil {
+			return errors.Wrap(err, "failed to create signed data")
+		}
+		if err = sd.Sign([]*x509.Certificate{cert}, signer); err != nil {
+			return errors.Wrap(err, "failed to sign message")
+		}
 	}
 
 	if len(*tsaOpt) > 0 {
+		logf("requesting timestamp from %s", *tsaOpt)
 		if err = sd.AddTimestamps(*tsaOpt); err != nil {
 			return errors.Wrap(err, "failed to add timestamp")
 		}
 	}
 
-	chain, err := userIdent.CertificateChain()
-	if err != nil {
-		return errors.Wrap(err, "failed to get idenity certificate chain")
-	}
-	if chain, err = certsForSignature(chain); err != nil {
-		return err
-	}
 	if err = sd.SetCertificates(chain); err != nil {
 		return errors.Wrap(err, "failed to set certificates")
 	}
@@ -86,7 +180,12 @@ func commandSign() error {
 		return errors.Wrap(err, "failed to serialize signature")
 	}
 
-	emitSigCreated(cert, *detachSignFlag)
+	// Record the signature before it's written out, so that every signature
+	// that leaves smimesign is in the audit log.
+	signatureDigest := sha256.Sum256(der)
+	if err = finishSignature(cert, sd, signatureDigest[:]); err != nil {
+		return err
+	}
 
 	if *armorFlag {
 		err = pem.Encode(stdout, &pem.Block{
@@ -103,6 +202,92 @@ func commandSign() error {
 	return nil
 }
 
+// signStream makes an attached signature over message, writing it out as BER
+// while message is read, so that large messages aren't held in memory. The
+// end of the signature, which holds the signer's signature, is held back until
+// it has been recorded in the audit log.
+func signStream(cert *x509.Certificate, chain []*x509.Certificate, signer crypto.Signer, message io.Reader) error {
+	sd, err := cms.NewDetachedSignedData()
+	if err != nil {
+		return errors.Wrap(err, "failed to create signed data")
+	}
+
+	var (
+		out   io.Writer = stdout
+		armor io.WriteCloser
+	)
+	if *armorFlag {
+		armor = newArmorWriter(stdout, "SIGNED MESSAGE")
+		out = armor
+	}
+
+	var (
+		held            = &holdWriter{w: out}
+		signatureDigest = sha256.New()
+	)
+
+	err = sd.SignStream(io.MultiWriter(held, signatureDigest), message, []*x509.Certificate{cert}, signer, func() error {
+		held.hold = true
+		return sd.SetCertificates(chain)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to sign message")
+	}
+
+	if err = finishSignature(cert, sd, signatureDigest.Sum(nil)); err != nil {
+		return err
+	}
+
+	if err = held.release(); err == nil && armor != nil {
+		err = armor.Close()
+	}
+	if err != nil {
+		return errors.New("failed to write signature")
+	}
+
+	return nil
+}
+
+// finishSignature records a signature made by cert in the audit log and emits
+// SIG_CREATED. signatureDigest is the SHA-256 digest of the encoded signature.
+func finishSignature(cert *x509.Certificate, sd *cms.SignedData, signatureDigest []byte) error {
+	si := sd.GetSignerInfos()[0]
+
+	rec, err := newAuditRecord(cert, signatureDigest, si, *detachSignFlag, *tsaOpt)
+	if err != nil {
+		return errors.Wrap(err, "failed to create audit record")
+	}
+	if err = writeAuditRecord(rec); err != nil {
+		return errors.Wrap(err, "failed to write audit log")
+	}
+
+	emitSigCreated(cert, si, *detachSignFlag)
+
+	return nil
+}
+
+// holdWriter writes to w until hold is set, then buffers writes until release
+// is called.
+type holdWriter struct {
+	w    io.Writer
+	buf  bytes.Buffer
+	hold bool
+}
+
+func (hw *holdWriter) Write(p []byte) (int, error) {
+	if hw.hold {
+		return hw.buf.Write(p)
+	}
+
+	return hw.w.Write(p)
+}
+
+// release writes out everything written since hold was set.
+func (hw *holdWriter) release() error {
+	_, err := hw.w.Write(hw.buf.Bytes())
+	return err
+}
+
 // findUserIdentity attempts to find an identity to sign with in the certstore
 // by checking available identities against the --local-user argument.
 func findUserIdentity() (certstore.Identity, error) {
@@ -122,11 +307,21 @@ func findUserIdentity() (certstore.Identity, error) {
 	}
 
 	for _, ident := range idents {
-		if cert, err := ident.Certificate(); err == nil && (certHasEmail(cert, email) || certHasFingerprint(cert, fpr)) {
+		cert, err := ident.Certificate()
+		if err != nil {
+			debugf(debugBasic, "skipping identity whose certificate can't be loaded: %s", err)
+			continue
+		}
+
+		if certHasEmail(cert, email) || certHasFingerprint(cert, fpr) {
+			logf("using certificate \"%s\" (0x%s) for user-id %s", cert.Subject.String(), certHexFingerprint(cert), *localUserOpt)
 			return ident, nil
 		}
+
+		debugf(debugAdvanced, "certificate \"%s\" (0x%s) doesn't match user-id %s", cert.Subject.String(), certHexFingerprint(cert), *localUserOpt)
 	}
 
+	logf("no certificate matches user-id %s", *localUserOpt)
 	return nil, nil
 }
 
@@ -179,3 +374,99 @@ func chainWithoutRoot(chain []*x509.Certificate) []*x509.Certificate {
 
 	return chain
 }
+
+// armorWriter PEM encodes what is written to it as a single block of the given
+// type, like pem.Encode, without holding the block in memory.
+type armorWriter struct {
+	w       io.Writer
+	typ     string
+	lines   *lineBreaker
+	encoder io.WriteCloser
+	started bool
+}
+
+func newArmorWriter(w io.Writer, typ string) *armorWriter {
+	lines := &lineBreaker{w: w}
+	return &armorWriter{
+		w:       w,
+		typ:     typ,
+		lines:   lines,
+		encoder: base64.NewEncoder(base64.StdEncoding, lines),
+	}
+}
+
+func (aw *armorWriter) Write(p []byte) (int, error) {
+	if !aw.started {
+		if _, err := fmt.Fprintf(aw.w, "-----BEGIN %s-----\n", aw.typ); err != nil {
+			return 0, err
+		}
+		aw.started = true
+	}
+
+	return aw.encoder.Write(p)
+}
+
+// Close finishes the PEM block. It doesn't close the underlying writer.
+func (aw *armorWriter) Close() error {
+	if !aw.started {
+		if _, err := aw.Write(nil); err != nil {
+			return err
+		}
+	}
+	if err := aw.encoder.Close(); err != nil {
+		return err
+	}
+	if err := aw.lines.Close(); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(aw.w, "-----END %s-----\n", aw.typ)
+	return err
+}
+
+// pemLineLength is the length of the base64 lines written by pem.Encode.
+const pemLineLength = 64
+
+// lineBreaker breaks what is written to it into lines of pemLineLength bytes.
+type lineBreaker struct {
+	w    io.Writer
+	used int
+}
+
+func (lb *lineBreaker) Write(p []byte) (int, error) {
+	var n int
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > pemLineLength-lb.used {
+			chunk = chunk[:pemLineLength-lb.used]
+		}
+
+		m, err := lb.w.Write(chunk)
+		n += m
+		if err != nil {
+			return n, err
+		}
+
+		p = p[len(chunk):]
+		if lb.used += len(chunk); lb.used == pemLineLength {
+			if _, err := lb.w.Write([]byte{'\n'}); err != nil {
+				return n, err
+			}
+			lb.used = 0
+		}
+	}
+
+	return n, nil
+}
+
+// Close ends the last line, if it isn't empty.
+func (lb *lineBreaker) Close() error {
+	if lb.used == 0 {
+		return nil
+	}
+
+	lb.used = 0
+	_, err := lb.w.Write([]byte{'\n'})
+	return err
+}
@@ -1,9 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/github/smimesign/certstore"
+	"github.com/github/smimesign/fakeca"
 	cms "github.com/github/smimesign/ietf-cms"
 	"github.com/github/smimesign/ietf-cms/protocol"
 	"github.com/stretchr/testify/require"
@@ -30,6 +44,82 @@ func TestSign(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestSignDetached(t *testing.T) {
+	defer testSetup(t, "--sign", "--detach-sign", "-u", certHexFingerprint(leaf.Certificate))()
+
+	stdinBuf.WriteString("hello, world!")
+	require.NoError(t, commandSign())
+	sd, err := cms.ParseSignedData(stdoutBuf.Bytes())
+	require.NoError(t, err)
+	require.True(t, sd.IsDetached())
+
+	_, err = sd.VerifyDetached([]byte("hello, world!"), x509.VerifyOptions{Roots: ca.ChainPool()})
+	require.NoError(t, err)
+}
+
+// streamingReader reads n copies of chunk, checking that the signature has
+// been written out as the message was read.
+type streamingReader struct {
+	t     *testing.T
+	chunk []byte
+	n     int
+	read  int
+}
+
+func (r *streamingReader) Read(p []byte) (int, error) {
+	if r.read == r.n*len(r.chunk) {
+		require.True(r.t, stdoutBuf.Len() > r.read/2, "message wasn't streamed")
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.chunk[r.read%len(r.chunk):])
+	r.read += n
+	return n, nil
+}
+
+func TestSignStream(t *testing.T) {
+	chunk := []byte(strings.Repeat("hello, world!\n", 100))
+
+	for _, armor := range []bool{false, true} {
+		func() {
+			args := []string{"--sign", "-u", certHexFingerprint(leaf.Certificate)}
+			if armor {
+				args = append(args, "--armor")
+			}
+			defer testSetup(t, args...)()
+			stdin = ioutil.NopCloser(&streamingReader{t: t, chunk: chunk, n: 100})
+
+			require.NoError(t, commandSign())
+
+			ber := stdoutBuf.Bytes()
+			if armor {
+				blk, rest := pem.Decode(ber)
+				require.NotNil(t, blk)
+				require.Equal(t, "SIGNED MESSAGE", blk.Type)
+				require.Empty(t, rest)
+				require.Equal(t, string(pem.EncodeToMemory(blk)), stdoutBuf.String())
+				ber = blk.Bytes
+			}
+
+			// Streamed signatures use BER indefinite lengths.
+			require.Equal(t, []byte{0x30, 0x80}, ber[:2])
+
+			sd, err := cms.ParseSignedData(ber)
+			require.NoError(t, err)
+			content, err := sd.GetData()
+			require.NoError(t, err)
+			require.Equal(t, bytes.Repeat(chunk, 100), content)
+
+			certs, err := sd.GetCertificates()
+			require.NoError(t, err)
+			require.Equal(t, 2, len(certs))
+
+			_, err = sd.Verify(x509.VerifyOptions{Roots: ca.ChainPool()})
+			require.NoError(t, err)
+		}()
+	}
+}
+
 func TestSignIncludeCertsAIA(t *testing.T) {
 	defer testSetup(t, "--sign", "-u", certHexFingerprint(aiaLeaf.Certificate))()
 
@@ -229,3 +319,212 @@ func TestSignIncludeCerts4(t *testing.T) {
 	require.True(t, chainContains(certs, intermediate.Certificate))
 	require.True(t, chainContains(certs, ca.Certificate))
 }
+
+// noKeyIdentity is an identity whose private key isn't available.
+type noKeyIdentity struct {
+	identity
+}
+
+func (i noKeyIdentity) Signer() (crypto.Signer, error) {
+	return nil, errors.New("no private key")
+}
+
+func (i noKeyIdentity) Decrypter() (crypto.Decrypter, error) {
+	return nil, errors.New("no private key")
+}
+
+// requireSigCreated checks a SIG_CREATED status line, allowing for its
+// creation time.
+func requireSigCreated(t *testing.T, line, sigType, pkAlgo, hashAlgo, fpr string) {
+	t.Helper()
+
+	fields := strings.Fields(line)
+	require.Equal(t, 8, len(fields))
+	created, err := strconv.ParseInt(fields[6], 10, 64)
+	require.NoError(t, err)
+	require.InDelta(t, time.Now().Unix(), created, 60)
+	require.Equal(t, "[GNUPG:] SIG_CREATED "+sigType+" "+pkAlgo+" "+hashAlgo+" 00 "+fields[6]+" "+fpr, line)
+}
+
+func TestSignStatus(t *testing.T) {
+	fpr := certHexFingerprint(leaf.Certificate)
+
+	func() {
+		defer testSetup(t, "--sign", "-u", fpr)()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		stdinBuf.WriteString("hello, world!")
+		require.NoError(t, commandSign())
+
+		lines := strings.Split(strings.TrimSpace(read()), "\n")
+		require.Equal(t, 3, len(lines))
+		require.Equal(t, "[GNUPG:] KEY_CONSIDERED "+fpr+" 0", lines[0])
+		require.Equal(t, "[GNUPG:] BEGIN_SIGNING", lines[1])
+		requireSigCreated(t, lines[2], "S", "1", "8", fpr)
+	}()
+
+	// The algorithms are those of the signature, not of the issuer's signature
+	// on the certificate.
+	func() {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		ecLeaf := identity{intermediate.Issue(fakeca.PrivateKey(ecKey))}
+		require.Equal(t, x509.RSA, intermediate.Certificate.PublicKeyAlgorithm)
+		ecFpr := certHexFingerprint(ecLeaf.Identity.Certificate)
+
+		defer testSetup(t, "--sign", "--detach-sign", "-u", ecFpr)()
+		idents = append(idents, ecLeaf)
+		read, reset := captureStatus(t)
+		defer reset()
+
+		stdinBuf.WriteString("hello, world!")
+		require.NoError(t, commandSign())
+
+		lines := strings.Split(strings.TrimSpace(read()), "\n")
+		require.Equal(t, 3, len(lines))
+		requireSigCreated(t, lines[2], "D", "19", "8", ecFpr)
+	}()
+
+	func() {
+		defer testSetup(t, "--sign", "--digest-algo", "sha384", "-u", fpr)()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		stdinBuf.WriteString("hello, world!")
+		require.NoError(t, commandSign())
+
+		lines := strings.Split(strings.TrimSpace(read()), "\n")
+		require.Equal(t, 3, len(lines))
+		requireSigCreated(t, lines[2], "S", "1", "9", fpr)
+	}()
+
+	func() {
+		defer testSetup(t, "--sign", "-u", "nobody@example.com")()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		require.Error(t, commandSign())
+		require.Equal(t, ""+
+			"[GNUPG:] INV_SGNR 1 nobody@example.com\n"+
+			"[GNUPG:] FAILURE sign 50331675\n",
+			read())
+	}()
+
+	func() {
+		defer testSetup(t, "--sign", "-u", "zz")()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		require.Error(t, commandSign())
+		require.Equal(t, ""+
+			"[GNUPG:] INV_SGNR 14 zz\n"+
+			"[GNUPG:] FAILURE sign 50331685\n",
+			read())
+	}()
+
+	func() {
+		defer testSetup(t, "--sign", "-u", fpr)()
+		idents = []certstore.Identity{noKeyIdentity{wrappedLeaf}}
+		read, reset := captureStatus(t)
+		defer reset()
+
+		require.Error(t, commandSign())
+		require.Equal(t, ""+
+			"[GNUPG:] KEY_CONSIDERED "+fpr+" 1\n"+
+			"[GNUPG:] NO_SECKEY "+fpr+"\n"+
+			"[GNUPG:] INV_SGNR 9 "+fpr+"\n"+
+			"[GNUPG:] FAILURE sign 50331665\n",
+			read())
+	}()
+}
+
+func TestSignRSAPSS(t *testing.T) {
+	defer testSetup(t, "--sign", "--detach-sign", "--rsa-pss", "-u", certHexFingerprint(leaf.Certificate))()
+
+	stdinBuf.WriteString("hello, world!")
+	require.NoError(t, commandSign())
+	sd, err := cms.ParseSignedData(stdoutBuf.Bytes())
+	require.NoError(t, err)
+
+	_, err = sd.VerifyDetached([]byte("hello, world!"), x509.VerifyOptions{Roots: ca.ChainPool()})
+	require.NoError(t, err)
+
+	require.Equal(t, x509.SHA256WithRSAPSS, sd.GetSignerInfos()[0].X509SignatureAlgorithm())
+}
+
+func TestSignRSAPSSStatus(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecLeaf := identity{intermediate.Issue(fakeca.PrivateKey(ecKey))}
+	fpr := certHexFingerprint(ecLeaf.Identity.Certificate)
+
+	defer testSetup(t, "--sign", "--rsa-pss", "-u", fpr)()
+	idents = append(idents, ecLeaf)
+	read, reset := captureStatus(t)
+	defer reset()
+
+	stdinBuf.WriteString("hello, world!")
+	require.Error(t, commandSign())
+	require.Equal(t, ""+
+		"[GNUPG:] KEY_CONSIDERED "+fpr+" 0\n"+
+		"[GNUPG:] INV_SGNR 3 "+fpr+"\n"+
+		"[GNUPG:] FAILURE sign 50331689\n",
+		read())
+}
+
+func TestSignDigestAlgo(t *testing.T) {
+	func() {
+		defer testSetup(t, "--sign", "--digest-algo", "SHA512", "-u", certHexFingerprint(leaf.Certificate))()
+
+		stdinBuf.WriteString("hello, world!")
+		require.NoError(t, commandSign())
+		sd, err := cms.ParseSignedData(stdoutBuf.Bytes())
+		require.NoError(t, err)
+
+		_, err = sd.Verify(x509.VerifyOptions{Roots: ca.ChainPool()})
+		require.NoError(t, err)
+
+		hash, err := sd.GetSignerInfos()[0].Hash()
+		require.NoError(t, err)
+		require.Equal(t, crypto.SHA512, hash)
+	}()
+
+	// Digests that the keychain and certificate store can't sign are rejected
+	// before signing starts.
+	for _, name := range []string{"sha224", "sha512-256", "sha3-256"} {
+		func() {
+			fpr := certHexFingerprint(leaf.Certificate)
+			defer testSetup(t, "--sign", "--digest-algo", name, "-u", fpr)()
+			read, reset := captureStatus(t)
+			defer reset()
+
+			stdinBuf.WriteString("hello, world!")
+			require.EqualError(t, commandSign(), `digest algorithm "`+name+`" is not supported for signing`)
+			require.Equal(t, 0, stdoutBuf.Len())
+			require.Equal(t, ""+
+				"[GNUPG:] KEY_CONSIDERED "+fpr+" 0\n"+
+				"[GNUPG:] INV_SGNR 0 "+fpr+"\n"+
+				"[GNUPG:] FAILURE sign 50331653\n",
+				read())
+		}()
+	}
+
+	func() {
+		defer testSetup(t, "--sign", "--digest-algo", "md4", "-u", certHexFingerprint(leaf.Certificate))()
+
+		stdinBuf.WriteString("hello, world!")
+		require.EqualError(t, commandSign(), `unknown digest algorithm "md4"`)
+	}()
+
+	// Digests rejected by the default algorithm policy aren't used for signing.
+	for _, name := range []string{"md5", "SHA1"} {
+		func() {
+			defer testSetup(t, "--sign", "--digest-algo", name, "-u", certHexFingerprint(leaf.Certificate))()
+
+			stdinBuf.WriteString("hello, world!")
+			require.EqualError(t, commandSign(), `digest algorithm "`+name+`" is not allowed`)
+			require.Equal(t, 0, stdoutBuf.Len())
+		}()
+	}
+}
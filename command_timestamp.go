@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io"
+	"os"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/pkg/errors"
+)
+
+// commandTimestamp reads an existing signature, adds RFC3161 timestamps to any
+// of its SignerInfos that don't have one yet and writes out the result.
+func commandTimestamp() error {
+	var (
+		f   io.ReadCloser
+		err error
+	)
+
+	// Read in signature
+	if len(fileArgs) == 1 {
+		if f, err = os.Open(fileArgs[0]); err != nil {
+			return errors.Wrapf(err, "failed to open signature file (%s)", fileArgs[0])
+		}
+		defer f.Close()
+	} else {
+		f = stdin
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err = io.Copy(buf, f); err != nil {
+		return errors.Wrap(err, "failed to read signature")
+	}
+
+	// Try decoding as PEM
+	var der []byte
+	if blk, _ := pem.Decode(buf.Bytes()); blk != nil {
+		der = blk.Bytes
+	} else {
+		der = buf.Bytes()
+	}
+
+	// Parse signature
+	sd, err := cms.ParseSignedData(der)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse signature")
+	}
+
+	logf("requesting timestamp from %s", *tsaOpt)
+	if err = sd.AddTimestamps(*tsaOpt); err != nil {
+		return errors.Wrap(err, "failed to add timestamp")
+	}
+
+	if der, err = sd.ToDER(); err != nil {
+		return errors.Wrap(err, "failed to serialize signature")
+	}
+
+	if *armorFlag {
+		err = pem.Encode(stdout, &pem.Block{
+			Type:  "SIGNED MESSAGE",
+			Bytes: der,
+		})
+	} else {
+		_, err = stdout.Write(der)
+	}
+	if err != nil {
+		return errors.New("failed to write signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	"github.com/github/smimesign/ietf-cms/timestamp"
+	"github.com/pborman/getopt/v2"
+	"github.com/stretchr/testify/require"
+)
+
+var tsaIdent = intermediate.Issue()
+
+// testTSA is a timestamp.HTTPClient that answers timestamp requests itself.
+type testTSA struct{}
+
+func (testTSA) Do(httpReq *http.Request) (*http.Response, error) {
+	buf := new(bytes.Buffer)
+	if _, err := io.Copy(buf, httpReq.Body); err != nil {
+		return nil, err
+	}
+
+	var req timestamp.Request
+	if _, err := asn1.Unmarshal(buf.Bytes(), &req); err != nil {
+		return nil, err
+	}
+
+	infoDER, err := asn1.Marshal(timestamp.Info{
+		Version:        1,
+		Policy:         asn1.ObjectIdentifier{1, 2, 3},
+		SerialNumber:   big.NewInt(1),
+		GenTime:        time.Now(),
+		MessageImprint: req.MessageImprint,
+		Nonce:          req.Nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	eci, err := protocol.NewEncapsulatedContentInfo(oid.ContentTypeTSTInfo, infoDER)
+	if err != nil {
+		return nil, err
+	}
+
+	tst, err := protocol.NewSignedData(eci)
+	if err != nil {
+		return nil, err
+	}
+	if err = tst.AddSignerInfo(tsaIdent.Chain(), tsaIdent.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	ci, err := tst.ContentInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	respDER, err := asn1.Marshal(timestamp.Response{
+		Status:         timestamp.PKIStatusInfo{Status: 0},
+		TimeStampToken: ci,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"application/timestamp-reply"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(respDER)),
+	}, nil
+}
+
+func TestTimestamp(t *testing.T) {
+	timestamp.DefaultHTTPClient = testTSA{}
+	defer func() { timestamp.DefaultHTTPClient = http.DefaultClient }()
+
+	defer testSetup(t, "--sign", "-u", certHexFingerprint(leaf.Certificate))()
+
+	stdinBuf.WriteString("hello, world!")
+	require.NoError(t, commandSign())
+	sig := append([]byte(nil), stdoutBuf.Bytes()...)
+
+	resetIO()
+	getopt.Reset()
+	getopt.CommandLine.Parse([]string{"smimesign", "--add-timestamp", "-t", "http://tsa.example"})
+
+	stdinBuf.Write(sig)
+	require.NoError(t, commandTimestamp())
+
+	sd, err := cms.ParseSignedData(stdoutBuf.Bytes())
+	require.NoError(t, err)
+
+	_, err = sd.Verify(x509.VerifyOptions{Roots: ca.ChainPool()})
+	require.NoError(t, err)
+
+	ci, err := protocol.ParseContentInfo(stdoutBuf.Bytes())
+	require.NoError(t, err)
+
+	psd, err := ci.SignedDataContent()
+	require.NoError(t, err)
+	require.True(t, psd.SignerInfos[0].UnsignedAttrs.HasAttribute(oid.AttributeTimeStampToken))
+}
@@ -7,9 +7,13 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/certifi/gocertifi"
 	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/github/smimesign/ietf-cms/protocol"
 	"github.com/pkg/errors"
 )
 
@@ -58,34 +62,12 @@ func verifyAttached() error {
 		return errors.Wrap(err, "failed to parse signature")
 	}
 
-	// Verify signature
-	chains, err := sd.Verify(verifyOpts())
+	econtent, err := sd.GetData()
 	if err != nil {
-		if len(chains) > 0 {
-			emitBadSig(chains)
-		} else {
-			// TODO: We're omitting a bunch of arguments here.
-			sErrSig.emit()
-		}
-
-		return errors.Wrap(err, "failed to verify signature")
+		return errors.Wrap(err, "failed to get signed data")
 	}
 
-	var (
-		cert = chains[0][0][0]
-		fpr  = certHexFingerprint(cert)
-		subj = cert.Subject.String()
-	)
-
-	fmt.Fprintf(stderr, "smimesign: Signature made using certificate ID 0x%s\n", fpr)
-	emitGoodSig(chains)
-
-	// TODO: Maybe split up signature checking and certificate checking so we can
-	// output something more meaningful.
-	fmt.Fprintf(stderr, "smimesign: Good signature from \"%s\"\n", subj)
-	emitTrustFully()
-
-	return nil
+	return verifySignedData(sd, econtent, nil)
 }
 
 func verifyDetached() error {
@@ -119,71 +101,443 @@ func verifyDetached() error {
 		return errors.Wrap(err, "failed to parse signature")
 	}
 
+	if !sd.IsDetached() {
+		err = errors.New("signature not detached")
+		emitErrSig(sd, 0, err)
+		return errors.Wrap(err, "failed to verify signature")
+	}
+
 	// Read in signed data
 	if fileArgs[1] == "-" {
 		f = stdin
 	} else {
 		if f, err = os.Open(fileArgs[1]); err != nil {
-			errors.Wrapf(err, "failed to open message file (%s)", fileArgs[1])
+			return errors.Wrapf(err, "failed to open message file (%s)", fileArgs[1])
 		}
 		defer f.Close()
 	}
 
-	// Verify signature
-	buf.Reset()
-	if _, err = io.Copy(buf, f); err != nil {
+	// Signers without signed attributes signed the message itself rather than
+	// its digest, so the whole message is needed to check their signatures.
+	for _, si := range sd.GetSignerInfos() {
+		if si.SignedAttrs == nil {
+			buf.Reset()
+			if _, err = io.Copy(buf, f); err != nil {
+				return errors.Wrap(err, "failed to read message file")
+			}
+
+			return verifySignedData(sd, buf.Bytes(), nil)
+		}
+	}
+
+	// The message is streamed, so that large files can be verified without
+	// holding them in memory. Only its beginning is kept, for checking the
+	// committer or tagger of git payloads.
+	payload := &prefixBuffer{max: maxPayloadHeader}
+	digests, err := sd.DigestDetached(io.TeeReader(f, payload))
+	if err != nil {
 		return errors.Wrap(err, "failed to read message file")
 	}
 
-	chains, err := sd.VerifyDetached(buf.Bytes(), verifyOpts())
+	return verifySignedData(sd, payload.Bytes(), digests)
+}
+
+// verifySignedData checks each signature over message and then validates the
+// signer's certificate, emitting a block of status lines for each signer. A
+// good signature is reported with GOODSIG even if the certificate turns out to
+// be invalid, with a TRUST_ line giving the certificate's validity, so
+// frontends can tell a forged signature from an untrusted one. payload is the
+// signed content, or the beginning of it for streamed detached signatures.
+// digests are the digests of the content of streamed detached signatures, or
+// nil if payload is the whole content. --require-signers determines how many
+// signers must be valid.
+func verifySignedData(sd *cms.SignedData, payload []byte, digests cms.MessageDigests) error {
+	v, err := newVerifier()
+	if err != nil {
+		return err
+	}
+
+	_, err = v.verify(sd, payload, digests)
+	return err
+}
+
+// verifier holds the options for verifying signatures, so that they can be
+// shared by every signature verified by a command.
+type verifier struct {
+	allowed  allowedSigners
+	policy   *cms.AlgorithmPolicy
+	opts     x509.VerifyOptions
+	required int
+}
+
+// newVerifier builds a verifier from the command line options.
+func newVerifier() (*verifier, error) {
+	allowed, err := allowedSignersFromOpt()
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := algorithmPolicyFromOpt()
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := verifyOpts()
+	if err != nil {
+		return nil, err
+	}
+
+	required, err := requiredSignersFromOpt()
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifier{
+		allowed:  allowed,
+		policy:   policy,
+		opts:     opts,
+		required: required,
+	}, nil
+}
+
+// verify is like verifySignedData, but returns the certificates of the
+// distinct valid signers.
+func (v *verifier) verify(sd *cms.SignedData, payload []byte, digests cms.MessageDigests) ([]*x509.Certificate, error) {
+	sd.Policy = v.policy
+
+	// Intermediates fetched for this signature aren't used for others.
+	opts := v.opts
+	opts.Intermediates = opts.Intermediates.Clone()
+
+	signerInfos := sd.GetSignerInfos()
+	if len(signerInfos) == 0 {
+		err := protocol.ASN1Error{Message: "no signatures found"}
+		emitErrSig(sd, 0, err)
+		return nil, errors.Wrap(err, "failed to verify signature")
+	}
+
+	// Only download intermediates once we've found a good signature.
+	var fetched bool
+	fetch := func() {
+		if !fetched {
+			fetchIntermediates(sd, opts)
+			fetched = true
+		}
+	}
+
+	var (
+		firstErr error
+		valid    []*x509.Certificate
+		seen     = map[string]bool{}
+	)
+
+	for i := range signerInfos {
+		// NEWSIG was already emitted for the first signer.
+		if i > 0 {
+			sNewSig.emit()
+		}
+
+		logf("checking signature %d of %d", i+1, len(signerInfos))
+		cert, err := verifySigner(sd, i, payload, digests, opts, v.allowed, fetch)
+		if err != nil {
+			logf("signature %d is not valid: %s", i+1, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if fpr := certHexFingerprint(cert); !seen[fpr] {
+			seen[fpr] = true
+			valid = append(valid, cert)
+		}
+	}
+
+	debugf(debugBasic, "%d distinct valid signers, --require-signers=%s", len(valid), *requireSignersOpt)
+
+	if v.required == requireAllSigners {
+		return valid, firstErr
+	}
+
+	if len(valid) < v.required {
+		// A lone signer's own error says more than the count of valid signers.
+		if len(signerInfos) == 1 && firstErr != nil {
+			return valid, firstErr
+		}
+		return valid, fmt.Errorf("only %d of %d signers are valid, but %d are required", len(valid), len(signerInfos), v.required)
+	}
+
+	return valid, nil
+}
+
+// verifySigner checks the signature of the i'th signer and then validates its
+// certificate, emitting status lines for the signer. The signer's certificate
+// is returned if the signature is good and the certificate is valid. fetch is
+// called to download missing intermediates once the signature is known to be
+// good.
+func verifySigner(sd *cms.SignedData, i int, payload []byte, digests cms.MessageDigests, opts x509.VerifyOptions, allowed allowedSigners, fetch func()) (*x509.Certificate, error) {
+	// Check the signature itself. Attached signatures are checked against
+	// their encapsulated content.
+	var (
+		cert *x509.Certificate
+		err  error
+	)
+	switch {
+	case digests != nil:
+		cert, err = sd.CheckSignerDigests(i, digests)
+	case sd.IsDetached():
+		cert, err = sd.CheckSignerSignature(i, payload)
+	default:
+		cert, err = sd.CheckSignerSignature(i, nil)
+	}
 	if err != nil {
-		if len(chains) > 0 {
-			emitBadSig(chains)
+		if bse, ok := err.(cms.BadSignatureError); ok {
+			fmt.Fprintf(stderr, "smimesign: Bad signature from \"%s\"\n", bse.Cert.Subject.String())
+			emitBadSig(bse.Cert)
 		} else {
-			// TODO: We're omitting a bunch of arguments here.
-			sErrSig.emit()
+			emitErrSig(sd, i, err)
 		}
 
-		return errors.Wrap(err, "failed to verify signature")
+		return nil, errors.Wrap(err, "failed to verify signature")
 	}
 
 	var (
-		cert = chains[0][0][0]
 		fpr  = certHexFingerprint(cert)
 		subj = cert.Subject.String()
 	)
 
 	fmt.Fprintf(stderr, "smimesign: Signature made using certificate ID 0x%s\n", fpr)
-	emitGoodSig(chains)
 
-	// TODO: Maybe split up signature checking and certificate checking so we can
-	// output something more meaningful.
+	fetch()
+
+	si := sd.GetSignerInfos()[i]
+	signingTime := signatureTime(sd, i, opts)
+	emitSigID(si, signingTime)
+
+	// Then check the signer's certificate.
+	debugf(debugBasic, "verifying certificate chain of \"%s\"", subj)
+	chains, err := sd.VerifySignerChains(i, opts)
+	for _, chain := range chains {
+		debugf(debugAdvanced, "found chain: %s", chainString(chain))
+	}
+
+	issuer := signerIssuer(sd, cert, chains)
+	validSig := func() { emitValidSig(cert, issuer, si, signingTime) }
+
+	if err != nil {
+		if isExpired(err) {
+			fmt.Fprintf(stderr, "smimesign: Good signature from \"%s\", but the certificate has expired\n", subj)
+			emitExpKeySig(cert)
+			validSig()
+			return nil, errors.Wrap(err, "failed to verify certificate")
+		}
+
+		fmt.Fprintf(stderr, "smimesign: Good signature from \"%s\", but the certificate is not valid: %s\n", subj, err)
+		emitGoodSig(cert)
+		validSig()
+		if _, ok := err.(x509.UnknownAuthorityError); ok {
+			emitTrustUndefined("not_trusted")
+		} else {
+			emitTrustNever("bad_certificate_chain")
+		}
+
+		return nil, errors.Wrap(err, "failed to verify certificate")
+	}
+
+	if err = checkKeyUsage(cert); err != nil {
+		fmt.Fprintf(stderr, "smimesign: Good signature from \"%s\", but the certificate can't be used for signing: %s\n", subj, err)
+		emitGoodSig(cert)
+		validSig()
+		emitTrustNever("wrong_key_usage")
+		return nil, errors.Wrap(err, "failed to verify certificate")
+	}
+
+	debugf(debugBasic, "checking revocation of \"%s\"", subj)
+	var revocationUnknown bool
+	if err = checkSignerRevocation(sd, i, chains, opts); err == errRevocationUnknown {
+		revocationUnknown = true
+	} else if err != nil {
+		if isRevoked(err) {
+			fmt.Fprintf(stderr, "smimesign: Signature made by revoked certificate \"%s\": %s\n", subj, err)
+			emitRevKeySig(cert)
+			validSig()
+		} else {
+			fmt.Fprintf(stderr, "smimesign: Good signature from \"%s\", but revocation checking failed: %s\n", subj, err)
+			emitGoodSig(cert)
+			validSig()
+			emitTrustUndefined("revocation_check_failed")
+		}
+
+		return nil, errors.Wrap(err, "failed to check revocation")
+	}
+
+	emitGoodSig(cert)
+	validSig()
 	fmt.Fprintf(stderr, "smimesign: Good signature from \"%s\"\n", subj)
-	emitTrustFully()
 
-	return nil
+	// Validity windows are only checked at a verified timestamp, because the
+	// signer chooses the signing time attribute and could backdate it.
+	allowedTime, _ := sd.SignerTimestamp(i, opts)
+
+	debugf(debugBasic, "checking that \"%s\" is an allowed signer", subj)
+	if err = checkSigner(payload, chains, allowed, allowedTime); err != nil {
+		fmt.Fprintf(stderr, "smimesign: Signer \"%s\" is not allowed: %s\n", subj, err)
+		emitTrustNever(trustNeverToken(err))
+		return nil, err
+	}
+
+	if revocationUnknown {
+		emitTrustMarginal()
+	} else {
+		emitTrustFully()
+	}
+
+	return cert, nil
+}
+
+// chainString describes a certificate chain for logging.
+func chainString(chain []*x509.Certificate) string {
+	subjects := make([]string, len(chain))
+	for i, cert := range chain {
+		subjects[i] = fmt.Sprintf("\"%s\"", cert.Subject.String())
+	}
+
+	return strings.Join(subjects, " -> ")
+}
+
+// requireAllSigners is returned by requiredSignersFromOpt if every signer must
+// be valid.
+const requireAllSigners = -1
+
+// requiredSignersFromOpt parses --require-signers, giving the number of
+// distinct valid signers a signature needs. Signers that aren't allowed by
+// --allowed-signers aren't valid, so this also gives the number of signers
+// needed from the allowed set.
+func requiredSignersFromOpt() (int, error) {
+	switch *requireSignersOpt {
+	case "all":
+		return requireAllSigners, nil
+	case "any":
+		return 1, nil
+	}
+
+	n, err := strconv.Atoi(*requireSignersOpt)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("bad number of required signers: %s", *requireSignersOpt)
+	}
+
+	return n, nil
+}
+
+// signatureTime gets the time at which the i'th signature was made, preferring
+// its verified timestamp over its signing time attribute. The zero time is
+// returned if neither is available.
+func signatureTime(sd *cms.SignedData, i int, opts x509.VerifyOptions) time.Time {
+	if t, err := sd.SignerTimestamp(i, opts); err == nil && !t.IsZero() {
+		return t
+	}
+
+	if t, err := sd.GetSignerInfos()[i].GetSigningTimeAttribute(); err == nil {
+		return t
+	}
+
+	return time.Time{}
 }
 
-func verifyOpts() x509.VerifyOptions {
-	roots, err := x509.SystemCertPool()
+// signerIssuer gets the issuer of the signer's certificate from the verified
+// chains, or from the certificates included in the signature if the chain
+// couldn't be verified. Nil is returned for self-signed certificates or if the
+// issuer isn't known.
+func signerIssuer(sd *cms.SignedData, cert *x509.Certificate, chains [][]*x509.Certificate) *x509.Certificate {
+	if len(chains) > 0 {
+		if chain := chains[0]; len(chain) > 1 {
+			return chain[1]
+		}
+		return nil
+	}
+
+	certs, err := sd.GetCertificates()
 	if err != nil {
-		// SystemCertPool isn't implemented for Windows. fall back to mozilla trust
-		// store.
-		roots, err = gocertifi.CACerts()
-		if err != nil {
-			// Fall back to an empty store. Verification will likely fail.
-			roots = x509.NewCertPool()
+		return nil
+	}
+
+	for _, c := range certs {
+		if c.Equal(cert) {
+			continue
+		}
+		if cert.CheckSignatureFrom(c) == nil {
+			return c
 		}
 	}
 
-	for _, ident := range idents {
-		if cert, err := ident.Certificate(); err == nil {
-			roots.AddCert(cert)
+	return nil
+}
+
+// isExpired checks if a certificate verification error is due to a
+// certificate being expired or not yet valid.
+func isExpired(err error) bool {
+	cie, ok := errors.Cause(err).(x509.CertificateInvalidError)
+	return ok && cie.Reason == x509.Expired
+}
+
+// verifyOpts builds the options for verifying signer certificates. Roots
+// come from the system trust store, unless --no-system-roots is given, along
+// with any --trust-anchors. Local identity certificates are only trusted if
+// --trust-local-certs is given. Signer certificates must allow one of the
+// extended key usages from --ekus.
+func verifyOpts() (x509.VerifyOptions, error) {
+	var roots *x509.CertPool
+
+	if *noSystemRootsFlag {
+		roots = x509.NewCertPool()
+	} else {
+		var err error
+		if roots, err = x509.SystemCertPool(); err != nil {
+			// SystemCertPool isn't implemented for Windows. fall back to mozilla
+			// trust store.
+			roots, err = gocertifi.CACerts()
+			if err != nil {
+				// Fall back to an empty store. Verification will likely fail.
+				roots = x509.NewCertPool()
+			}
 		}
 	}
 
-	return x509.VerifyOptions{
-		Roots:     roots,
-		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	anchors, err := loadCerts(*trustAnchorsOpt)
+	if err != nil {
+		return x509.VerifyOptions{}, errors.Wrap(err, "failed to load trust anchors")
+	}
+	for _, cert := range anchors {
+		roots.AddCert(cert)
+	}
+	debugf(debugBasic, "loaded %d trust anchors", len(anchors))
+
+	if *trustLocalCertsFlag {
+		for _, ident := range idents {
+			if cert, err := ident.Certificate(); err == nil {
+				roots.AddCert(cert)
+			}
+		}
 	}
+
+	intermediates := x509.NewCertPool()
+	certs, err := loadCerts(*intermediatesOpt)
+	if err != nil {
+		return x509.VerifyOptions{}, errors.Wrap(err, "failed to load intermediates")
+	}
+	for _, cert := range certs {
+		intermediates.AddCert(cert)
+	}
+	debugf(debugBasic, "loaded %d intermediates", len(certs))
+
+	ekus, err := ekusFromOpt()
+	if err != nil {
+		return x509.VerifyOptions{}, err
+	}
+
+	return x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     ekus,
+	}, nil
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/pkg/errors"
+)
+
+// commandVerifyAuditLog checks that each signature file given as an argument
+// is recorded in the --audit-log, and that its record matches the signature.
+// Signatures are identified by their digest, so signatures that were modified
+// after signing, for example by --add-timestamp, won't be found.
+func commandVerifyAuditLog() error {
+	records, err := readAuditLog(*auditLogOpt)
+	if err != nil {
+		return errors.Wrap(err, "failed to read audit log")
+	}
+
+	bySignature := make(map[string]auditRecord, len(records))
+	for _, rec := range records {
+		bySignature[rec.SignatureDigest] = rec
+	}
+
+	var nBad int
+
+	for _, path := range fileArgs {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stdout, "%s: %s\n", path, errors.Wrap(err, "failed to read signature file"))
+			nBad++
+			continue
+		}
+
+		der := derFromMaybePEM(data)
+
+		rec, ok := bySignature[sha256Hex(der)]
+		if !ok {
+			fmt.Fprintf(stdout, "%s: not recorded in audit log\n", path)
+			nBad++
+			continue
+		}
+
+		if err = checkAuditRecord(rec, der); err != nil {
+			fmt.Fprintf(stdout, "%s: audit record doesn't match signature: %s\n", path, err)
+			nBad++
+			continue
+		}
+
+		fmt.Fprintf(stdout, "%s: signed by \"%s\" (0x%s) at %s\n", path, rec.Subject, rec.Signer, rec.Time.Format("2006-01-02 15:04:05 MST"))
+	}
+
+	if nBad > 0 {
+		return fmt.Errorf("%d of %d signatures failed audit log verification", nBad, len(fileArgs))
+	}
+
+	return nil
+}
+
+// checkAuditRecord checks that an audit record's fields match the DER encoded
+// signature it was found for. The signer can only be checked if the signature
+// includes the signer's certificate.
+func checkAuditRecord(rec auditRecord, der []byte) error {
+	sd, err := cms.ParseSignedData(der)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse signature")
+	}
+
+	signerInfos := sd.GetSignerInfos()
+	if len(signerInfos) != 1 {
+		return fmt.Errorf("expected one signer, found %d", len(signerInfos))
+	}
+	si := signerInfos[0]
+
+	if rec.Detached != sd.IsDetached() {
+		return errors.New("detached mode differs")
+	}
+
+	certs, err := sd.GetCertificates()
+	if err != nil {
+		return errors.Wrap(err, "failed to get certificates")
+	}
+	if cert, err := si.FindCertificate(certs); err == nil && certHexFingerprint(cert) != rec.Signer {
+		return errors.New("signer differs")
+	}
+
+	hash, ok := digestAlgorithms[rec.DigestAlgorithm]
+	if !ok {
+		return errors.Errorf("unknown digest algorithm %q", rec.DigestAlgorithm)
+	}
+
+	if sd.IsDetached() {
+		// Detached content isn't available, but its digest is signed.
+		if siHash, err := si.Hash(); err != nil || siHash != hash {
+			return errors.New("digest algorithm differs")
+		}
+		digest, err := si.GetMessageDigestAttribute()
+		if err != nil {
+			return errors.Wrap(err, "failed to get message digest")
+		}
+		if hex.EncodeToString(digest) != rec.ContentDigest {
+			return errors.New("content digest differs")
+		}
+	} else {
+		content, err := sd.GetData()
+		if err != nil {
+			return errors.Wrap(err, "failed to get signed data")
+		}
+		h := hash.New()
+		h.Write(content)
+		if hex.EncodeToString(h.Sum(nil)) != rec.ContentDigest {
+			return errors.New("content digest differs")
+		}
+	}
+
+	if timestampSerial(si) != rec.TimestampSerial {
+		return errors.New("timestamp serial differs")
+	}
+
+	return nil
+}
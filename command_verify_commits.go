@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/pkg/errors"
+)
+
+// gitCommand is the git executable used for reading commits and tags.
+var gitCommand = "git"
+
+// gitObject is a commit or tag read from the git object database.
+type gitObject struct {
+	id      string
+	name    string
+	typ     string
+	content []byte
+}
+
+// commandVerifyCommits verifies the signatures on every commit in the
+// rev-ranges given as arguments, along with any annotated tags named by those
+// ranges. All objects are verified in this process using the same trust pool,
+// and each signer is checked as by --verify, including --require-signers.
+// Objects named more than once are only verified once. Results aren't kept
+// between runs.
+func commandVerifyCommits() error {
+	names, err := objectsToVerify(fileArgs)
+	if err != nil {
+		return err
+	}
+
+	v, err := newVerifier()
+	if err != nil {
+		return err
+	}
+
+	var (
+		results = map[string]objectResult{}
+		nBad    int
+	)
+
+	err = catFile(names, func(obj gitObject) error {
+		res, cached := results[obj.id]
+		if !cached {
+			res = verifyObject(v, obj)
+			results[obj.id] = res
+			if !res.good {
+				nBad++
+			}
+		}
+
+		fmt.Fprintf(stdout, "%s %s: %s\n", obj.typ, obj.name, res.message)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if nBad > 0 {
+		return fmt.Errorf("%d of %d objects failed verification", nBad, len(results))
+	}
+
+	return nil
+}
+
+// objectResult is the outcome of verifying a commit or tag.
+type objectResult struct {
+	good    bool
+	message string
+}
+
+// verifyObject verifies the signature on a commit or tag.
+func verifyObject(v *verifier, obj gitObject) objectResult {
+	sig, payload := splitSignature(obj)
+	if sig == nil {
+		return objectResult{message: "no signature"}
+	}
+
+	// Try decoding as PEM
+	der := sig
+	if blk, _ := pem.Decode(sig); blk != nil {
+		der = blk.Bytes
+	}
+
+	sd, err := cms.ParseSignedData(der)
+	if err != nil {
+		return objectResult{message: fmt.Sprintf("bad signature: %s", errors.Wrap(err, "failed to parse signature"))}
+	}
+
+	// Each object gets its own block of status lines for each signer, as with
+	// --verify.
+	sNewSig.emit()
+
+	certs, err := v.verify(sd, payload, nil)
+	if err != nil {
+		return objectResult{message: fmt.Sprintf("bad signature: %s", err)}
+	}
+
+	signers := make([]string, len(certs))
+	for i, cert := range certs {
+		signers[i] = fmt.Sprintf("\"%s\" (0x%s)", cert.Subject.String(), certHexFingerprint(cert))
+	}
+
+	return objectResult{good: true, message: "good signature from " + strings.Join(signers, ", ")}
+}
+
+// objectsToVerify lists the commits in the given rev-ranges, followed by any
+// annotated tags used as range endpoints.
+func objectsToVerify(revs []string) ([]string, error) {
+	out, err := git(nil, append([]string{"rev-list"}, revs...)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list commits")
+	}
+	names := strings.Fields(string(out))
+
+	// Find the range endpoints that are included in the ranges (eg. "v1.1" in
+	// "v1.0..v1.1", but not "v1.0" or "^v1.0") and check which of them are
+	// annotated tags.
+	var endpoints []string
+	for _, rev := range revs {
+		for _, ep := range includedEndpoints(rev) {
+			if len(ep) > 0 {
+				endpoints = append(endpoints, ep)
+			}
+		}
+	}
+	if len(endpoints) == 0 {
+		return names, nil
+	}
+
+	in := strings.NewReader(strings.Join(endpoints, "\n") + "\n")
+	if out, err = git(in, "cat-file", "--batch-check=%(objecttype)"); err != nil {
+		return nil, errors.Wrap(err, "failed to check object types")
+	}
+	for i, typ := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if typ == "tag" && i < len(endpoints) {
+			names = append(names, endpoints[i])
+		}
+	}
+
+	return names, nil
+}
+
+// includedEndpoints gets the endpoints of a rev-range whose commits are
+// included in the range. Both ends of a symmetric difference are included, but
+// only the right end of "a..b", and nothing from an exclusion like "^a".
+func includedEndpoints(rev string) []string {
+	if strings.HasPrefix(rev, "^") {
+		return nil
+	}
+
+	if i := strings.Index(rev, "..."); i >= 0 {
+		return []string{rev[:i], rev[i+3:]}
+	}
+
+	if i := strings.Index(rev, ".."); i >= 0 {
+		return []string{rev[i+2:]}
+	}
+
+	return []string{rev}
+}
+
+// catFile reads the named objects with a single `git cat-file --batch`,
+// calling fn with each in turn. Objects are read from git one at a time, so
+// they aren't all held in memory.
+func catFile(names []string, fn func(gitObject) error) (err error) {
+	if len(names) == 0 {
+		return nil
+	}
+
+	cmd := exec.Command(gitCommand, "cat-file", "--batch")
+	cmd.Stdin = strings.NewReader(strings.Join(names, "\n") + "\n")
+
+	errBuf := new(bytes.Buffer)
+	cmd.Stderr = errBuf
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "failed to read objects")
+	}
+	if err = cmd.Start(); err != nil {
+		return errors.Wrap(err, "failed to read objects")
+	}
+	defer func() {
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+		}
+	}()
+
+	r := bufio.NewReader(out)
+	for _, name := range names {
+		obj, err := readObject(r, name)
+		if err != nil {
+			return err
+		}
+		if err = fn(obj); err != nil {
+			return err
+		}
+	}
+
+	if err = cmd.Wait(); err != nil {
+		return fmt.Errorf("git cat-file: %v: %s", err, strings.TrimSpace(errBuf.String()))
+	}
+
+	return nil
+}
+
+// readObject reads the named object from `git cat-file --batch` output.
+func readObject(r *bufio.Reader, name string) (gitObject, error) {
+	// <oid> SP <type> SP <size> LF <contents> LF
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return gitObject{}, errors.Wrap(err, "failed to read object header")
+	}
+
+	fields := strings.Fields(header)
+	if len(fields) != 3 {
+		return gitObject{}, fmt.Errorf("bad object: %s", name)
+	}
+
+	size, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return gitObject{}, errors.Wrapf(err, "bad object size: %s", name)
+	}
+
+	content := make([]byte, size+1)
+	if _, err = io.ReadFull(r, content); err != nil {
+		return gitObject{}, errors.Wrapf(err, "failed to read object: %s", name)
+	}
+
+	return gitObject{
+		id:      fields[0],
+		name:    name,
+		typ:     fields[1],
+		content: content[:size],
+	}, nil
+}
+
+// git runs a git command, returning its stdout.
+func git(in io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command(gitCommand, args...)
+	cmd.Stdin = in
+
+	errBuf := new(bytes.Buffer)
+	cmd.Stderr = errBuf
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(errBuf.String()))
+	}
+
+	return out, nil
+}
+
+// splitSignature separates a commit or tag into its signature and the payload
+// that was signed. A nil signature is returned if the object isn't signed.
+func splitSignature(obj gitObject) (sig, payload []byte) {
+	switch obj.typ {
+	case "commit":
+		return splitCommitSignature(obj.content, signatureHeader(obj.id))
+	case "tag":
+		return splitTagSignature(obj.content)
+	default:
+		return nil, obj.content
+	}
+}
+
+// signatureHeaders are the commit headers that hold signatures, for
+// repositories using SHA-1 and SHA-256 object IDs.
+var signatureHeaders = []string{"gpgsig", "gpgsig-sha256"}
+
+// signatureHeader gets the commit header holding the signature for the hash
+// algorithm of the object ID id.
+func signatureHeader(id string) string {
+	if len(id) == 64 {
+		return "gpgsig-sha256"
+	}
+
+	return "gpgsig"
+}
+
+// splitCommitSignature removes the signature headers from a commit, as git
+// does, returning the value of the given header as the signature, with
+// continuation lines unfolded.
+func splitCommitSignature(commit []byte, header string) (sig, payload []byte) {
+	var (
+		sigBuf      = new(bytes.Buffer)
+		payloadBuf  = new(bytes.Buffer)
+		inSig       bool
+		inOtherSig  bool
+		inHeaders   = true
+		sigPrefix   = []byte(header + " ")
+		isSigHeader = func(line []byte) bool {
+			for _, h := range signatureHeaders {
+				if bytes.HasPrefix(line, []byte(h+" ")) {
+					return true
+				}
+			}
+			return false
+		}
+	)
+
+	for _, line := range bytes.SplitAfter(commit, []byte("\n")) {
+		if inHeaders {
+			switch {
+			case len(bytes.TrimRight(line, "\n")) == 0:
+				inHeaders = false
+				inSig, inOtherSig = false, false
+			case bytes.HasPrefix(line, sigPrefix):
+				inSig, inOtherSig = true, false
+				sigBuf.Write(line[len(sigPrefix):])
+				continue
+			case isSigHeader(line):
+				inSig, inOtherSig = false, true
+				continue
+			case bytes.HasPrefix(line, []byte(" ")) && (inSig || inOtherSig):
+				if inSig {
+					sigBuf.Write(line[1:])
+				}
+				continue
+			default:
+				inSig, inOtherSig = false, false
+			}
+		}
+
+		payloadBuf.Write(line)
+	}
+
+	if sigBuf.Len() == 0 {
+		return nil, commit
+	}
+
+	return sigBuf.Bytes(), payloadBuf.Bytes()
+}
+
+// splitTagSignature splits the signature appended to an annotated tag's
+// message from the rest of the tag.
+func splitTagSignature(tag []byte) (sig, payload []byte) {
+	idx := bytes.LastIndex(tag, []byte("-----BEGIN SIGNED MESSAGE-----"))
+	if idx < 0 || (idx > 0 && tag[idx-1] != '\n') {
+		return nil, tag
+	}
+
+	return tag[idx:], tag[:idx]
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/github/smimesign/fakeca"
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/stretchr/testify/require"
+)
+
+// testRepo creates a git repository in a temporary directory, changing into it.
+// Any args are passed to git init. The returned function cleans up.
+func testRepo(t *testing.T, args ...string) func() {
+	t.Helper()
+
+	if _, err := exec.LookPath(gitCommand); err != nil {
+		t.Skip("git not available")
+	}
+
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+
+	testGit(t, append([]string{"init", "-q"}, args...)...)
+
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+// testGit runs a git command for a test, returning its trimmed output.
+func testGit(t *testing.T, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command(gitCommand, args...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=a", "GIT_AUTHOR_EMAIL=a@example.com",
+		"GIT_COMMITTER_NAME=a", "GIT_COMMITTER_EMAIL=a@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	return strings.TrimSpace(string(out))
+}
+
+// signedCommit writes a commit signed by leaf, or by each of signers if any are
+// given, with the given parent.
+func signedCommit(t *testing.T, parent string, message string, signers ...*fakeca.Identity) string {
+	t.Helper()
+
+	tree := testGit(t, "write-tree")
+
+	payload := "tree " + tree + "\n"
+	if len(parent) > 0 {
+		payload += "parent " + parent + "\n"
+	}
+	payload += "author a <a@example.com> 1500000000 +0000\n"
+	payload += "committer a <a@example.com> 1500000000 +0000\n"
+
+	body := "\n" + message + "\n"
+
+	if len(signers) == 0 {
+		signers = []*fakeca.Identity{leaf}
+	}
+
+	sd, err := cms.NewSignedData([]byte(payload + body))
+	require.NoError(t, err)
+	for _, signer := range signers {
+		require.NoError(t, sd.Sign(signer.Chain(), signer.PrivateKey))
+	}
+	sd.Detached()
+	der, err := sd.ToDER()
+	require.NoError(t, err)
+	sig := strings.TrimSpace(string(pem.EncodeToMemory(&pem.Block{Type: "SIGNED MESSAGE", Bytes: der})))
+
+	header := "gpgsig"
+	if testGit(t, "rev-parse", "--show-object-format") == "sha256" {
+		header = "gpgsig-sha256"
+	}
+
+	commit := payload + header + " " + strings.Replace(sig, "\n", "\n ", -1) + "\n" + body
+	require.NoError(t, ioutil.WriteFile("commit", []byte(commit), 0644))
+	defer os.Remove("commit")
+
+	return testGit(t, "hash-object", "-t", "commit", "-w", "commit")
+}
+
+// signedTag writes an annotated tag signed by leaf pointing at commit.
+func signedTag(t *testing.T, commit string, name string) {
+	t.Helper()
+
+	payload := "object " + commit + "\ntype commit\ntag " + name + "\n"
+	payload += "tagger a <a@example.com> 1500000000 +0000\n\n" + name + "\n"
+
+	der, err := cms.SignDetached([]byte(payload), leaf.Chain(), leaf.PrivateKey)
+	require.NoError(t, err)
+	sig := pem.EncodeToMemory(&pem.Block{Type: "SIGNED MESSAGE", Bytes: der})
+
+	require.NoError(t, ioutil.WriteFile("tag", append([]byte(payload), sig...), 0644))
+	defer os.Remove("tag")
+
+	tag := testGit(t, "hash-object", "-t", "tag", "-w", "tag")
+	testGit(t, "update-ref", "refs/tags/"+name, tag)
+}
+
+func TestVerifyCommits(t *testing.T) {
+	defer testRepo(t)()
+
+	first := signedCommit(t, "", "first")
+	second := signedCommit(t, first, "second")
+	signedTag(t, second, "v1")
+	caFile := writeCertsFile(t, ".", "ca.pem", ca.Certificate)
+
+	defer testSetup(t, "--verify-commits", "--trust-anchors", caFile, "v1")()
+
+	require.NoError(t, commandVerifyCommits())
+	require.Contains(t, stdoutBuf.String(), "commit "+first+": good signature")
+	require.Contains(t, stdoutBuf.String(), "commit "+second+": good signature")
+	require.Contains(t, stdoutBuf.String(), "tag v1: good signature")
+}
+
+func TestVerifyCommitsSHA256(t *testing.T) {
+	defer testRepo(t, "--object-format=sha256")()
+
+	first := signedCommit(t, "", "first")
+	second := signedCommit(t, first, "second")
+	require.Equal(t, 64, len(second))
+	testGit(t, "update-ref", "HEAD", second)
+	caFile := writeCertsFile(t, ".", "ca.pem", ca.Certificate)
+
+	defer testSetup(t, "--verify-commits", "--trust-anchors", caFile, "HEAD")()
+
+	require.NoError(t, commandVerifyCommits())
+	require.Contains(t, stdoutBuf.String(), "commit "+first+": good signature")
+	require.Contains(t, stdoutBuf.String(), "commit "+second+": good signature")
+}
+
+func TestVerifyCommitsUnsigned(t *testing.T) {
+	defer testRepo(t)()
+
+	first := signedCommit(t, "", "first")
+	unsigned := testGit(t, "commit-tree", testGit(t, "write-tree"), "-p", first, "-m", "unsigned")
+	caFile := writeCertsFile(t, ".", "ca.pem", ca.Certificate)
+
+	defer testSetup(t, "--verify-commits", "--trust-anchors", caFile, unsigned)()
+
+	require.EqualError(t, commandVerifyCommits(), "1 of 2 objects failed verification")
+	require.Contains(t, stdoutBuf.String(), "commit "+unsigned+": no signature")
+}
+
+func TestVerifyCommitsOnce(t *testing.T) {
+	defer testRepo(t)()
+
+	first := signedCommit(t, "", "first")
+	second := signedCommit(t, first, "second")
+	signedTag(t, first, "v0")
+	signedTag(t, second, "v1")
+	caFile := writeCertsFile(t, ".", "ca.pem", ca.Certificate)
+
+	defer testSetup(t, "--verify-commits", "--trust-anchors", caFile, "v0..v1", "v1")()
+	read, reset := captureStatus(t)
+	defer reset()
+
+	// The excluded v0 isn't verified, and v1 is only verified once.
+	require.NoError(t, commandVerifyCommits())
+	require.Equal(t, 2, strings.Count(read(), "[GNUPG:] NEWSIG\n"))
+	require.NotContains(t, stdoutBuf.String(), "tag v0")
+	require.NotContains(t, stdoutBuf.String(), "commit "+first)
+	require.Contains(t, stdoutBuf.String(), "commit "+second+": good signature")
+	require.Equal(t, 2, strings.Count(stdoutBuf.String(), "tag v1: good signature"))
+}
+
+func TestCatFile(t *testing.T) {
+	defer testRepo(t)()
+
+	first := signedCommit(t, "", "first")
+	second := signedCommit(t, first, "second")
+
+	var ids []string
+	require.NoError(t, catFile([]string{first, second, first}, func(obj gitObject) error {
+		ids = append(ids, obj.id)
+		return nil
+	}))
+	require.Equal(t, []string{first, second, first}, ids)
+
+	// Errors from fn stop reading.
+	ids = nil
+	err := catFile([]string{first, second}, func(obj gitObject) error {
+		ids = append(ids, obj.id)
+		return errors.New("stop")
+	})
+	require.EqualError(t, err, "stop")
+	require.Equal(t, []string{first}, ids)
+
+	require.EqualError(t, catFile([]string{"0000000000000000000000000000000000000000"}, func(gitObject) error { return nil }), "bad object: 0000000000000000000000000000000000000000")
+}
+
+func TestIncludedEndpoints(t *testing.T) {
+	require.Equal(t, []string{"v1"}, includedEndpoints("v1"))
+	require.Equal(t, []string{"v1"}, includedEndpoints("v0..v1"))
+	require.Equal(t, []string{""}, includedEndpoints("v0.."))
+	require.Equal(t, []string{"v0", "v1"}, includedEndpoints("v0...v1"))
+	require.Empty(t, includedEndpoints("^v0"))
+}
+
+func TestVerifyCommitsMultipleSigners(t *testing.T) {
+	defer testRepo(t)()
+
+	var (
+		untrusted = fakeca.New(fakeca.IsCA).Issue()
+		commit    = signedCommit(t, "", "first", leaf, untrusted)
+		caFile    = writeCertsFile(t, ".", "ca.pem", ca.Certificate)
+		fpr       = certHexFingerprint(leaf.Certificate)
+		otherFpr  = certHexFingerprint(untrusted.Certificate)
+	)
+
+	verify := func(requireSigners string) (string, error) {
+		defer testSetup(t, "--verify-commits", "--trust-anchors", caFile, "--require-signers", requireSigners, commit)()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		err := commandVerifyCommits()
+		return read(), err
+	}
+
+	// Each signer is reported in its own block, as with --verify.
+	output, err := verify("all")
+	require.EqualError(t, err, "1 of 1 objects failed verification")
+	require.Equal(t, 2, strings.Count(output, "[GNUPG:] NEWSIG\n"))
+	require.Equal(t, []string{
+		"GOODSIG " + fpr, "VALIDSIG " + fpr, "TRUST_FULLY 0",
+		"GOODSIG " + otherFpr, "VALIDSIG " + otherFpr, "TRUST_UNDEFINED not_trusted",
+	}, statusLines(output))
+
+	_, err = verify("any")
+	require.NoError(t, err)
+
+	_, err = verify("2")
+	require.Error(t, err)
+}
+
+func TestSplitCommitSignature(t *testing.T) {
+	commit := "tree abc\ngpgsig -----BEGIN SIGNED MESSAGE-----\n abcd\n -----END SIGNED MESSAGE-----\nauthor x\n\nmessage\n gpgsig not a header\n"
+
+	sig, payload := splitCommitSignature([]byte(commit), "gpgsig")
+	require.Equal(t, "-----BEGIN SIGNED MESSAGE-----\nabcd\n-----END SIGNED MESSAGE-----\n", string(sig))
+	require.Equal(t, "tree abc\nauthor x\n\nmessage\n gpgsig not a header\n", string(payload))
+
+	sig, payload = splitCommitSignature([]byte(commit), "gpgsig-sha256")
+	require.Nil(t, sig)
+	require.Equal(t, commit, string(payload))
+
+	// Signatures for both hash algorithms are removed from the payload.
+	commit = "tree abc\ngpgsig sha1\n sig\ngpgsig-sha256 sha256\n sig\nauthor x\n\nmessage\n"
+
+	sig, payload = splitCommitSignature([]byte(commit), "gpgsig")
+	require.Equal(t, "sha1\nsig\n", string(sig))
+	require.Equal(t, "tree abc\nauthor x\n\nmessage\n", string(payload))
+
+	sig, payload = splitCommitSignature([]byte(commit), "gpgsig-sha256")
+	require.Equal(t, "sha256\nsig\n", string(sig))
+	require.Equal(t, "tree abc\nauthor x\n\nmessage\n", string(payload))
+}
+
+func TestSplitTagSignature(t *testing.T) {
+	tag := "object abc\ntype commit\ntag v1\n\nmessage\n-----BEGIN SIGNED MESSAGE-----\nabcd\n-----END SIGNED MESSAGE-----\n"
+
+	sig, payload := splitTagSignature([]byte(tag))
+	require.Equal(t, "-----BEGIN SIGNED MESSAGE-----\nabcd\n-----END SIGNED MESSAGE-----\n", string(sig))
+	require.Equal(t, "object abc\ntype commit\ntag v1\n\nmessage\n", string(payload))
+}
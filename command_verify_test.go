@@ -0,0 +1,493 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/github/smimesign/fakeca"
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// statusLines gets the status keywords and first arguments from status
+// output, ignoring NEWSIG and SIG_ID.
+func statusLines(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "[GNUPG:] "))
+		if len(fields) == 0 || fields[0] == "NEWSIG" || fields[0] == "SIG_ID" {
+			continue
+		}
+		if len(fields) > 2 {
+			fields = fields[:2]
+		}
+		lines = append(lines, strings.Join(fields, " "))
+	}
+
+	return lines
+}
+
+func TestVerifyStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+	fpr := certHexFingerprint(leaf.Certificate)
+
+	sign := func(ident *fakeca.Identity) []byte {
+		der, err := cms.SignDetached([]byte("hello, world!"), ident.Chain(), ident.PrivateKey)
+		require.NoError(t, err)
+		return der
+	}
+
+	verify := func(sig []byte, message string, args ...string) ([]string, error) {
+		sigFile := filepath.Join(dir, "sig")
+		require.NoError(t, ioutil.WriteFile(sigFile, sig, 0644))
+
+		defer testSetup(t, append(append([]string{"--verify"}, args...), sigFile, "-")...)()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		stdinBuf.WriteString(message)
+		err := commandVerify()
+		return statusLines(read()), err
+	}
+
+	// Good signature from a trusted certificate.
+	lines, err := verify(sign(leaf), "hello, world!", "--no-system-roots", "--trust-anchors", caFile)
+	require.NoError(t, err)
+	require.Equal(t, []string{"GOODSIG " + fpr, "VALIDSIG " + fpr, "TRUST_FULLY 0"}, lines)
+
+	// Good signature from an untrusted certificate.
+	lines, err = verify(sign(leaf), "hello, world!", "--no-system-roots")
+	require.Error(t, err)
+	require.Equal(t, []string{"GOODSIG " + fpr, "VALIDSIG " + fpr, "TRUST_UNDEFINED not_trusted"}, lines)
+
+	// Good signature from a certificate that isn't valid for signing.
+	encLeaf := intermediate.Issue(fakeca.KeyUsage(x509.KeyUsageKeyEncipherment))
+	lines, err = verify(sign(encLeaf), "hello, world!", "--no-system-roots", "--trust-anchors", caFile)
+	require.Error(t, err)
+	require.Equal(t, []string{"GOODSIG " + certHexFingerprint(encLeaf.Certificate), "VALIDSIG " + certHexFingerprint(encLeaf.Certificate), "TRUST_NEVER wrong_key_usage"}, lines)
+
+	// Good signature from an expired certificate.
+	expiredLeaf := intermediate.Issue(fakeca.NotBefore(time.Now().Add(-2*time.Hour)), fakeca.NotAfter(time.Now().Add(-time.Hour)))
+	lines, err = verify(sign(expiredLeaf), "hello, world!", "--no-system-roots", "--trust-anchors", caFile)
+	require.Error(t, err)
+	require.Equal(t, []string{"EXPKEYSIG " + certHexFingerprint(expiredLeaf.Certificate), "VALIDSIG " + certHexFingerprint(expiredLeaf.Certificate)}, lines)
+
+	// Bad signature.
+	lines, err = verify(sign(leaf), "goodbye, world!", "--no-system-roots", "--trust-anchors", caFile)
+	require.Error(t, err)
+	require.Equal(t, []string{"BADSIG " + fpr}, lines)
+}
+
+func TestVerifyStatusUnsupportedAlgorithm(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecLeaf := intermediate.Issue(fakeca.PrivateKey(ecKey))
+
+	// An RSASSA-PSS signature algorithm can't be used with an ECDSA key.
+	der, err := cms.Sign([]byte("hello, world!"), ecLeaf.Chain(), ecLeaf.PrivateKey)
+	require.NoError(t, err)
+	ci, err := protocol.ParseContentInfo(der)
+	require.NoError(t, err)
+	psd, err := ci.SignedDataContent()
+	require.NoError(t, err)
+	psd.SignerInfos[0].SignatureAlgorithm, err = protocol.NewRSASSAPSSAlgorithmIdentifier(crypto.SHA256)
+	require.NoError(t, err)
+	der, err = psd.ContentInfoDER()
+	require.NoError(t, err)
+
+	defer testSetup(t, "--verify")()
+	read, reset := captureStatus(t)
+	defer reset()
+
+	stdinBuf.Write(der)
+	err = commandVerify()
+	require.Equal(t, x509.ErrUnsupportedAlgorithm, errors.Cause(err))
+
+	lines := strings.Split(strings.TrimSpace(read()), "\n")
+	require.Equal(t, 2, len(lines))
+	require.True(t, strings.HasPrefix(lines[1], "[GNUPG:] ERRSIG "+certHexFingerprint(ecLeaf.Certificate)+" "))
+	require.True(t, strings.HasSuffix(lines[1], " 4"))
+}
+
+func TestVerifyValidSig(t *testing.T) {
+	der, err := cms.Sign([]byte("hello, world!"), leaf.Chain(), leaf.PrivateKey)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+
+	defer testSetup(t, "--verify", "--no-system-roots", "--trust-anchors", caFile)()
+	read, reset := captureStatus(t)
+	defer reset()
+
+	stdinBuf.Write(der)
+	require.NoError(t, commandVerify())
+
+	sd, err := cms.ParseSignedData(der)
+	require.NoError(t, err)
+	signingTime, err := sd.GetSignerInfos()[0].GetSigningTimeAttribute()
+	require.NoError(t, err)
+
+	var sigID, validSig []string
+	for _, line := range strings.Split(read(), "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "[GNUPG:] "))
+		if len(fields) > 0 && fields[0] == "SIG_ID" {
+			sigID = fields[1:]
+		}
+		if len(fields) > 0 && fields[0] == "VALIDSIG" {
+			validSig = fields[1:]
+		}
+	}
+
+	date := signingTime.UTC().Format("2006-01-02")
+	ts := strconv.FormatInt(signingTime.Unix(), 10)
+
+	require.Equal(t, 3, len(sigID))
+	require.Equal(t, []string{date, ts}, sigID[1:])
+
+	require.Equal(t, []string{
+		certHexFingerprint(leaf.Certificate),
+		date,
+		ts,
+		strconv.FormatInt(leaf.Certificate.NotAfter.Unix(), 10),
+		"0", "0",
+		"1", "8", "00",
+		certHexFingerprint(intermediate.Certificate),
+	}, validSig)
+}
+
+func TestVerifyValidSigNoTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+
+	// Without signed attributes or a timestamp, the signature has no time.
+	sigFile := filepath.Join(dir, "sig")
+	require.NoError(t, ioutil.WriteFile(sigFile, signWithoutSignedAttrs(t, []byte("hello, world!")), 0644))
+
+	defer testSetup(t, "--verify", "--no-system-roots", "--trust-anchors", caFile, sigFile, "-")()
+	read, reset := captureStatus(t)
+	defer reset()
+
+	stdinBuf.WriteString("hello, world!")
+	require.NoError(t, commandVerify())
+
+	var sigID, validSig []string
+	for _, line := range strings.Split(read(), "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "[GNUPG:] "))
+		if len(fields) > 0 && fields[0] == "SIG_ID" {
+			sigID = fields[1:]
+		}
+		if len(fields) > 0 && fields[0] == "VALIDSIG" {
+			validSig = fields[1:]
+		}
+	}
+
+	require.Equal(t, 3, len(sigID))
+	require.Equal(t, []string{"????-??-??", "0"}, sigID[1:])
+	require.Equal(t, 10, len(validSig))
+	require.Equal(t, []string{"????-??-??", "0"}, validSig[1:3])
+}
+
+func TestVerifyEd25519(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edLeaf := intermediate.Issue(fakeca.PrivateKey(priv))
+
+	der, err := cms.Sign([]byte("hello, world!"), edLeaf.Chain(), edLeaf.PrivateKey)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+
+	defer testSetup(t, "--verify", "--no-system-roots", "--trust-anchors", caFile)()
+	read, reset := captureStatus(t)
+	defer reset()
+
+	stdinBuf.Write(der)
+	require.NoError(t, commandVerify())
+
+	var validSig []string
+	for _, line := range strings.Split(read(), "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "[GNUPG:] "))
+		if len(fields) > 0 && fields[0] == "VALIDSIG" {
+			validSig = fields[1:]
+		}
+	}
+
+	// EdDSA with SHA-512.
+	require.Equal(t, 10, len(validSig))
+	require.Equal(t, certHexFingerprint(edLeaf.Certificate), validSig[0])
+	require.Equal(t, []string{"22", "10"}, validSig[6:8])
+}
+
+func TestVerifyMultipleSigners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+
+	var (
+		untrusted = fakeca.New(fakeca.IsCA).Issue()
+		fpr       = certHexFingerprint(leaf.Certificate)
+		otherFpr  = certHexFingerprint(untrusted.Certificate)
+	)
+
+	sign := func(idents ...*fakeca.Identity) []byte {
+		sd, err := cms.NewSignedData([]byte("hello, world!"))
+		require.NoError(t, err)
+		for _, ident := range idents {
+			require.NoError(t, sd.Sign(ident.Chain(), ident.PrivateKey))
+		}
+		der, err := sd.ToDER()
+		require.NoError(t, err)
+		return der
+	}
+
+	verify := func(sig []byte, requireSigners string) (string, error) {
+		defer testSetup(t, "--verify", "--no-system-roots", "--trust-anchors", caFile, "--require-signers", requireSigners)()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		stdinBuf.Write(sig)
+		err := commandVerify()
+		return read(), err
+	}
+
+	// Each signer is reported in its own block.
+	output, err := verify(sign(leaf, untrusted), "all")
+	require.Error(t, err)
+	require.Equal(t, 2, strings.Count(output, "[GNUPG:] NEWSIG\n"))
+	require.Equal(t, []string{
+		"GOODSIG " + fpr, "VALIDSIG " + fpr, "TRUST_FULLY 0",
+		"GOODSIG " + otherFpr, "VALIDSIG " + otherFpr, "TRUST_UNDEFINED not_trusted",
+	}, statusLines(output))
+
+	_, err = verify(sign(leaf, untrusted), "any")
+	require.NoError(t, err)
+
+	_, err = verify(sign(leaf, untrusted), "1")
+	require.NoError(t, err)
+
+	_, err = verify(sign(leaf, untrusted), "2")
+	require.EqualError(t, err, "only 1 of 2 signers are valid, but 2 are required")
+
+	_, err = verify(sign(untrusted, untrusted), "any")
+	require.Error(t, err)
+
+	// The same signer only counts once.
+	_, err = verify(sign(leaf, leaf), "2")
+	require.Error(t, err)
+
+	_, err = verify(sign(leaf, leaf), "all")
+	require.NoError(t, err)
+
+	// A single valid signer can't meet a requirement for more.
+	_, err = verify(sign(leaf), "2")
+	require.EqualError(t, err, "only 1 of 1 signers are valid, but 2 are required")
+
+	_, err = verify(sign(leaf), "0")
+	require.EqualError(t, err, "bad number of required signers: 0")
+}
+
+func TestVerifyDetachedPayload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+
+	payload := "tree abc\ncommitter Mallory <mallory@example.com> 1500000000 +0000\n\nmessage\n"
+	sig, err := cms.SignDetachedReader(strings.NewReader(payload), leaf.Chain(), leaf.PrivateKey)
+	require.NoError(t, err)
+	sigFile := filepath.Join(dir, "sig")
+	require.NoError(t, ioutil.WriteFile(sigFile, sig, 0644))
+
+	// The streamed message is still checked against the committer.
+	defer testSetup(t, "--verify", "--no-system-roots", "--trust-anchors", caFile, "--committer-check=require", sigFile, "-")()
+	read, reset := captureStatus(t)
+	defer reset()
+
+	stdinBuf.WriteString(payload)
+	require.Equal(t, errEmailMismatch, commandVerify())
+	require.Equal(t, []string{"GOODSIG " + certHexFingerprint(leaf.Certificate), "VALIDSIG " + certHexFingerprint(leaf.Certificate), "TRUST_NEVER email_mismatch"}, statusLines(read()))
+}
+
+// signWithoutSignedAttrs makes a detached signature of message by leaf that has
+// no signed attributes, as other tools may, so the signature is over the
+// message itself.
+func signWithoutSignedAttrs(t *testing.T, message []byte) []byte {
+	t.Helper()
+
+	der, err := cms.SignDetached(message, leaf.Chain(), leaf.PrivateKey)
+	require.NoError(t, err)
+	ci, err := protocol.ParseContentInfo(der)
+	require.NoError(t, err)
+	psd, err := ci.SignedDataContent()
+	require.NoError(t, err)
+
+	digest := sha256.Sum256(message)
+	psd.SignerInfos[0].SignedAttrs = nil
+	psd.SignerInfos[0].Signature, err = leaf.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	der, err = psd.ContentInfoDER()
+	require.NoError(t, err)
+
+	return der
+}
+
+// signBackdated makes an attached signature by ident whose signing time
+// attribute is set to signingTime.
+func signBackdated(t *testing.T, ident *fakeca.Identity, message []byte, signingTime time.Time) []byte {
+	t.Helper()
+
+	der, err := cms.Sign(message, ident.Chain(), ident.PrivateKey)
+	require.NoError(t, err)
+	ci, err := protocol.ParseContentInfo(der)
+	require.NoError(t, err)
+	psd, err := ci.SignedDataContent()
+	require.NoError(t, err)
+
+	si := &psd.SignerInfos[0]
+	for j, attr := range si.SignedAttrs {
+		if attr.Type.Equal(oid.AttributeSigningTime) {
+			si.SignedAttrs[j], err = protocol.NewAttribute(oid.AttributeSigningTime, signingTime.UTC())
+			require.NoError(t, err)
+		}
+	}
+
+	sm, err := si.SignedAttrs.MarshaledForSigning()
+	require.NoError(t, err)
+	digest := sha256.Sum256(sm)
+	si.Signature, err = ident.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	require.NoError(t, err)
+	der, err = psd.ContentInfoDER()
+	require.NoError(t, err)
+
+	return der
+}
+
+func TestVerifyAllowedSignersBackdated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+
+	// The signer was removed in 2010, but claims to have signed in 2005.
+	allowedFile := filepath.Join(dir, "allowed_signers")
+	require.NoError(t, ioutil.WriteFile(allowedFile, []byte("alice@example.com subject=* valid-before=20100101\n"), 0644))
+
+	der := signBackdated(t, aliceLeaf, []byte("hello, world!"), time.Date(2005, 1, 1, 0, 0, 0, 0, time.UTC))
+	sd, err := cms.ParseSignedData(der)
+	require.NoError(t, err)
+	signingTime, err := sd.GetSignerInfos()[0].GetSigningTimeAttribute()
+	require.NoError(t, err)
+	require.Equal(t, 2005, signingTime.Year())
+
+	defer testSetup(t, "--verify", "--no-system-roots", "--trust-anchors", caFile, "--allowed-signers", allowedFile)()
+	read, reset := captureStatus(t)
+	defer reset()
+
+	stdinBuf.Write(der)
+	require.Equal(t, errNotAllowed, errors.Cause(commandVerify()))
+	require.Contains(t, statusLines(read()), "TRUST_NEVER not_allowed")
+}
+
+func TestVerifyDetachedNoSignedAttrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+
+	der := signWithoutSignedAttrs(t, []byte("hello, world!"))
+	sigFile := filepath.Join(dir, "sig")
+	require.NoError(t, ioutil.WriteFile(sigFile, der, 0644))
+
+	verify := func(message string) (string, error) {
+		defer testSetup(t, "--verify", "--no-system-roots", "--trust-anchors", caFile, sigFile, "-")()
+		read, reset := captureStatus(t)
+		defer reset()
+
+		stdinBuf.WriteString(message)
+		err := commandVerify()
+		return read(), err
+	}
+
+	fpr := certHexFingerprint(leaf.Certificate)
+
+	output, err := verify("hello, world!")
+	require.NoError(t, err)
+	require.Equal(t, []string{"GOODSIG " + fpr, "VALIDSIG " + fpr, "TRUST_FULLY 0"}, statusLines(output))
+
+	output, err = verify("goodbye, world!")
+	require.Error(t, err)
+	require.Equal(t, []string{"BADSIG " + fpr}, statusLines(output))
+}
+
+func TestVerifyDetachedMissingMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sig, err := cms.SignDetached([]byte("hello, world!"), leaf.Chain(), leaf.PrivateKey)
+	require.NoError(t, err)
+	sigFile := filepath.Join(dir, "sig")
+	require.NoError(t, ioutil.WriteFile(sigFile, sig, 0644))
+
+	msgFile := filepath.Join(dir, "missing")
+	defer testSetup(t, "--verify", sigFile, msgFile)()
+
+	err = commandVerify()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed to open message file ("+msgFile+")")
+}
+
+func TestVerifierIntermediatesNotShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+
+	defer testSetup(t, "--verify", "--no-system-roots", "--trust-anchors", caFile)()
+
+	v, err := newVerifier()
+	require.NoError(t, err)
+
+	verify := func(chain []*x509.Certificate) error {
+		der, err := cms.Sign([]byte("hello, world!"), chain, leaf.PrivateKey)
+		require.NoError(t, err)
+		sd, err := cms.ParseSignedData(der)
+		require.NoError(t, err)
+
+		_, err = v.verify(sd, []byte("hello, world!"), nil)
+		return err
+	}
+
+	// Certificates included in one signature don't complete the chain of
+	// another verified with the same verifier, as with --verify-commits.
+	require.NoError(t, verify(leaf.Chain()))
+	require.Error(t, verify([]*x509.Certificate{leaf.Certificate}))
+}
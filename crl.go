@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxCRLSize is the largest CRL we're willing to download.
+const maxCRLSize = 20 << 20
+
+// checkCRLs checks each certificate in chain, except for the root, against the
+// CRLs listed in its CRL distribution points. A certificate revoked before the
+// signature was made at signingTime causes a revokedError to be returned.
+// Certificates without CRL distribution points aren't checked. If a CRL isn't
+// current, a warning is printed and errRevocationUnknown is returned once the
+// rest of the chain is checked.
+func checkCRLs(chain []*x509.Certificate, signingTime time.Time) error {
+	var unknown bool
+
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+
+		for _, url := range cert.CRLDistributionPoints {
+			crl, err := getCRL(url, issuer)
+			if err == errStaleCRL {
+				fmt.Fprintf(stderr, "smimesign: WARNING: failed to check CRL status of \"%s\": %s (%s)\n", cert.Subject.String(), err, url)
+				unknown = true
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to get CRL (%s)", url)
+			}
+
+			for _, entry := range crl.RevokedCertificateEntries {
+				if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 && !signingTime.Before(entry.RevocationTime) {
+					return revokedError{cert, entry.RevocationTime}
+				}
+			}
+		}
+	}
+
+	if unknown {
+		return errRevocationUnknown
+	}
+
+	return nil
+}
+
+// errStaleCRL is returned by getCRL if the downloaded CRL isn't current. An
+// old CRL can be replayed to hide a revocation, so it can't be relied on.
+var errStaleCRL = errors.New("CRL is not current")
+
+// getCRL gets the CRL at url, using the cached copy if it is still current.
+// The CRL's signature is verified against issuer.
+func getCRL(url string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	cachePath := crlCachePath(url)
+
+	if der, err := ioutil.ReadFile(cachePath); err == nil {
+		if crl, err := parseCRL(der, issuer); err == nil && isCurrentCRL(crl) {
+			debugf(debugAdvanced, "using cached CRL from %s", cachePath)
+			return crl, nil
+		}
+	}
+
+	logf("fetching CRL from %s", url)
+	der, err := fetchCRL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	crl, err := parseCRL(der, issuer)
+	if err != nil {
+		return nil, err
+	}
+	if !isCurrentCRL(crl) {
+		return nil, errStaleCRL
+	}
+
+	// Caching is best-effort.
+	if err = os.MkdirAll(filepath.Dir(cachePath), 0700); err == nil {
+		ioutil.WriteFile(cachePath, der, 0600)
+	}
+
+	return crl, nil
+}
+
+// parseCRL parses a DER or PEM encoded CRL and verifies its signature.
+func parseCRL(der []byte, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	crl, err := x509.ParseRevocationList(derFromMaybePEM(der))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, errors.Wrap(err, "bad CRL signature")
+	}
+
+	return crl, nil
+}
+
+// isCurrentCRL checks if the current time is within the CRL's update period.
+// CRLs without a nextUpdate time are never current.
+func isCurrentCRL(crl *x509.RevocationList) bool {
+	now := time.Now()
+	return !now.Before(crl.ThisUpdate) && now.Before(crl.NextUpdate)
+}
+
+// fetchCRL downloads the CRL at url.
+func fetchCRL(url string) ([]byte, error) {
+	resp, err := revocationHTTPClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad HTTP status: %s", resp.Status)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err = io.Copy(buf, io.LimitReader(resp.Body, maxCRLSize+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxCRLSize {
+		return nil, errors.New("CRL too large")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// crlCachePath gets the path at which the CRL from url is cached.
+func crlCachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(cacheDir(), "crl", hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/github/smimesign/fakeca"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	crlCA   = fakeca.New(fakeca.IsCA, fakeca.KeyUsage(x509.KeyUsageCertSign|x509.KeyUsageCRLSign))
+	crlLeaf = crlCA.Issue(fakeca.CRLDistributionPoints("http://crl.example/ca.crl"))
+)
+
+// roundTripper is a http.RoundTripper implemented by a function.
+type roundTripper func(*http.Request) (*http.Response, error)
+
+func (rt roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rt(req)
+}
+
+// serveRevocation makes revocationHTTPClient respond to all requests with
+// body. The returned function restores the original client.
+func serveRevocation(t *testing.T, body []byte) func() {
+	t.Helper()
+
+	orig := revocationHTTPClient
+	revocationHTTPClient = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		if body == nil {
+			return nil, errors.New("offline")
+		}
+
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		}, nil
+	})}
+
+	return func() { revocationHTTPClient = orig }
+}
+
+// testCRL creates a current CRL signed by issuer, revoking the given certs at
+// revokedAt.
+func testCRL(t *testing.T, issuer *fakeca.Identity, revokedAt time.Time, revoked ...*x509.Certificate) []byte {
+	t.Helper()
+
+	return testCRLAt(t, time.Now().Add(-time.Hour), issuer, revokedAt, revoked...)
+}
+
+// testCRLAt is like testCRL, but the CRL is valid for two hours from
+// thisUpdate.
+func testCRLAt(t *testing.T, thisUpdate time.Time, issuer *fakeca.Identity, revokedAt time.Time, revoked ...*x509.Certificate) []byte {
+	t.Helper()
+
+	var entries []x509.RevocationListEntry
+	for _, cert := range revoked {
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: revokedAt,
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                thisUpdate.Add(2 * time.Hour),
+		RevokedCertificateEntries: entries,
+	}, issuer.Certificate, issuer.PrivateKey)
+	require.NoError(t, err)
+
+	return der
+}
+
+// testCacheDir sets --cache-dir to a temporary directory. The returned
+// function cleans up.
+func testCacheDir(t *testing.T, args ...string) func() {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+
+	reset := testSetup(t, append(args, "--cache-dir", dir)...)
+
+	return func() {
+		reset()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCheckCRLs(t *testing.T) {
+	var (
+		chain     = crlLeaf.Chain()
+		revokedAt = time.Now().Add(-time.Minute)
+	)
+
+	func() {
+		defer testCacheDir(t, "--verify", "--check-crls")()
+		defer serveRevocation(t, testCRL(t, crlCA, revokedAt))()
+
+		require.NoError(t, checkCRLs(chain, time.Now()))
+	}()
+
+	func() {
+		defer testCacheDir(t, "--verify", "--check-crls")()
+		defer serveRevocation(t, testCRL(t, crlCA, revokedAt, crlLeaf.Certificate))()
+
+		err := checkCRLs(chain, time.Now())
+		require.True(t, isRevoked(err), err)
+
+		// Signatures made before the revocation are still good.
+		require.NoError(t, checkCRLs(chain, revokedAt.Add(-time.Minute)))
+	}()
+
+	func() {
+		defer testCacheDir(t, "--verify", "--check-crls")()
+		defer serveRevocation(t, testCRL(t, fakeca.New(fakeca.IsCA, fakeca.KeyUsage(x509.KeyUsageCertSign|x509.KeyUsageCRLSign)), revokedAt))()
+
+		err := checkCRLs(chain, time.Now())
+		require.Error(t, err)
+		require.False(t, isRevoked(err))
+	}()
+}
+
+func TestCheckCRLsStale(t *testing.T) {
+	var (
+		chain     = crlLeaf.Chain()
+		revokedAt = time.Now().Add(-48 * time.Hour)
+	)
+
+	// An expired CRL, perhaps replayed to hide a later revocation, leaves the
+	// revocation status unknown.
+	func() {
+		defer testCacheDir(t, "--verify", "--check-crls")()
+		defer serveRevocation(t, testCRLAt(t, time.Now().Add(-24*time.Hour), crlCA, revokedAt))()
+
+		require.Equal(t, errRevocationUnknown, checkCRLs(chain, time.Now()))
+		require.Contains(t, stderrBuf.String(), "CRL is not current")
+	}()
+
+	// So does one that isn't valid yet.
+	func() {
+		defer testCacheDir(t, "--verify", "--check-crls")()
+		defer serveRevocation(t, testCRLAt(t, time.Now().Add(time.Hour), crlCA, revokedAt))()
+
+		require.Equal(t, errRevocationUnknown, checkCRLs(chain, time.Now()))
+	}()
+}
+
+func TestCheckCRLsCache(t *testing.T) {
+	defer testCacheDir(t, "--verify", "--check-crls")()
+
+	func() {
+		defer serveRevocation(t, testCRL(t, crlCA, time.Now(), crlLeaf.Certificate))()
+		require.True(t, isRevoked(checkCRLs(crlLeaf.Chain(), time.Now())))
+	}()
+
+	// The cached CRL is used while offline.
+	defer serveRevocation(t, nil)()
+	require.True(t, isRevoked(checkCRLs(crlLeaf.Chain(), time.Now())))
+
+	// Certificates without distribution points don't need a CRL.
+	require.NoError(t, checkCRLs(leaf.Chain(), time.Now()))
+}
@@ -22,7 +22,9 @@ type configuration struct {
 	notAfter              *time.Time
 	issuingCertificateURL []string
 	ocspServer            []string
+	crlDistributionPoints []string
 	keyUsage              x509.KeyUsage
+	extKeyUsage           []x509.ExtKeyUsage
 }
 
 func (c *configuration) generate() *Identity {
@@ -34,7 +36,9 @@ func (c *configuration) generate() *Identity {
 		NotBefore:             c.getNotBefore(),
 		IssuingCertificateURL: c.issuingCertificateURL,
 		OCSPServer:            c.ocspServer,
+		CRLDistributionPoints: c.crlDistributionPoints,
 		KeyUsage:              c.keyUsage,
+		ExtKeyUsage:           c.extKeyUsage,
 	}
 
 	var (
@@ -227,6 +231,14 @@ func OCSPServer(value ...string) Option {
 	}
 }
 
+// CRLDistributionPoints is an Option for setting the identity's certificate's
+// CRLDistributionPoints.
+func CRLDistributionPoints(value ...string) Option {
+	return func(c *configuration) {
+		c.crlDistributionPoints = append(c.crlDistributionPoints, value...)
+	}
+}
+
 // KeyUsage is an Option for setting the identity's certificate's KeyUsage.
 func KeyUsage(ku x509.KeyUsage) Option {
 	return func(c *configuration) {
@@ -234,6 +246,14 @@ func KeyUsage(ku x509.KeyUsage) Option {
 	}
 }
 
+// ExtKeyUsage is an Option for setting the identity's certificate's
+// ExtKeyUsage.
+func ExtKeyUsage(value ...x509.ExtKeyUsage) Option {
+	return func(c *configuration) {
+		c.extKeyUsage = append(c.extKeyUsage, value...)
+	}
+}
+
 // IsCA is an Option for making an identity a certificate authority.
 var IsCA Option = func(c *configuration) {
 	c.isCA = true
@@ -3,6 +3,7 @@ package fakeca
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
@@ -155,6 +156,30 @@ func TestPFX(t *testing.T) {
 	})
 }
 
+func TestEd25519(t *testing.T) {
+	assertNoPanic(t, func() {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			ca   = New(IsCA, PrivateKey(priv))
+			leaf = ca.Issue(PrivateKey(priv))
+		)
+
+		if ca.Certificate.SignatureAlgorithm != x509.PureEd25519 {
+			t.Fatalf("bad signature algorithm. expected Ed25519, got %s", ca.Certificate.SignatureAlgorithm)
+		}
+
+		if err := leaf.Certificate.CheckSignatureFrom(ca.Certificate); err != nil {
+			t.Fatal(err)
+		}
+
+		leaf.PFX("asdf")
+	})
+}
+
 func TestAIA(t *testing.T) {
 	i := New(IssuingCertificateURL("a", "b"), OCSPServer("c", "d"))
 
@@ -167,6 +192,22 @@ func TestAIA(t *testing.T) {
 	}
 }
 
+func TestCRLDistributionPoints(t *testing.T) {
+	i := New(CRLDistributionPoints("a", "b"))
+
+	if !reflect.DeepEqual(i.Certificate.CRLDistributionPoints, []string{"a", "b"}) {
+		t.Error("bad CRLDistributionPoints: ", i.Certificate.CRLDistributionPoints)
+	}
+}
+
+func TestExtKeyUsage(t *testing.T) {
+	i := New(ExtKeyUsage(x509.ExtKeyUsageOCSPSigning))
+
+	if !reflect.DeepEqual(i.Certificate.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}) {
+		t.Error("bad ExtKeyUsage: ", i.Certificate.ExtKeyUsage)
+	}
+}
+
 func assertNoPanic(t *testing.T, cb func()) {
 	// Check that t.Helper() is defined for Go<1.9
 	if h, ok := interface{}(t).(interface{ Helper() }); ok {
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -118,6 +119,9 @@ func toDER(priv interface{}) []byte {
 		der = x509.MarshalPKCS1PrivateKey(p)
 	case *ecdsa.PrivateKey:
 		der, err = x509.MarshalECPrivateKey(p)
+	case ed25519.PrivateKey:
+		// Ed25519 keys have no traditional format, only PKCS#8.
+		der, err = x509.MarshalPKCS8PrivateKey(p)
 	default:
 		err = errors.New("unknown key type")
 	}
@@ -0,0 +1,242 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+)
+
+// gcmNonceSize and gcmTagSize are the AES-GCM nonce and tag sizes used when
+// encrypting. These are the sizes recommended by RFC 5084.
+const (
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
+// AuthEnvelopedData represents a message encrypted to one or more recipients
+// with an authenticated encryption algorithm.
+type AuthEnvelopedData struct {
+	aed *protocol.AuthEnvelopedData
+}
+
+// AuthEncrypt creates a CMS AuthEnvelopedData with the content encrypted with
+// AES-256 in GCM mode, and the content encryption key encrypted to each of the
+// recipient certificates. The DER encoded CMS message is returned.
+func AuthEncrypt(data []byte, recipients []*x509.Certificate) ([]byte, error) {
+	return AuthEncryptWithOptions(data, recipients, EncryptOptions{})
+}
+
+// AuthEncryptWithOptions is like AuthEncrypt, but allows the content
+// encryption key size, RSA key transport algorithm and authenticated
+// attributes to be chosen.
+func AuthEncryptWithOptions(data []byte, recipients []*x509.Certificate, opts EncryptOptions) ([]byte, error) {
+	aed, err := NewAuthEnvelopedData(data, recipients, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return aed.ToDER()
+}
+
+// NewAuthEnvelopedData encrypts data to the recipient certificates with
+// AES-GCM. If opts has AuthAttributes, they are authenticated along with the
+// content, and a content-type attribute is added to them.
+func NewAuthEnvelopedData(data []byte, recipients []*x509.Certificate, opts EncryptOptions) (*AuthEnvelopedData, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+
+	keySize := opts.KeySize
+	if keySize == 0 {
+		keySize = 32
+	}
+
+	var algOID asn1.ObjectIdentifier
+	switch keySize {
+	case 16:
+		algOID = oid.AuthEncryptionAlgorithmAES128GCM
+	case 24:
+		algOID = oid.AuthEncryptionAlgorithmAES192GCM
+	case 32:
+		algOID = oid.AuthEncryptionAlgorithmAES256GCM
+	default:
+		return nil, errors.New("bad key size")
+	}
+
+	cek := make([]byte, keySize)
+	if _, err := rand.Read(cek); err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcmNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var (
+		authAttrs protocol.Attributes
+		aad       []byte
+	)
+
+	if len(opts.AuthAttributes) > 0 {
+		if !opts.AuthAttributes.HasAttribute(oid.AttributeContentType) {
+			ct, err := protocol.NewAttribute(oid.AttributeContentType, oid.ContentTypeData)
+			if err != nil {
+				return nil, err
+			}
+			authAttrs = append(authAttrs, ct)
+		}
+		authAttrs = append(authAttrs, opts.AuthAttributes...)
+
+		var err error
+		if aad, err = authAttrs.MarshaledForSigning(); err != nil {
+			return nil, err
+		}
+	}
+
+	gcm, err := newGCM(cek, gcmNonceSize, gcmTagSize)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := gcm.Seal(nil, nonce, data, aad)
+	ciphertext, mac := sealed[:len(data)], sealed[len(data):]
+
+	params, err := marshalRawValue(protocol.GCMParameters{Nonce: nonce, ICVLen: gcmTagSize})
+	if err != nil {
+		return nil, err
+	}
+
+	ris, err := newRecipientInfos(cek, recipients, opts.OAEP)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := pkix.AlgorithmIdentifier{Algorithm: algOID, Parameters: params}
+	eci := protocol.NewEncryptedContentInfo(oid.ContentTypeData, alg, ciphertext)
+
+	return &AuthEnvelopedData{aed: protocol.NewAuthEnvelopedData(ris, eci, authAttrs, mac)}, nil
+}
+
+// ParseAuthEnvelopedData parses an AuthEnvelopedData from BER encoded data.
+func ParseAuthEnvelopedData(ber []byte) (*AuthEnvelopedData, error) {
+	ci, err := protocol.ParseContentInfo(ber)
+	if err != nil {
+		return nil, err
+	}
+
+	aed, err := ci.AuthEnvelopedDataContent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthEnvelopedData{aed: aed}, nil
+}
+
+// IsRecipient checks if the message was encrypted to cert.
+func (aed *AuthEnvelopedData) IsRecipient(cert *x509.Certificate) bool {
+	return hasRecipient(aed.aed.RecipientInfos, cert)
+}
+
+// GetAuthAttributes gets the message's authenticated attributes. They should
+// only be trusted after Decrypt succeeds.
+func (aed *AuthEnvelopedData) GetAuthAttributes() protocol.Attributes {
+	return aed.aed.AuthAttrs
+}
+
+// Decrypt decrypts the message as the recipient with the given certificate,
+// checking that neither the content nor the authenticated attributes were
+// modified. key is the recipient's private key, as for EnvelopedData.Decrypt.
+func (aed *AuthEnvelopedData) Decrypt(cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	eci := aed.aed.AuthEncryptedContentInfo
+
+	keySize, ok := oid.AuthEncryptionAlgorithmToKeySize[eci.ContentEncryptionAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, errors.New("unsupported content encryption algorithm")
+	}
+
+	var params protocol.GCMParameters
+	if rest, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, protocol.ErrTrailingData
+	}
+
+	if params.ICVLen != len(aed.aed.MAC) {
+		return nil, errors.New("MAC length doesn't match parameters")
+	}
+
+	ciphertext, err := eci.EncryptedContentValue()
+	if err != nil {
+		return nil, err
+	}
+	if ciphertext == nil {
+		return nil, errors.New("missing encrypted content")
+	}
+
+	var aad []byte
+	if len(aed.aed.AuthAttrs) > 0 {
+		if ct, err := aed.aed.GetContentTypeAttribute(); err != nil {
+			return nil, err
+		} else if !ct.Equal(eci.ContentType) {
+			return nil, errors.New("content-type attribute doesn't match content")
+		}
+
+		if aad, err = aed.aed.AuthAttrs.MarshaledForVerification(); err != nil {
+			return nil, err
+		}
+	}
+
+	cek, err := decryptKey(aed.aed.RecipientInfos, cert, key, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(cek, len(params.Nonce), params.ICVLen)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, 0, len(ciphertext)+len(aed.aed.MAC))
+	sealed = append(sealed, ciphertext...)
+	sealed = append(sealed, aed.aed.MAC...)
+
+	plaintext, err := gcm.Open(nil, params.Nonce, sealed, aad)
+	if err != nil {
+		return nil, errors.New("decryption failed")
+	}
+
+	return plaintext, nil
+}
+
+// ToDER encodes this AuthEnvelopedData message using DER.
+func (aed *AuthEnvelopedData) ToDER() ([]byte, error) {
+	return aed.aed.ContentInfoDER()
+}
+
+// newGCM creates an AES-GCM AEAD with the given nonce and tag sizes. The
+// standard library can't combine a non-standard nonce size with a short tag,
+// so those aren't supported.
+func newGCM(key []byte, nonceSize, tagSize int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case nonceSize == gcmNonceSize:
+		return cipher.NewGCMWithTagSize(block, tagSize)
+	case tagSize == gcmTagSize && nonceSize > 0:
+		return cipher.NewGCMWithNonceSize(block, nonceSize)
+	default:
+		return nil, errors.New("unsupported AES-GCM parameters")
+	}
+}
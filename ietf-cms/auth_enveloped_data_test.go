@@ -0,0 +1,217 @@
+package cms
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/github/smimesign/fakeca"
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+)
+
+func TestAuthEncryptDecrypt(t *testing.T) {
+	data := []byte("hello, world!")
+
+	tests := []struct {
+		name  string
+		ident *fakeca.Identity
+		opts  EncryptOptions
+	}{
+		{name: "rsa", ident: leaf},
+		{name: "rsa-oaep", ident: leaf, opts: EncryptOptions{OAEP: true}},
+		{name: "rsa-aes128", ident: leaf, opts: EncryptOptions{KeySize: 16}},
+		{name: "p256", ident: p256Leaf},
+		{name: "p384-aes192", ident: p384Leaf, opts: EncryptOptions{KeySize: 24}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			der, err := AuthEncryptWithOptions(data, []*x509.Certificate{test.ident.Certificate}, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			aed, err := ParseAuthEnvelopedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !aed.IsRecipient(test.ident.Certificate) {
+				t.Fatal("expected certificate to be a recipient")
+			}
+
+			plaintext, err := aed.Decrypt(test.ident.Certificate, test.ident.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plaintext, data) {
+				t.Fatalf("expected %q, got %q", data, plaintext)
+			}
+
+			if _, err = aed.Decrypt(otherRoot.Certificate, otherRoot.PrivateKey); err != ErrNotRecipient {
+				t.Fatalf("expected ErrNotRecipient, got %v", err)
+			}
+		})
+	}
+
+	// EnvelopedData messages aren't AuthEnvelopedData.
+	der, err := Encrypt(data, []*x509.Certificate{leaf.Certificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseAuthEnvelopedData(der); err != protocol.ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestAuthEncryptAttributes(t *testing.T) {
+	data := []byte("hello, world!")
+
+	attr, err := protocol.NewAttribute(oid.AttributeSigningTime, "not really a time")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aed, err := NewAuthEnvelopedData(data, []*x509.Certificate{leaf.Certificate}, EncryptOptions{
+		AuthAttributes: protocol.Attributes{attr},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := aed.ToDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aed, err = ParseAuthEnvelopedData(der); err != nil {
+		t.Fatal(err)
+	}
+
+	attrs := aed.GetAuthAttributes()
+	if len(attrs) != 2 {
+		t.Fatalf("expected 2 authenticated attributes, got %d", len(attrs))
+	}
+	if !attrs.HasAttribute(oid.AttributeContentType) {
+		t.Fatal("expected content-type attribute to be added")
+	}
+
+	plaintext, err := aed.Decrypt(leaf.Certificate, leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, data) {
+		t.Fatalf("expected %q, got %q", data, plaintext)
+	}
+
+	// Changing the authenticated attributes breaks decryption.
+	aed.aed.AuthAttrs = aed.aed.AuthAttrs[:1]
+	if _, err = aed.Decrypt(leaf.Certificate, leaf.PrivateKey); err == nil {
+		t.Fatal("expected error with modified attributes")
+	}
+
+	if _, err = NewEnvelopedData(data, []*x509.Certificate{leaf.Certificate}, EncryptOptions{AuthAttributes: protocol.Attributes{attr}}); err == nil {
+		t.Fatal("expected error with EnvelopedData attributes")
+	}
+}
+
+func TestAuthDecryptTampered(t *testing.T) {
+	data := []byte("hello, world!")
+
+	tamper := map[string]func(*protocol.AuthEnvelopedData){
+		"ciphertext": func(aed *protocol.AuthEnvelopedData) {
+			aed.AuthEncryptedContentInfo.EncryptedContent.Bytes[0] ^= 1
+		},
+		"mac": func(aed *protocol.AuthEnvelopedData) {
+			aed.MAC[0] ^= 1
+		},
+		"truncated mac": func(aed *protocol.AuthEnvelopedData) {
+			aed.MAC = aed.MAC[:12]
+		},
+	}
+
+	for name, fn := range tamper {
+		t.Run(name, func(t *testing.T) {
+			aed, err := NewAuthEnvelopedData(data, []*x509.Certificate{leaf.Certificate}, EncryptOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fn(aed.aed)
+
+			if _, err = aed.Decrypt(leaf.Certificate, leaf.PrivateKey); err == nil {
+				t.Fatal("expected decryption error")
+			}
+		})
+	}
+}
+
+func TestAuthDecryptWithOpenSSL(t *testing.T) {
+	// Do not require this test to pass if openssl is not in the path
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("could not find openssl in path")
+	}
+
+	data := []byte("hello, world!")
+
+	for _, ident := range []*fakeca.Identity{leaf, p256Leaf} {
+		certFile := writeTempPEM(t, "CERTIFICATE", ident.Certificate.Raw)
+		defer os.Remove(certFile)
+
+		keyDER, err := x509.MarshalPKCS8PrivateKey(ident.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyFile := writeTempPEM(t, "PRIVATE KEY", keyDER)
+		defer os.Remove(keyFile)
+
+		dataFile, err := ioutil.TempFile("", "TestAuthDecryptWithOpenSSL_data_*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(dataFile.Name())
+		dataFile.Write(data)
+		dataFile.Close()
+
+		// We decrypt what openssl encrypts. Older versions of openssl can't
+		// make AuthEnvelopedData.
+		encrypted, err := exec.Command(opensslPath, "cms", "-encrypt", "-binary", "-aes-256-gcm",
+			"-in", dataFile.Name(), "-outform", "DER", certFile).Output()
+		if err != nil {
+			t.Skip("openssl doesn't support AuthEnvelopedData")
+		}
+
+		aed, err := ParseAuthEnvelopedData(encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, err := aed.Decrypt(ident.Certificate, ident.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plaintext, data) {
+			t.Fatalf("expected %q, got %q", data, plaintext)
+		}
+
+		// And openssl decrypts what we encrypt.
+		der, err := AuthEncrypt(data, []*x509.Certificate{ident.Certificate})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(opensslPath, "cms", "-decrypt", "-binary", "-inform", "DER",
+			"-recip", certFile, "-inkey", keyFile)
+		cmd.Stdin = bytes.NewReader(der)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("expected %q, got %q", data, out)
+		}
+	}
+}
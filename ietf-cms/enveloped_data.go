@@ -0,0 +1,236 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+)
+
+// EnvelopedData represents a message encrypted to one or more recipients.
+type EnvelopedData struct {
+	ed *protocol.EnvelopedData
+}
+
+// EncryptOptions are options for Encrypt and AuthEncrypt.
+type EncryptOptions struct {
+	// KeySize is the size of the AES content encryption key in bytes. It must
+	// be 16, 24 or 32. Zero means 32.
+	KeySize int
+
+	// OAEP encrypts the content encryption key for RSA recipients with
+	// RSAES-OAEP and SHA-256 rather than PKCS #1 v1.5.
+	OAEP bool
+
+	// AuthAttributes are authenticated along with the content. They are only
+	// supported by AuthEnvelopedData.
+	AuthAttributes protocol.Attributes
+}
+
+// Encrypt creates a CMS EnvelopedData with the content encrypted with AES-256
+// in CBC mode, and the content encryption key encrypted to each of the
+// recipient certificates. RSA recipients use PKCS #1 v1.5 key transport and EC
+// recipients use ECDH key agreement. The DER encoded CMS message is returned.
+func Encrypt(data []byte, recipients []*x509.Certificate) ([]byte, error) {
+	return EncryptWithOptions(data, recipients, EncryptOptions{})
+}
+
+// EncryptWithOptions is like Encrypt, but allows the content encryption key
+// size and RSA key transport algorithm to be chosen.
+func EncryptWithOptions(data []byte, recipients []*x509.Certificate, opts EncryptOptions) ([]byte, error) {
+	ed, err := NewEnvelopedData(data, recipients, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return ed.ToDER()
+}
+
+// NewEnvelopedData encrypts data to the recipient certificates.
+func NewEnvelopedData(data []byte, recipients []*x509.Certificate, opts EncryptOptions) (*EnvelopedData, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	if len(opts.AuthAttributes) > 0 {
+		return nil, errors.New("EnvelopedData doesn't support authenticated attributes")
+	}
+
+	keySize := opts.KeySize
+	if keySize == 0 {
+		keySize = 32
+	}
+
+	var algOID asn1.ObjectIdentifier
+	switch keySize {
+	case 16:
+		algOID = oid.EncryptionAlgorithmAES128CBC
+	case 24:
+		algOID = oid.EncryptionAlgorithmAES192CBC
+	case 32:
+		algOID = oid.EncryptionAlgorithmAES256CBC
+	default:
+		return nil, errors.New("bad key size")
+	}
+
+	cek := make([]byte, keySize)
+	if _, err := rand.Read(cek); err != nil {
+		return nil, err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := aesCBCEncrypt(cek, iv, data)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := marshalRawValue(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	ris, err := newRecipientInfos(cek, recipients, opts.OAEP)
+	if err != nil {
+		return nil, err
+	}
+
+	alg := pkix.AlgorithmIdentifier{Algorithm: algOID, Parameters: params}
+	eci := protocol.NewEncryptedContentInfo(oid.ContentTypeData, alg, ciphertext)
+
+	return &EnvelopedData{ed: protocol.NewEnvelopedData(ris, eci)}, nil
+}
+
+// ParseEnvelopedData parses an EnvelopedData from BER encoded data.
+func ParseEnvelopedData(ber []byte) (*EnvelopedData, error) {
+	ci, err := protocol.ParseContentInfo(ber)
+	if err != nil {
+		return nil, err
+	}
+
+	ed, err := ci.EnvelopedDataContent()
+	if err != nil {
+		return nil, err
+	}
+
+	return &EnvelopedData{ed: ed}, nil
+}
+
+// IsRecipient checks if the message was encrypted to cert.
+func (ed *EnvelopedData) IsRecipient(cert *x509.Certificate) bool {
+	return hasRecipient(ed.ed.RecipientInfos, cert)
+}
+
+// Decrypt decrypts the message as the recipient with the given certificate.
+// key is the recipient's private key. RSA keys must implement
+// crypto.Decrypter. EC keys must be an *ecdsa.PrivateKey or implement
+// KeyAgreer.
+func (ed *EnvelopedData) Decrypt(cert *x509.Certificate, key crypto.PrivateKey) ([]byte, error) {
+	eci := ed.ed.EncryptedContentInfo
+
+	keySize, ok := oid.EncryptionAlgorithmToKeySize[eci.ContentEncryptionAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, errors.New("unsupported content encryption algorithm")
+	}
+
+	var iv []byte
+	if rest, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, protocol.ErrTrailingData
+	}
+
+	ciphertext, err := eci.EncryptedContentValue()
+	if err != nil {
+		return nil, err
+	}
+	if ciphertext == nil {
+		return nil, errors.New("missing encrypted content")
+	}
+
+	cek, err := decryptKey(ed.ed.RecipientInfos, cert, key, keySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return aesCBCDecrypt(cek, iv, ciphertext)
+}
+
+// ToDER encodes this EnvelopedData message using DER.
+func (ed *EnvelopedData) ToDER() ([]byte, error) {
+	return ed.ed.ContentInfoDER()
+}
+
+// aesCBCEncrypt encrypts plaintext with AES in CBC mode, with PKCS #7 padding.
+func aesCBCEncrypt(key, iv, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := make([]byte, len(plaintext)+pad)
+	copy(padded, plaintext)
+	copy(padded[len(plaintext):], bytes.Repeat([]byte{byte(pad)}, pad))
+
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+
+	return padded, nil
+}
+
+// aesCBCDecrypt decrypts ciphertext with AES in CBC mode and removes the
+// PKCS #7 padding.
+func aesCBCDecrypt(key, iv, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("bad IV length")
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("bad ciphertext length")
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	// Check the padding without branching on its contents, so that a wrong
+	// key from PKCS #1 v1.5 decryption can't be told apart from bad padding.
+	pad := int(plaintext[len(plaintext)-1])
+	good := subtle.ConstantTimeLessOrEq(1, pad) & subtle.ConstantTimeLessOrEq(pad, aes.BlockSize)
+	for i := 1; i <= aes.BlockSize; i++ {
+		inPad := subtle.ConstantTimeLessOrEq(i, pad)
+		match := subtle.ConstantTimeByteEq(plaintext[len(plaintext)-i], byte(pad))
+		good &= subtle.ConstantTimeSelect(inPad, match, 1)
+	}
+	if good != 1 {
+		return nil, errors.New("decryption failed")
+	}
+
+	return plaintext[:len(plaintext)-pad], nil
+}
+
+// marshalRawValue DER encodes val and decodes it into a RawValue.
+func marshalRawValue(val interface{}) (rv asn1.RawValue, err error) {
+	var der []byte
+	if der, err = asn1.Marshal(val); err != nil {
+		return
+	}
+
+	_, err = asn1.Unmarshal(der, &rv)
+
+	return
+}
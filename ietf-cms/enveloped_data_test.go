@@ -0,0 +1,201 @@
+package cms
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/github/smimesign/fakeca"
+	"github.com/github/smimesign/ietf-cms/oid"
+)
+
+var (
+	p256Key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p256Leaf   = intermediate.Issue(fakeca.PrivateKey(p256Key))
+
+	p384Key, _ = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p384Leaf   = intermediate.Issue(fakeca.PrivateKey(p384Key))
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	data := []byte("hello, world!")
+
+	tests := []struct {
+		name  string
+		ident *fakeca.Identity
+		opts  EncryptOptions
+	}{
+		{name: "rsa", ident: leaf},
+		{name: "rsa-oaep", ident: leaf, opts: EncryptOptions{OAEP: true}},
+		{name: "rsa-aes128", ident: leaf, opts: EncryptOptions{KeySize: 16}},
+		{name: "p256", ident: p256Leaf},
+		{name: "p384-aes192", ident: p384Leaf, opts: EncryptOptions{KeySize: 24}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			der, err := EncryptWithOptions(data, []*x509.Certificate{test.ident.Certificate}, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ed, err := ParseEnvelopedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !ed.IsRecipient(test.ident.Certificate) {
+				t.Fatal("expected certificate to be a recipient")
+			}
+
+			plaintext, err := ed.Decrypt(test.ident.Certificate, test.ident.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(plaintext, data) {
+				t.Fatalf("expected %q, got %q", data, plaintext)
+			}
+
+			if ed.IsRecipient(otherRoot.Certificate) {
+				t.Fatal("expected other certificate not to be a recipient")
+			}
+			if _, err = ed.Decrypt(otherRoot.Certificate, otherRoot.PrivateKey); err != ErrNotRecipient {
+				t.Fatalf("expected ErrNotRecipient, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEncryptMultipleRecipients(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4)
+
+	ed, err := NewEnvelopedData(data, []*x509.Certificate{leaf.Certificate, p256Leaf.Certificate}, EncryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ed.ed.Version != 2 {
+		t.Fatalf("expected version 2 with key agreement recipient, got %d", ed.ed.Version)
+	}
+	if len(ed.ed.RecipientInfos) != 2 {
+		t.Fatalf("expected 2 recipient infos, got %d", len(ed.ed.RecipientInfos))
+	}
+	if !ed.ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm.Equal(oid.EncryptionAlgorithmAES256CBC) {
+		t.Fatal("expected AES-256-CBC by default")
+	}
+
+	for _, ident := range []*fakeca.Identity{leaf, p256Leaf} {
+		plaintext, err := ed.Decrypt(ident.Certificate, ident.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plaintext, data) {
+			t.Fatalf("expected %q, got %q", data, plaintext)
+		}
+	}
+
+	// RSA only recipients use version 0.
+	ed, err = NewEnvelopedData(data, []*x509.Certificate{leaf.Certificate}, EncryptOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ed.ed.Version != 0 {
+		t.Fatalf("expected version 0, got %d", ed.ed.Version)
+	}
+
+	if _, err = NewEnvelopedData(data, nil, EncryptOptions{}); err == nil {
+		t.Fatal("expected error without recipients")
+	}
+	if _, err = NewEnvelopedData(data, []*x509.Certificate{leaf.Certificate}, EncryptOptions{KeySize: 8}); err == nil {
+		t.Fatal("expected error with bad key size")
+	}
+}
+
+func TestDecryptWithOpenSSL(t *testing.T) {
+	// Do not require this test to pass if openssl is not in the path
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("could not find openssl in path")
+	}
+
+	data := []byte("hello, world!")
+
+	for _, ident := range []*fakeca.Identity{leaf, p256Leaf} {
+		certFile := writeTempPEM(t, "CERTIFICATE", ident.Certificate.Raw)
+		defer os.Remove(certFile)
+
+		keyDER, err := x509.MarshalPKCS8PrivateKey(ident.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyFile := writeTempPEM(t, "PRIVATE KEY", keyDER)
+		defer os.Remove(keyFile)
+
+		dataFile, err := ioutil.TempFile("", "TestDecryptWithOpenSSL_data_*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(dataFile.Name())
+		dataFile.Write(data)
+		dataFile.Close()
+
+		// We decrypt what openssl encrypts.
+		encrypted, err := exec.Command(opensslPath, "cms", "-encrypt", "-binary", "-aes256",
+			"-in", dataFile.Name(), "-outform", "DER", certFile).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ed, err := ParseEnvelopedData(encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, err := ed.Decrypt(ident.Certificate, ident.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plaintext, data) {
+			t.Fatalf("expected %q, got %q", data, plaintext)
+		}
+
+		// And openssl decrypts what we encrypt.
+		for _, opts := range []EncryptOptions{{}, {OAEP: true}} {
+			der, err := EncryptWithOptions(data, []*x509.Certificate{ident.Certificate}, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := exec.Command(opensslPath, "cms", "-decrypt", "-binary", "-inform", "DER",
+				"-recip", certFile, "-inkey", keyFile)
+			cmd.Stdin = bytes.NewReader(der)
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("expected %q, got %q", data, out)
+			}
+		}
+	}
+}
+
+func writeTempPEM(t *testing.T, typ string, der []byte) string {
+	f, err := ioutil.TempFile("", "TestDecryptWithOpenSSL_*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err = pem.Encode(f, &pem.Block{Type: typ, Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
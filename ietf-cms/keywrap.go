@@ -0,0 +1,104 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// keyWrapIV is the default initial value from RFC 3394 section 2.2.3.1.
+var keyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// errKeyUnwrap is returned when a wrapped key fails its integrity check.
+var errKeyUnwrap = errors.New("key unwrap failed")
+
+// aesKeyWrap wraps key with kek using the AES key wrap algorithm from RFC 3394.
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key) < 16 || len(key)%8 != 0 {
+		return nil, errors.New("bad key length for key wrap")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out, keyWrapIV)
+	copy(out[8:], key)
+
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf, out[:8])
+			copy(buf[8:], out[8*i:8*i+8])
+			block.Encrypt(buf, buf)
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(out[:8], binary.BigEndian.Uint64(buf[:8])^t)
+			copy(out[8*i:8*i+8], buf[8:])
+		}
+	}
+
+	return out, nil
+}
+
+// aesKeyUnwrap unwraps a key that was wrapped with kek using the AES key wrap
+// algorithm from RFC 3394.
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, errors.New("bad wrapped key length")
+	}
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(out[:8])^t)
+			copy(buf[8:], out[8*i:8*i+8])
+			block.Decrypt(buf, buf)
+
+			copy(out[:8], buf[:8])
+			copy(out[8*i:8*i+8], buf[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(out[:8], keyWrapIV) != 1 {
+		return nil, errKeyUnwrap
+	}
+
+	return out[8:], nil
+}
+
+// x963KDF derives a key of the given length from a shared secret using the
+// ANSI X9.63 key derivation function, as required by RFC 5753.
+func x963KDF(hash crypto.Hash, secret, sharedInfo []byte, length int) []byte {
+	var (
+		out     = make([]byte, 0, length+hash.Size())
+		counter = make([]byte, 4)
+	)
+
+	for i := uint32(1); len(out) < length; i++ {
+		binary.BigEndian.PutUint32(counter, i)
+
+		h := hash.New()
+		h.Write(secret)
+		h.Write(counter)
+		h.Write(sharedInfo)
+		out = h.Sum(out)
+	}
+
+	return out[:length]
+}
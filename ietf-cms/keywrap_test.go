@@ -0,0 +1,63 @@
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"testing"
+)
+
+func TestAESKeyWrap(t *testing.T) {
+	// Test vectors from RFC 3394 section 4.
+	tests := []struct {
+		kek, key, wrapped string
+	}{
+		{
+			kek:     "000102030405060708090A0B0C0D0E0F",
+			key:     "00112233445566778899AABBCCDDEEFF",
+			wrapped: "1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5",
+		},
+		{
+			kek:     "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			key:     "00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			wrapped: "28C9F404C4B810F4CBCCB35CFB87F8263F5786E2D80ED326CBC7F0E71A99F43BFB988B9B7A02DD21",
+		},
+	}
+
+	for _, test := range tests {
+		kek, _ := hex.DecodeString(test.kek)
+		key, _ := hex.DecodeString(test.key)
+		expected, _ := hex.DecodeString(test.wrapped)
+
+		wrapped, err := aesKeyWrap(kek, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wrapped, expected) {
+			t.Fatalf("expected %X, got %X", expected, wrapped)
+		}
+
+		unwrapped, err := aesKeyUnwrap(kek, wrapped)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Fatalf("expected %X, got %X", key, unwrapped)
+		}
+
+		wrapped[len(wrapped)-1] ^= 1
+		if _, err = aesKeyUnwrap(kek, wrapped); err != errKeyUnwrap {
+			t.Fatalf("expected errKeyUnwrap, got %v", err)
+		}
+	}
+}
+
+func TestX963KDF(t *testing.T) {
+	// Test vector from the NIST CAVS ANSI X9.63 KDF tests for SHA-256.
+	secret, _ := hex.DecodeString("96c05619d56c328ab95fe84b18264b08725b85e33fd34f08")
+	expected, _ := hex.DecodeString("443024c3dae66b95e6f5670601558f71")
+
+	if key := x963KDF(crypto.SHA256, secret, nil, 16); !bytes.Equal(key, expected) {
+		t.Fatalf("expected %X, got %X", expected, key)
+	}
+}
@@ -5,89 +5,187 @@ import (
 	"crypto"
 	"crypto/x509"
 	"encoding/asn1"
+
+	// Register the SHA-3 hashes so that their crypto.Hash values are
+	// available.
+	_ "golang.org/x/crypto/sha3"
 )
 
 var (
-	ContentTypeData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
-	ContentTypeSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
-	ContentTypeTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	ContentTypeData              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	ContentTypeSignedData        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	ContentTypeEnvelopedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	ContentTypeTSTInfo           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	ContentTypeAuthEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 23}
 
 	AttributeContentType    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
 	AttributeMessageDigest  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
 	AttributeSigningTime    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
 	AttributeTimeStampToken = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 14}
 
-	PublicKeyAlgorithmRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
-	PublicKeyAlgorithmECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
-
-	DigestAlgorithmSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
-	DigestAlgorithmMD5    = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}
-	DigestAlgorithmSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
-	DigestAlgorithmSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
-	DigestAlgorithmSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
-
-	SignatureAlgorithmMD2WithRSA      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 2}
-	SignatureAlgorithmMD5WithRSA      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 4}
-	SignatureAlgorithmSHA1WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
-	SignatureAlgorithmSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
-	SignatureAlgorithmSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
-	SignatureAlgorithmSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
-	SignatureAlgorithmRSAPSS          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
-	SignatureAlgorithmDSAWithSHA1     = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 3}
-	SignatureAlgorithmDSAWithSHA256   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 2}
-	SignatureAlgorithmECDSAWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
-	SignatureAlgorithmECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
-	SignatureAlgorithmECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
-	SignatureAlgorithmECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
-	SignatureAlgorithmISOSHA1WithRSA  = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 29}
+	PublicKeyAlgorithmRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	PublicKeyAlgorithmECDSA   = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	PublicKeyAlgorithmEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+	DigestAlgorithmSHA1       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	DigestAlgorithmMD5        = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}
+	DigestAlgorithmSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	DigestAlgorithmSHA384     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	DigestAlgorithmSHA512     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	DigestAlgorithmSHA224     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4}
+	DigestAlgorithmSHA512_256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 6}
+	DigestAlgorithmSHA3_256   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 8}
+	DigestAlgorithmSHA3_384   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 9}
+	DigestAlgorithmSHA3_512   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 10}
+
+	SignatureAlgorithmMD2WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 2}
+	SignatureAlgorithmMD5WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 4}
+	SignatureAlgorithmSHA1WithRSA       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	SignatureAlgorithmSHA256WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	SignatureAlgorithmSHA384WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	SignatureAlgorithmSHA512WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	SignatureAlgorithmSHA224WithRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 14}
+	SignatureAlgorithmSHA512_256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 16}
+	SignatureAlgorithmSHA3_256WithRSA   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 14}
+	SignatureAlgorithmSHA3_384WithRSA   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 15}
+	SignatureAlgorithmSHA3_512WithRSA   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 16}
+	SignatureAlgorithmRSAPSS            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	SignatureAlgorithmDSAWithSHA1       = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 3}
+	SignatureAlgorithmDSAWithSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 2}
+	SignatureAlgorithmECDSAWithSHA1     = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	SignatureAlgorithmECDSAWithSHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	SignatureAlgorithmECDSAWithSHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	SignatureAlgorithmECDSAWithSHA512   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+	SignatureAlgorithmECDSAWithSHA224   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 1}
+	SignatureAlgorithmECDSAWithSHA3_256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 10}
+	SignatureAlgorithmECDSAWithSHA3_384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 11}
+	SignatureAlgorithmECDSAWithSHA3_512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 3, 12}
+	SignatureAlgorithmISOSHA1WithRSA    = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 29}
+	SignatureAlgorithmEd25519           = asn1.ObjectIdentifier{1, 3, 101, 112}
 
 	ExtensionSubjectKeyIdentifier = asn1.ObjectIdentifier{2, 5, 29, 14}
+
+	EncryptionAlgorithmAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	EncryptionAlgorithmAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	EncryptionAlgorithmAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+
+	AuthEncryptionAlgorithmAES128GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 6}
+	AuthEncryptionAlgorithmAES192GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 26}
+	AuthEncryptionAlgorithmAES256GCM = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 46}
+
+	KeyEncryptionAlgorithmRSA     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	KeyEncryptionAlgorithmRSAOAEP = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	MaskGenerationFunctionMGF1    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	PSourceAlgorithmPSpecified    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 9}
+
+	KeyWrapAlgorithmAES128 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 5}
+	KeyWrapAlgorithmAES192 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 25}
+	KeyWrapAlgorithmAES256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 45}
+
+	KeyAgreementAlgorithmECDHSHA1KDF   = asn1.ObjectIdentifier{1, 3, 133, 16, 840, 63, 0, 2}
+	KeyAgreementAlgorithmECDHSHA256KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 1}
+	KeyAgreementAlgorithmECDHSHA384KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 2}
+	KeyAgreementAlgorithmECDHSHA512KDF = asn1.ObjectIdentifier{1, 3, 132, 1, 11, 3}
+
+	NamedCurveP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	NamedCurveP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	NamedCurveP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
 )
 
+// EncryptionAlgorithmToKeySize maps AES-CBC content encryption OIDs to their
+// key sizes in bytes.
+var EncryptionAlgorithmToKeySize = map[string]int{
+	EncryptionAlgorithmAES128CBC.String(): 16,
+	EncryptionAlgorithmAES192CBC.String(): 24,
+	EncryptionAlgorithmAES256CBC.String(): 32,
+}
+
+// AuthEncryptionAlgorithmToKeySize maps AES-GCM authenticated content
+// encryption OIDs to their key sizes in bytes.
+var AuthEncryptionAlgorithmToKeySize = map[string]int{
+	AuthEncryptionAlgorithmAES128GCM.String(): 16,
+	AuthEncryptionAlgorithmAES192GCM.String(): 24,
+	AuthEncryptionAlgorithmAES256GCM.String(): 32,
+}
+
+// KeyWrapAlgorithmToKeySize maps AES key wrap OIDs to their key sizes in bytes.
+var KeyWrapAlgorithmToKeySize = map[string]int{
+	KeyWrapAlgorithmAES128.String(): 16,
+	KeyWrapAlgorithmAES192.String(): 24,
+	KeyWrapAlgorithmAES256.String(): 32,
+}
+
+// KeyAgreementAlgorithmToKDFHash maps ECDH key agreement OIDs to the hash used
+// by their ANSI X9.63 key derivation functions.
+var KeyAgreementAlgorithmToKDFHash = map[string]crypto.Hash{
+	KeyAgreementAlgorithmECDHSHA1KDF.String():   crypto.SHA1,
+	KeyAgreementAlgorithmECDHSHA256KDF.String(): crypto.SHA256,
+	KeyAgreementAlgorithmECDHSHA384KDF.String(): crypto.SHA384,
+	KeyAgreementAlgorithmECDHSHA512KDF.String(): crypto.SHA512,
+}
+
 // DigestAlgorithmToCryptoHash maps digest OIDs to crypto.Hash values.
 var DigestAlgorithmToCryptoHash = map[string]crypto.Hash{
-	DigestAlgorithmSHA1.String():   crypto.SHA1,
-	DigestAlgorithmMD5.String():    crypto.MD5,
-	DigestAlgorithmSHA256.String(): crypto.SHA256,
-	DigestAlgorithmSHA384.String(): crypto.SHA384,
-	DigestAlgorithmSHA512.String(): crypto.SHA512,
+	DigestAlgorithmSHA1.String():       crypto.SHA1,
+	DigestAlgorithmMD5.String():        crypto.MD5,
+	DigestAlgorithmSHA256.String():     crypto.SHA256,
+	DigestAlgorithmSHA384.String():     crypto.SHA384,
+	DigestAlgorithmSHA512.String():     crypto.SHA512,
+	DigestAlgorithmSHA224.String():     crypto.SHA224,
+	DigestAlgorithmSHA512_256.String(): crypto.SHA512_256,
+	DigestAlgorithmSHA3_256.String():   crypto.SHA3_256,
+	DigestAlgorithmSHA3_384.String():   crypto.SHA3_384,
+	DigestAlgorithmSHA3_512.String():   crypto.SHA3_512,
 }
 
 // CryptoHashToDigestAlgorithm maps crypto.Hash values to digest OIDs.
 var CryptoHashToDigestAlgorithm = map[crypto.Hash]asn1.ObjectIdentifier{
-	crypto.SHA1:   DigestAlgorithmSHA1,
-	crypto.MD5:    DigestAlgorithmMD5,
-	crypto.SHA256: DigestAlgorithmSHA256,
-	crypto.SHA384: DigestAlgorithmSHA384,
-	crypto.SHA512: DigestAlgorithmSHA512,
+	crypto.SHA1:       DigestAlgorithmSHA1,
+	crypto.MD5:        DigestAlgorithmMD5,
+	crypto.SHA256:     DigestAlgorithmSHA256,
+	crypto.SHA384:     DigestAlgorithmSHA384,
+	crypto.SHA512:     DigestAlgorithmSHA512,
+	crypto.SHA224:     DigestAlgorithmSHA224,
+	crypto.SHA512_256: DigestAlgorithmSHA512_256,
+	crypto.SHA3_256:   DigestAlgorithmSHA3_256,
+	crypto.SHA3_384:   DigestAlgorithmSHA3_384,
+	crypto.SHA3_512:   DigestAlgorithmSHA3_512,
 }
 
 // X509SignatureAlgorithmToDigestAlgorithm maps x509.SignatureAlgorithm to
 // digestAlgorithm OIDs.
 var X509SignatureAlgorithmToDigestAlgorithm = map[x509.SignatureAlgorithm]asn1.ObjectIdentifier{
-	x509.SHA1WithRSA:     DigestAlgorithmSHA1,
-	x509.MD5WithRSA:      DigestAlgorithmMD5,
-	x509.SHA256WithRSA:   DigestAlgorithmSHA256,
-	x509.SHA384WithRSA:   DigestAlgorithmSHA384,
-	x509.SHA512WithRSA:   DigestAlgorithmSHA512,
-	x509.ECDSAWithSHA1:   DigestAlgorithmSHA1,
-	x509.ECDSAWithSHA256: DigestAlgorithmSHA256,
-	x509.ECDSAWithSHA384: DigestAlgorithmSHA384,
-	x509.ECDSAWithSHA512: DigestAlgorithmSHA512,
+	x509.SHA1WithRSA:      DigestAlgorithmSHA1,
+	x509.MD5WithRSA:       DigestAlgorithmMD5,
+	x509.SHA256WithRSA:    DigestAlgorithmSHA256,
+	x509.SHA384WithRSA:    DigestAlgorithmSHA384,
+	x509.SHA512WithRSA:    DigestAlgorithmSHA512,
+	x509.ECDSAWithSHA1:    DigestAlgorithmSHA1,
+	x509.ECDSAWithSHA256:  DigestAlgorithmSHA256,
+	x509.ECDSAWithSHA384:  DigestAlgorithmSHA384,
+	x509.ECDSAWithSHA512:  DigestAlgorithmSHA512,
+	x509.SHA256WithRSAPSS: DigestAlgorithmSHA256,
+	x509.SHA384WithRSAPSS: DigestAlgorithmSHA384,
+	x509.SHA512WithRSAPSS: DigestAlgorithmSHA512,
+	x509.PureEd25519:      DigestAlgorithmSHA512,
 }
 
 // X509SignatureAlgorithmToPublicKeyAlgorithm maps x509.SignatureAlgorithm to
 // signatureAlgorithm OIDs.
 var X509SignatureAlgorithmToPublicKeyAlgorithm = map[x509.SignatureAlgorithm]asn1.ObjectIdentifier{
-	x509.SHA1WithRSA:     PublicKeyAlgorithmRSA,
-	x509.MD5WithRSA:      PublicKeyAlgorithmRSA,
-	x509.SHA256WithRSA:   PublicKeyAlgorithmRSA,
-	x509.SHA384WithRSA:   PublicKeyAlgorithmRSA,
-	x509.SHA512WithRSA:   PublicKeyAlgorithmRSA,
-	x509.ECDSAWithSHA1:   PublicKeyAlgorithmECDSA,
-	x509.ECDSAWithSHA256: PublicKeyAlgorithmECDSA,
-	x509.ECDSAWithSHA384: PublicKeyAlgorithmECDSA,
-	x509.ECDSAWithSHA512: PublicKeyAlgorithmECDSA,
+	x509.SHA1WithRSA:      PublicKeyAlgorithmRSA,
+	x509.MD5WithRSA:       PublicKeyAlgorithmRSA,
+	x509.SHA256WithRSA:    PublicKeyAlgorithmRSA,
+	x509.SHA384WithRSA:    PublicKeyAlgorithmRSA,
+	x509.SHA512WithRSA:    PublicKeyAlgorithmRSA,
+	x509.ECDSAWithSHA1:    PublicKeyAlgorithmECDSA,
+	x509.ECDSAWithSHA256:  PublicKeyAlgorithmECDSA,
+	x509.ECDSAWithSHA384:  PublicKeyAlgorithmECDSA,
+	x509.ECDSAWithSHA512:  PublicKeyAlgorithmECDSA,
+	x509.SHA256WithRSAPSS: PublicKeyAlgorithmRSA,
+	x509.SHA384WithRSAPSS: PublicKeyAlgorithmRSA,
+	x509.SHA512WithRSAPSS: PublicKeyAlgorithmRSA,
+	x509.PureEd25519:      PublicKeyAlgorithmEd25519,
 }
 
 // PublicKeyAndDigestAlgorithmToX509SignatureAlgorithm maps digest and signature
@@ -106,6 +204,9 @@ var PublicKeyAndDigestAlgorithmToX509SignatureAlgorithm = map[string]map[string]
 		DigestAlgorithmSHA384.String(): x509.ECDSAWithSHA384,
 		DigestAlgorithmSHA512.String(): x509.ECDSAWithSHA512,
 	},
+	PublicKeyAlgorithmEd25519.String(): map[string]x509.SignatureAlgorithm{
+		DigestAlgorithmSHA512.String(): x509.PureEd25519,
+	},
 }
 
 // SignatureAlgorithmToX509SignatureAlgorithm maps signature algorithm OIDs to
@@ -123,20 +224,76 @@ var SignatureAlgorithmToX509SignatureAlgorithm = map[string]x509.SignatureAlgori
 	SignatureAlgorithmDSAWithSHA1.String():     x509.DSAWithSHA1,
 }
 
+// SignatureAlgorithmToX509PublicKeyAlgorithm maps RSA and ECDSA signature
+// algorithm OIDs to x509.PublicKeyAlgorithm values. Along with
+// SignatureAlgorithmToDigestAlgorithm, this describes signature algorithms
+// that have no x509.SignatureAlgorithm, such as those using SHA-224,
+// SHA-512/256 or SHA-3 digests.
+var SignatureAlgorithmToX509PublicKeyAlgorithm = map[string]x509.PublicKeyAlgorithm{
+	PublicKeyAlgorithmRSA.String():               x509.RSA,
+	SignatureAlgorithmRSAPSS.String():            x509.RSA,
+	SignatureAlgorithmSHA224WithRSA.String():     x509.RSA,
+	SignatureAlgorithmSHA512_256WithRSA.String(): x509.RSA,
+	SignatureAlgorithmSHA3_256WithRSA.String():   x509.RSA,
+	SignatureAlgorithmSHA3_384WithRSA.String():   x509.RSA,
+	SignatureAlgorithmSHA3_512WithRSA.String():   x509.RSA,
+	PublicKeyAlgorithmECDSA.String():             x509.ECDSA,
+	SignatureAlgorithmECDSAWithSHA224.String():   x509.ECDSA,
+	SignatureAlgorithmECDSAWithSHA3_256.String(): x509.ECDSA,
+	SignatureAlgorithmECDSAWithSHA3_384.String(): x509.ECDSA,
+	SignatureAlgorithmECDSAWithSHA3_512.String(): x509.ECDSA,
+}
+
+// SignatureAlgorithmToDigestAlgorithm maps the signature algorithm OIDs in
+// SignatureAlgorithmToX509PublicKeyAlgorithm that name a digest algorithm to
+// that digest algorithm's OID.
+var SignatureAlgorithmToDigestAlgorithm = map[string]asn1.ObjectIdentifier{
+	SignatureAlgorithmSHA224WithRSA.String():     DigestAlgorithmSHA224,
+	SignatureAlgorithmSHA512_256WithRSA.String(): DigestAlgorithmSHA512_256,
+	SignatureAlgorithmSHA3_256WithRSA.String():   DigestAlgorithmSHA3_256,
+	SignatureAlgorithmSHA3_384WithRSA.String():   DigestAlgorithmSHA3_384,
+	SignatureAlgorithmSHA3_512WithRSA.String():   DigestAlgorithmSHA3_512,
+	SignatureAlgorithmECDSAWithSHA224.String():   DigestAlgorithmSHA224,
+	SignatureAlgorithmECDSAWithSHA3_256.String(): DigestAlgorithmSHA3_256,
+	SignatureAlgorithmECDSAWithSHA3_384.String(): DigestAlgorithmSHA3_384,
+	SignatureAlgorithmECDSAWithSHA3_512.String(): DigestAlgorithmSHA3_512,
+}
+
+// DigestAlgorithmToX509RSAPSSSignatureAlgorithm maps the digest algorithm
+// of RSASSA-PSS parameters to x509.SignatureAlgorithm values. The standard
+// library doesn't support RSASSA-PSS with SHA-1.
+var DigestAlgorithmToX509RSAPSSSignatureAlgorithm = map[string]x509.SignatureAlgorithm{
+	DigestAlgorithmSHA256.String(): x509.SHA256WithRSAPSS,
+	DigestAlgorithmSHA384.String(): x509.SHA384WithRSAPSS,
+	DigestAlgorithmSHA512.String(): x509.SHA512WithRSAPSS,
+}
+
 // X509PublicKeyAndDigestAlgorithmToSignatureAlgorithm maps X509 public key and
 // digest algorithms to to SignatureAlgorithm OIDs.
 var X509PublicKeyAndDigestAlgorithmToSignatureAlgorithm = map[x509.PublicKeyAlgorithm]map[string]asn1.ObjectIdentifier{
 	x509.RSA: map[string]asn1.ObjectIdentifier{
-		DigestAlgorithmSHA1.String():   SignatureAlgorithmSHA1WithRSA,
-		DigestAlgorithmMD5.String():    SignatureAlgorithmMD5WithRSA,
-		DigestAlgorithmSHA256.String(): SignatureAlgorithmSHA256WithRSA,
-		DigestAlgorithmSHA384.String(): SignatureAlgorithmSHA384WithRSA,
-		DigestAlgorithmSHA512.String(): SignatureAlgorithmSHA512WithRSA,
+		DigestAlgorithmSHA1.String():       SignatureAlgorithmSHA1WithRSA,
+		DigestAlgorithmMD5.String():        SignatureAlgorithmMD5WithRSA,
+		DigestAlgorithmSHA256.String():     SignatureAlgorithmSHA256WithRSA,
+		DigestAlgorithmSHA384.String():     SignatureAlgorithmSHA384WithRSA,
+		DigestAlgorithmSHA512.String():     SignatureAlgorithmSHA512WithRSA,
+		DigestAlgorithmSHA224.String():     SignatureAlgorithmSHA224WithRSA,
+		DigestAlgorithmSHA512_256.String(): SignatureAlgorithmSHA512_256WithRSA,
+		DigestAlgorithmSHA3_256.String():   SignatureAlgorithmSHA3_256WithRSA,
+		DigestAlgorithmSHA3_384.String():   SignatureAlgorithmSHA3_384WithRSA,
+		DigestAlgorithmSHA3_512.String():   SignatureAlgorithmSHA3_512WithRSA,
 	},
 	x509.ECDSA: map[string]asn1.ObjectIdentifier{
-		DigestAlgorithmSHA1.String():   SignatureAlgorithmECDSAWithSHA1,
-		DigestAlgorithmSHA256.String(): SignatureAlgorithmECDSAWithSHA256,
-		DigestAlgorithmSHA384.String(): SignatureAlgorithmECDSAWithSHA384,
-		DigestAlgorithmSHA512.String(): SignatureAlgorithmECDSAWithSHA512,
+		DigestAlgorithmSHA1.String():     SignatureAlgorithmECDSAWithSHA1,
+		DigestAlgorithmSHA256.String():   SignatureAlgorithmECDSAWithSHA256,
+		DigestAlgorithmSHA384.String():   SignatureAlgorithmECDSAWithSHA384,
+		DigestAlgorithmSHA512.String():   SignatureAlgorithmECDSAWithSHA512,
+		DigestAlgorithmSHA224.String():   SignatureAlgorithmECDSAWithSHA224,
+		DigestAlgorithmSHA3_256.String(): SignatureAlgorithmECDSAWithSHA3_256,
+		DigestAlgorithmSHA3_384.String(): SignatureAlgorithmECDSAWithSHA3_384,
+		DigestAlgorithmSHA3_512.String(): SignatureAlgorithmECDSAWithSHA3_512,
+	},
+	x509.Ed25519: map[string]asn1.ObjectIdentifier{
+		DigestAlgorithmSHA512.String(): SignatureAlgorithmEd25519,
 	},
 }
@@ -0,0 +1,159 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+)
+
+// AlgorithmPolicy restricts the cryptographic algorithms that are accepted when
+// verifying signatures, their certificate chains, and their timestamps.
+type AlgorithmPolicy struct {
+	// Hashes are the accepted digest algorithms. Nil accepts any supported
+	// digest algorithm.
+	Hashes []crypto.Hash
+
+	// MinRSABits is the minimum size of RSA keys, in bits.
+	MinRSABits int
+
+	// Curves are the accepted ECDSA curves. Nil accepts any supported curve.
+	Curves []elliptic.Curve
+}
+
+// DefaultAlgorithmPolicy rejects MD5 and SHA-1, RSA keys under 2048 bits, and
+// curves other than the NIST P-256, P-384, and P-521 curves.
+var DefaultAlgorithmPolicy = AlgorithmPolicy{
+	Hashes: []crypto.Hash{
+		crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512, crypto.SHA512_256,
+		crypto.SHA3_256, crypto.SHA3_384, crypto.SHA3_512,
+	},
+	MinRSABits: 2048,
+	Curves:     []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()},
+}
+
+// AlgorithmPolicyError is returned when a signature or certificate uses an
+// algorithm that isn't allowed by the AlgorithmPolicy.
+type AlgorithmPolicyError struct {
+	Message string
+}
+
+func (err AlgorithmPolicyError) Error() string {
+	return "algorithm policy: " + err.Message
+}
+
+// checkHash checks that a digest algorithm is allowed.
+func (p *AlgorithmPolicy) checkHash(hash crypto.Hash) error {
+	if p == nil || p.Hashes == nil {
+		return nil
+	}
+
+	for _, h := range p.Hashes {
+		if h == hash {
+			return nil
+		}
+	}
+
+	return AlgorithmPolicyError{fmt.Sprintf("digest algorithm %s not allowed", hash)}
+}
+
+// checkSignatureAlgorithm checks that the digest algorithm used by a signature
+// algorithm is allowed.
+func (p *AlgorithmPolicy) checkSignatureAlgorithm(algo x509.SignatureAlgorithm) error {
+	if p == nil || p.Hashes == nil {
+		return nil
+	}
+
+	digestOID, ok := oid.X509SignatureAlgorithmToDigestAlgorithm[algo]
+	if !ok {
+		return AlgorithmPolicyError{fmt.Sprintf("signature algorithm %s not allowed", algo)}
+	}
+
+	return p.checkHash(oid.DigestAlgorithmToCryptoHash[digestOID.String()])
+}
+
+// checkPublicKey checks that the type and size of a public key are allowed.
+func (p *AlgorithmPolicy) checkPublicKey(pub crypto.PublicKey) error {
+	if p == nil {
+		return nil
+	}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if bits := pub.N.BitLen(); bits < p.MinRSABits {
+			return AlgorithmPolicyError{fmt.Sprintf("%d bit RSA key not allowed", bits)}
+		}
+	case *ecdsa.PublicKey:
+		if p.Curves == nil {
+			return nil
+		}
+		for _, c := range p.Curves {
+			if c == pub.Curve {
+				return nil
+			}
+		}
+		return AlgorithmPolicyError{fmt.Sprintf("curve %s not allowed", pub.Curve.Params().Name)}
+	case ed25519.PublicKey:
+		// Ed25519 keys have a fixed size and curve.
+	default:
+		return AlgorithmPolicyError{fmt.Sprintf("public key type %T not allowed", pub)}
+	}
+
+	return nil
+}
+
+// checkChain checks the keys of each certificate in the chain, and the
+// signature algorithms of each certificate other than the self-signed root.
+func (p *AlgorithmPolicy) checkChain(chain []*x509.Certificate) error {
+	if p == nil {
+		return nil
+	}
+
+	for i, cert := range chain {
+		if err := p.checkPublicKey(cert.PublicKey); err != nil {
+			return err
+		}
+		if i == len(chain)-1 {
+			break
+		}
+		if err := p.checkSignatureAlgorithm(cert.SignatureAlgorithm); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// filterChains returns the chains that are allowed by the policy. If none are,
+// the error from the first chain is returned.
+func (p *AlgorithmPolicy) filterChains(chains [][]*x509.Certificate) ([][]*x509.Certificate, error) {
+	if p == nil {
+		return chains, nil
+	}
+
+	var (
+		allowed  [][]*x509.Certificate
+		firstErr error
+	)
+
+	for _, chain := range chains {
+		if err := p.checkChain(chain); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		allowed = append(allowed, chain)
+	}
+
+	if len(allowed) == 0 {
+		return nil, firstErr
+	}
+
+	return allowed, nil
+}
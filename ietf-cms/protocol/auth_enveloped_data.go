@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"encoding/asn1"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+)
+
+// AuthEnvelopedData ::= SEQUENCE {
+//   version CMSVersion,
+//   originatorInfo [0] IMPLICIT OriginatorInfo OPTIONAL,
+//   recipientInfos RecipientInfos,
+//   authEncryptedContentInfo EncryptedContentInfo,
+//   authAttrs [1] IMPLICIT AuthAttributes OPTIONAL,
+//   mac MessageAuthenticationCode,
+//   unauthAttrs [2] IMPLICIT UnauthAttributes OPTIONAL }
+//
+// AuthAttributes ::= SET SIZE (1..MAX) OF Attribute
+//
+// UnauthAttributes ::= SET SIZE (1..MAX) OF Attribute
+//
+// MessageAuthenticationCode ::= OCTET STRING
+type AuthEnvelopedData struct {
+	Version                  int
+	OriginatorInfo           asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos           []asn1.RawValue `asn1:"set"`
+	AuthEncryptedContentInfo EncryptedContentInfo
+	AuthAttrs                Attributes `asn1:"set,optional,tag:1"`
+	MAC                      []byte
+	UnauthAttrs              Attributes `asn1:"set,optional,tag:2"`
+}
+
+// NewAuthEnvelopedData creates a new AuthEnvelopedData from its recipient
+// infos, encrypted content, authenticated attributes and MAC.
+func NewAuthEnvelopedData(recipientInfos []asn1.RawValue, eci EncryptedContentInfo, authAttrs Attributes, mac []byte) *AuthEnvelopedData {
+	return &AuthEnvelopedData{
+		// The version is always 0.
+		Version:                  0,
+		RecipientInfos:           recipientInfos,
+		AuthEncryptedContentInfo: eci,
+		AuthAttrs:                authAttrs,
+		MAC:                      mac,
+	}
+}
+
+// AuthEnvelopedDataContent gets the content assuming contentType is
+// authEnvelopedData.
+func (ci ContentInfo) AuthEnvelopedDataContent() (*AuthEnvelopedData, error) {
+	if !ci.ContentType.Equal(oid.ContentTypeAuthEnvelopedData) {
+		return nil, ErrWrongType
+	}
+
+	aed := new(AuthEnvelopedData)
+	if rest, err := asn1.Unmarshal(ci.Content.Bytes, aed); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, ErrTrailingData
+	}
+
+	return aed, nil
+}
+
+// ContentInfoDER returns the AuthEnvelopedData wrapped in a ContentInfo packet
+// and DER encoded.
+func (aed *AuthEnvelopedData) ContentInfoDER() ([]byte, error) {
+	der, err := asn1.Marshal(*aed)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ContentInfo{
+		ContentType: oid.ContentTypeAuthEnvelopedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			Bytes:      der,
+			IsCompound: true,
+		},
+	})
+}
+
+// GetContentTypeAttribute gets the authenticated ContentType attribute from
+// the AuthEnvelopedData.
+func (aed *AuthEnvelopedData) GetContentTypeAttribute() (asn1.ObjectIdentifier, error) {
+	rv, err := aed.AuthAttrs.GetOnlyAttributeValueBytes(oid.AttributeContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	var ct asn1.ObjectIdentifier
+	if rest, err := asn1.Unmarshal(rv.FullBytes, &ct); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, ErrTrailingData
+	}
+
+	return ct, nil
+}
+
+// GCMParameters ::= SEQUENCE {
+//   aes-nonce        OCTET STRING, -- recommended size is 12 octets
+//   aes-ICVlen       AES-GCMICVlen DEFAULT 12 }
+//
+// AES-GCMICVlen ::= INTEGER (12 | 13 | 14 | 15 | 16)
+//
+// These are the parameters of the AES-GCM content authenticated encryption
+// algorithms, from RFC 5084.
+type GCMParameters struct {
+	Nonce  []byte
+	ICVLen int `asn1:"optional,default:12"`
+}
@@ -0,0 +1,168 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+)
+
+// berChunkSize is the size of the OCTET STRING chunks that encapsulated
+// content is split into by WriteBER.
+const berChunkSize = 4096
+
+var (
+	// Identifier and length octets for constructed types with indefinite
+	// lengths.
+	berSequenceIndefinite    = []byte{0x30, 0x80}
+	berExplicit0Indefinite   = []byte{0xA0, 0x80}
+	berOctetStringIndefinite = []byte{0x24, 0x80}
+
+	// berEndOfContents terminates an indefinite length value.
+	berEndOfContents = []byte{0x00, 0x00}
+)
+
+// WriteBER writes the SignedData wrapped in a ContentInfo to w, encoded as BER
+// with indefinite lengths, so that the encapsulated content never needs to be
+// held in memory. The encapsulated content is read from content and written as
+// a constructed OCTET STRING made of chunks, like gpgsm does. The SignedData
+// must not already have encapsulated content.
+//
+// Since the version and digest algorithms are written before the content, they
+// must be set before calling WriteBER. finish, if not nil, is called once all
+// of content has been written. It may add SignerInfos, certificates and CRLs
+// that depend on the content, but must not add new digest algorithms.
+func (sd *SignedData) WriteBER(w io.Writer, content io.Reader, finish func() error) error {
+	if sd.EncapContentInfo.EContent.Bytes != nil {
+		return errors.New("content already encapsulated")
+	}
+
+	head, err := marshalInner(struct {
+		Version          int
+		DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	}{sd.Version, sd.DigestAlgorithms})
+	if err != nil {
+		return err
+	}
+
+	contentType, err := asn1.Marshal(oid.ContentTypeSignedData)
+	if err != nil {
+		return err
+	}
+
+	eContentType, err := asn1.Marshal(sd.EncapContentInfo.EContentType)
+	if err != nil {
+		return err
+	}
+
+	// ContentInfo, its [0] EXPLICIT content, the SignedData and its
+	// EncapsulatedContentInfo.
+	if err = writeAll(w,
+		berSequenceIndefinite, contentType,
+		berExplicit0Indefinite,
+		berSequenceIndefinite, head,
+		berSequenceIndefinite, eContentType,
+		berExplicit0Indefinite, berOctetStringIndefinite,
+	); err != nil {
+		return err
+	}
+
+	if err = writeOctetStringChunks(w, content); err != nil {
+		return err
+	}
+
+	// End the OCTET STRING, the [0] EXPLICIT eContent and the
+	// EncapsulatedContentInfo.
+	if err = writeAll(w, berEndOfContents, berEndOfContents, berEndOfContents); err != nil {
+		return err
+	}
+
+	if finish != nil {
+		if err = finish(); err != nil {
+			return err
+		}
+	}
+
+	if newHead, err := marshalInner(struct {
+		Version          int
+		DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	}{sd.Version, sd.DigestAlgorithms}); err != nil {
+		return err
+	} else if !bytes.Equal(head, newHead) {
+		return errors.New("version or digest algorithms changed after being written")
+	}
+
+	tail, err := marshalInner(struct {
+		Certificates []asn1.RawValue `asn1:"optional,set,tag:0"`
+		CRLs         []asn1.RawValue `asn1:"optional,set,tag:1"`
+		SignerInfos  []SignerInfo    `asn1:"set"`
+	}{sd.Certificates, sd.CRLs, sd.SignerInfos})
+	if err != nil {
+		return err
+	}
+
+	// End the SignedData, the [0] EXPLICIT content and the ContentInfo.
+	return writeAll(w, tail, berEndOfContents, berEndOfContents, berEndOfContents)
+}
+
+// writeOctetStringChunks reads r until EOF, writing it to w as a series of
+// primitive OCTET STRINGs. At least one chunk is written, even if r is empty,
+// since BER2DER can't parse empty constructed values.
+func writeOctetStringChunks(w io.Writer, r io.Reader) error {
+	var (
+		chunk  = make([]byte, berChunkSize)
+		header bytes.Buffer
+	)
+
+	for first := true; ; first = false {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 || (first && err == io.EOF) {
+			header.Reset()
+			header.WriteByte(asn1.TagOctetString)
+			if err := encodeLength(&header, n); err != nil {
+				return err
+			}
+
+			if err := writeAll(w, header.Bytes(), chunk[:n]); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// marshalInner DER encodes a struct as a SEQUENCE and returns the encoded
+// fields, without the SEQUENCE's identifier and length octets.
+func marshalInner(val interface{}) ([]byte, error) {
+	der, err := asn1.Marshal(val)
+	if err != nil {
+		return nil, err
+	}
+
+	var seq asn1.RawValue
+	if _, err = asn1.Unmarshal(der, &seq); err != nil {
+		return nil, err
+	}
+
+	return seq.Bytes, nil
+}
+
+// writeAll writes each of bufs to w.
+func writeAll(w io.Writer, bufs ...[]byte) error {
+	for _, buf := range bufs {
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
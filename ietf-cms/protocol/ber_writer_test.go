@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+)
+
+func TestWriteBER(t *testing.T) {
+	// More than two chunks, so the last chunk is short.
+	content := bytes.Repeat([]byte("hello, world!\n"), 2*berChunkSize/10)
+
+	sd, err := NewSignedData(EncapsulatedContentInfo{EContentType: oid.ContentTypeData})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		ber      bytes.Buffer
+		finished bool
+	)
+	if err = sd.WriteBER(&ber, bytes.NewReader(content), func() error {
+		finished = true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !finished {
+		t.Fatal("expected finish to be called")
+	}
+
+	// Content must be chunked inside an indefinite length OCTET STRING.
+	if !bytes.Contains(ber.Bytes(), []byte{0xA0, 0x80, 0x24, 0x80, 0x04, 0x82, 0x10, 0x00}) {
+		t.Fatal("expected chunked constructed OCTET STRING")
+	}
+
+	ci, err := ParseContentInfo(ber.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd2, err := ci.SignedDataContent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sd2.Version != sd.Version {
+		t.Fatalf("expected version %d, got %d", sd.Version, sd2.Version)
+	}
+	if !sd2.EncapContentInfo.EContentType.Equal(oid.ContentTypeData) {
+		t.Fatal("expected data content type")
+	}
+
+	ec, err := sd2.EncapContentInfo.EContentValue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ec, content) {
+		t.Fatal("content didn't round trip")
+	}
+
+	// Empty content.
+	ber.Reset()
+	if err = sd.WriteBER(&ber, bytes.NewReader(nil), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseContentInfo(ber.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Digest algorithms can't be added once they have been written.
+	ber.Reset()
+	if err = sd.WriteBER(&ber, bytes.NewReader(content), func() error {
+		sd.addDigestAlgorithm(DigestAlgorithmForPublicKey(nil))
+		return nil
+	}); err == nil {
+		t.Fatal("expected error adding digest algorithm in finish")
+	}
+
+	// Content that is already encapsulated can't be written.
+	eci, err := NewDataEncapsulatedContentInfo(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attached, err := NewSignedData(eci)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = attached.WriteBER(&ber, bytes.NewReader(content), nil); err == nil {
+		t.Fatal("expected error writing encapsulated content")
+	}
+}
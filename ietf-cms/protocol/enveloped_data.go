@@ -0,0 +1,379 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+)
+
+// EnvelopedData ::= SEQUENCE {
+//   version CMSVersion,
+//   originatorInfo [0] IMPLICIT OriginatorInfo OPTIONAL,
+//   recipientInfos RecipientInfos,
+//   encryptedContentInfo EncryptedContentInfo,
+//   unprotectedAttrs [1] IMPLICIT UnprotectedAttributes OPTIONAL }
+//
+// RecipientInfos ::= SET SIZE (1..MAX) OF RecipientInfo
+//
+// UnprotectedAttributes ::= SET SIZE (1..MAX) OF Attribute
+type EnvelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo EncryptedContentInfo
+	UnprotectedAttrs     Attributes `asn1:"set,optional,tag:1"`
+}
+
+// NewEnvelopedData creates a new EnvelopedData from its recipient infos and
+// encrypted content.
+func NewEnvelopedData(recipientInfos []asn1.RawValue, eci EncryptedContentInfo) *EnvelopedData {
+	// Version 0 is used unless there are recipient infos other than
+	// KeyTransRecipientInfos with issuerAndSerialNumber identifiers.
+	version := 0
+	for _, ri := range recipientInfos {
+		if ri.Class != asn1.ClassUniversal || ri.Tag != asn1.TagSequence {
+			version = 2
+		} else if ktri, err := ParseKeyTransRecipientInfo(ri); err != nil || ktri.Version != 0 {
+			version = 2
+		}
+	}
+
+	return &EnvelopedData{
+		Version:              version,
+		RecipientInfos:       recipientInfos,
+		EncryptedContentInfo: eci,
+	}
+}
+
+// EnvelopedDataContent gets the content assuming contentType is
+// envelopedData.
+func (ci ContentInfo) EnvelopedDataContent() (*EnvelopedData, error) {
+	if !ci.ContentType.Equal(oid.ContentTypeEnvelopedData) {
+		return nil, ErrWrongType
+	}
+
+	ed := new(EnvelopedData)
+	if rest, err := asn1.Unmarshal(ci.Content.Bytes, ed); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, ErrTrailingData
+	}
+
+	return ed, nil
+}
+
+// ContentInfoDER returns the EnvelopedData wrapped in a ContentInfo packet and
+// DER encoded.
+func (ed *EnvelopedData) ContentInfoDER() ([]byte, error) {
+	der, err := asn1.Marshal(*ed)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(ContentInfo{
+		ContentType: oid.ContentTypeEnvelopedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			Bytes:      der,
+			IsCompound: true,
+		},
+	})
+}
+
+// EncryptedContentInfo ::= SEQUENCE {
+//   contentType ContentType,
+//   contentEncryptionAlgorithm ContentEncryptionAlgorithmIdentifier,
+//   encryptedContent [0] IMPLICIT EncryptedContent OPTIONAL }
+//
+// ContentEncryptionAlgorithmIdentifier ::= AlgorithmIdentifier
+//
+// EncryptedContent ::= OCTET STRING
+type EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// NewEncryptedContentInfo creates a new EncryptedContentInfo.
+func NewEncryptedContentInfo(contentType asn1.ObjectIdentifier, alg pkix.AlgorithmIdentifier, ciphertext []byte) EncryptedContentInfo {
+	return EncryptedContentInfo{
+		ContentType:                contentType,
+		ContentEncryptionAlgorithm: alg,
+		EncryptedContent: asn1.RawValue{
+			Class: asn1.ClassContextSpecific,
+			Tag:   0,
+			Bytes: ciphertext,
+		},
+	}
+}
+
+// EncryptedContentValue gets the encrypted content. Like EContent, gpgsm
+// encodes it as a constructed string, which is joined back together here. A
+// nil byte slice is returned if the OPTIONAL encryptedContent field is
+// missing.
+func (eci EncryptedContentInfo) EncryptedContentValue() ([]byte, error) {
+	if eci.EncryptedContent.Bytes == nil {
+		return nil, nil
+	}
+
+	if !eci.EncryptedContent.IsCompound {
+		return eci.EncryptedContent.Bytes, nil
+	}
+
+	var (
+		value  = []byte{}
+		octets asn1.RawValue
+		rest   = eci.EncryptedContent.Bytes
+	)
+
+	for len(rest) > 0 {
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &octets); err != nil {
+			return nil, err
+		}
+
+		// Don't allow further constructed types.
+		if octets.Class != asn1.ClassUniversal || octets.Tag != asn1.TagOctetString || octets.IsCompound {
+			return nil, ASN1Error{"bad class or tag"}
+		}
+
+		value = append(value, octets.Bytes...)
+	}
+
+	return value, nil
+}
+
+// KeyTransRecipientInfo ::= SEQUENCE {
+//   version CMSVersion,  -- always set to 0 or 2
+//   rid RecipientIdentifier,
+//   keyEncryptionAlgorithm KeyEncryptionAlgorithmIdentifier,
+//   encryptedKey EncryptedKey }
+//
+// RecipientIdentifier ::= CHOICE {
+//   issuerAndSerialNumber IssuerAndSerialNumber,
+//   subjectKeyIdentifier [0] SubjectKeyIdentifier }
+//
+// EncryptedKey ::= OCTET STRING
+type KeyTransRecipientInfo struct {
+	Version                int
+	RID                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// ParseKeyTransRecipientInfo parses a RecipientInfo, assuming it is a
+// KeyTransRecipientInfo.
+func ParseKeyTransRecipientInfo(ri asn1.RawValue) (ktri KeyTransRecipientInfo, err error) {
+	if ri.Class != asn1.ClassUniversal || ri.Tag != asn1.TagSequence {
+		err = ErrWrongType
+		return
+	}
+
+	var rest []byte
+	if rest, err = asn1.Unmarshal(ri.FullBytes, &ktri); err == nil && len(rest) > 0 {
+		err = ErrTrailingData
+	}
+
+	return
+}
+
+// RawValue encodes the KeyTransRecipientInfo as a RecipientInfo.
+func (ktri KeyTransRecipientInfo) RawValue() (asn1.RawValue, error) {
+	return marshalRawValue(ktri, "")
+}
+
+// MatchesCertificate checks if the KeyTransRecipientInfo identifies cert.
+func (ktri KeyTransRecipientInfo) MatchesCertificate(cert *x509.Certificate) bool {
+	return recipientIdentifierMatches(ktri.RID, cert)
+}
+
+// KeyAgreeRecipientInfo ::= SEQUENCE {
+//   version CMSVersion,  -- always set to 3
+//   originator [0] EXPLICIT OriginatorIdentifierOrKey,
+//   ukm [1] EXPLICIT UserKeyingMaterial OPTIONAL,
+//   keyEncryptionAlgorithm KeyEncryptionAlgorithmIdentifier,
+//   recipientEncryptedKeys RecipientEncryptedKeys }
+//
+// OriginatorIdentifierOrKey ::= CHOICE {
+//   issuerAndSerialNumber IssuerAndSerialNumber,
+//   subjectKeyIdentifier [0] SubjectKeyIdentifier,
+//   originatorKey [1] OriginatorPublicKey }
+//
+// UserKeyingMaterial ::= OCTET STRING
+//
+// RecipientEncryptedKeys ::= SEQUENCE OF RecipientEncryptedKey
+//
+// The KeyAgreeRecipientInfo is tagged [1] IMPLICIT when used as a
+// RecipientInfo. Originator holds the whole [0] EXPLICIT field, since
+// encoding/asn1 ignores the explicit tag when marshaling a RawValue.
+type KeyAgreeRecipientInfo struct {
+	Version                int
+	Originator             asn1.RawValue `asn1:"tag:0"`
+	UKM                    []byte        `asn1:"optional,explicit,tag:1"`
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	RecipientEncryptedKeys []RecipientEncryptedKey
+}
+
+// ParseKeyAgreeRecipientInfo parses a RecipientInfo, assuming it is a
+// KeyAgreeRecipientInfo.
+func ParseKeyAgreeRecipientInfo(ri asn1.RawValue) (kari KeyAgreeRecipientInfo, err error) {
+	if ri.Class != asn1.ClassContextSpecific || ri.Tag != 1 {
+		err = ErrWrongType
+		return
+	}
+
+	var rest []byte
+	if rest, err = asn1.UnmarshalWithParams(ri.FullBytes, &kari, "tag:1"); err == nil && len(rest) > 0 {
+		err = ErrTrailingData
+	}
+
+	return
+}
+
+// RawValue encodes the KeyAgreeRecipientInfo as a RecipientInfo.
+func (kari KeyAgreeRecipientInfo) RawValue() (asn1.RawValue, error) {
+	return marshalRawValue(kari, "tag:1")
+}
+
+// OriginatorKey gets the originator, assuming it is an originatorKey.
+func (kari KeyAgreeRecipientInfo) OriginatorKey() (opk OriginatorPublicKey, err error) {
+	var (
+		originator asn1.RawValue
+		rest       []byte
+	)
+
+	if rest, err = asn1.Unmarshal(kari.Originator.Bytes, &originator); err != nil {
+		return
+	} else if len(rest) > 0 {
+		err = ErrTrailingData
+		return
+	}
+
+	if originator.Class != asn1.ClassContextSpecific || originator.Tag != 1 {
+		err = ErrWrongType
+		return
+	}
+
+	if rest, err = asn1.UnmarshalWithParams(originator.FullBytes, &opk, "tag:1"); err == nil && len(rest) > 0 {
+		err = ErrTrailingData
+	}
+
+	return
+}
+
+// OriginatorPublicKey ::= SEQUENCE {
+//   algorithm AlgorithmIdentifier,
+//   publicKey BIT STRING }
+type OriginatorPublicKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// OriginatorRawValue encodes the OriginatorPublicKey as the originator field
+// of a KeyAgreeRecipientInfo.
+func (opk OriginatorPublicKey) OriginatorRawValue() (asn1.RawValue, error) {
+	der, err := asn1.MarshalWithParams(opk, "tag:1")
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	return asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        0,
+		Bytes:      der,
+		IsCompound: true,
+	}, nil
+}
+
+// RecipientEncryptedKey ::= SEQUENCE {
+//   rid KeyAgreeRecipientIdentifier,
+//   encryptedKey EncryptedKey }
+//
+// KeyAgreeRecipientIdentifier ::= CHOICE {
+//   issuerAndSerialNumber IssuerAndSerialNumber,
+//   rKeyId [0] IMPLICIT RecipientKeyIdentifier }
+//
+// RecipientKeyIdentifier ::= SEQUENCE {
+//   subjectKeyIdentifier SubjectKeyIdentifier,
+//   date GeneralizedTime OPTIONAL,
+//   other OtherKeyAttribute OPTIONAL }
+type RecipientEncryptedKey struct {
+	RID          asn1.RawValue
+	EncryptedKey []byte
+}
+
+// MatchesCertificate checks if the RecipientEncryptedKey identifies cert.
+func (rek RecipientEncryptedKey) MatchesCertificate(cert *x509.Certificate) bool {
+	if rek.RID.Class == asn1.ClassContextSpecific && rek.RID.Tag == 0 {
+		// The rKeyId's SubjectKeyIdentifier is its first field.
+		var ski []byte
+		if _, err := asn1.Unmarshal(rek.RID.Bytes, &ski); err != nil {
+			return false
+		}
+
+		return bytes.Equal(ski, cert.SubjectKeyId)
+	}
+
+	return recipientIdentifierMatches(rek.RID, cert)
+}
+
+// ECCCMSSharedInfo ::= SEQUENCE {
+//   keyInfo AlgorithmIdentifier,
+//   entityUInfo [0] EXPLICIT OCTET STRING OPTIONAL,
+//   suppPubInfo [2] EXPLICIT OCTET STRING }
+//
+// This is the SharedInfo input to the key derivation function for ECDH key
+// agreement, from RFC 5753.
+type ECCCMSSharedInfo struct {
+	KeyInfo     pkix.AlgorithmIdentifier
+	EntityUInfo []byte `asn1:"optional,explicit,tag:0"`
+	SuppPubInfo []byte `asn1:"explicit,tag:2"`
+}
+
+// RSAESOAEPParams ::= SEQUENCE {
+//   hashFunc [0] EXPLICIT AlgorithmIdentifier DEFAULT sha1,
+//   maskGenFunc [1] EXPLICIT AlgorithmIdentifier DEFAULT mgf1SHA1,
+//   pSourceFunc [2] EXPLICIT AlgorithmIdentifier DEFAULT pSpecifiedEmpty }
+//
+// Missing fields are left empty when parsing, so callers must apply the
+// defaults.
+type RSAESOAEPParams struct {
+	HashFunc    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	PSourceFunc pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:2"`
+}
+
+// recipientIdentifierMatches checks if an issuerAndSerialNumber or [0]
+// subjectKeyIdentifier recipient identifier identifies cert.
+func recipientIdentifierMatches(rid asn1.RawValue, cert *x509.Certificate) bool {
+	switch {
+	case rid.Class == asn1.ClassUniversal && rid.Tag == asn1.TagSequence:
+		var isn IssuerAndSerialNumber
+		if rest, err := asn1.Unmarshal(rid.FullBytes, &isn); err != nil || len(rest) > 0 {
+			return false
+		}
+
+		return bytes.Equal(cert.RawIssuer, isn.Issuer.FullBytes) && isn.SerialNumber.Cmp(cert.SerialNumber) == 0
+	case rid.Class == asn1.ClassContextSpecific && rid.Tag == 0:
+		return len(cert.SubjectKeyId) > 0 && bytes.Equal(rid.Bytes, cert.SubjectKeyId)
+	default:
+		return false
+	}
+}
+
+// marshalRawValue DER encodes val with the given asn1 params and decodes it
+// into a RawValue.
+func marshalRawValue(val interface{}, params string) (rv asn1.RawValue, err error) {
+	var der []byte
+	if der, err = asn1.MarshalWithParams(val, params); err != nil {
+		return
+	}
+
+	_, err = asn1.Unmarshal(der, &rv)
+
+	return
+}
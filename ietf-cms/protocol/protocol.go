@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	_ "crypto/sha1" // for crypto.SHA1
@@ -13,6 +14,7 @@ import (
 	"encoding/asn1"
 	"errors"
 	"fmt"
+	"io"
 	"math/big"
 	"sort"
 	"time"
@@ -421,7 +423,7 @@ type SignerInfo struct {
 func (si SignerInfo) FindCertificate(certs []*x509.Certificate) (*x509.Certificate, error) {
 	switch si.Version {
 	case 1: // SID is issuer and serial number
-		isn, err := si.issuerAndSerialNumberSID()
+		isn, err := si.IssuerAndSerialNumberSID()
 		if err != nil {
 			return nil, err
 		}
@@ -432,7 +434,7 @@ func (si SignerInfo) FindCertificate(certs []*x509.Certificate) (*x509.Certifica
 			}
 		}
 	case 3: // SID is SubjectKeyIdentifier
-		ski, err := si.subjectKeyIdentifierSID()
+		ski, err := si.SubjectKeyIdentifierSID()
 		if err != nil {
 			return nil, err
 		}
@@ -453,8 +455,8 @@ func (si SignerInfo) FindCertificate(certs []*x509.Certificate) (*x509.Certifica
 	return nil, ErrNoCertificate
 }
 
-// issuerAndSerialNumberSID gets the SID, assuming it is a issuerAndSerialNumber.
-func (si SignerInfo) issuerAndSerialNumberSID() (isn IssuerAndSerialNumber, err error) {
+// IssuerAndSerialNumberSID gets the SID, assuming it is a issuerAndSerialNumber.
+func (si SignerInfo) IssuerAndSerialNumberSID() (isn IssuerAndSerialNumber, err error) {
 	if si.SID.Class != asn1.ClassUniversal || si.SID.Tag != asn1.TagSequence {
 		err = ErrWrongType
 		return
@@ -468,8 +470,8 @@ func (si SignerInfo) issuerAndSerialNumberSID() (isn IssuerAndSerialNumber, err
 	return
 }
 
-// subjectKeyIdentifierSID gets the SID, assuming it is a subjectKeyIdentifier.
-func (si SignerInfo) subjectKeyIdentifierSID() ([]byte, error) {
+// SubjectKeyIdentifierSID gets the SID, assuming it is a subjectKeyIdentifier.
+func (si SignerInfo) SubjectKeyIdentifierSID() ([]byte, error) {
 	if si.SID.Class != asn1.ClassContextSpecific || si.SID.Tag != 0 {
 		return nil, ErrWrongType
 	}
@@ -490,7 +492,10 @@ func (si SignerInfo) Hash() (crypto.Hash, error) {
 }
 
 // X509SignatureAlgorithm gets the x509.SignatureAlgorithm that should be used
-// for verifying this SignerInfo's signature.
+// for verifying this SignerInfo's signature. The x509 package has no
+// SignatureAlgorithm for signatures using SHA-224, SHA-512/256 or SHA-3
+// digests, so x509.UnknownSignatureAlgorithm is returned for them, and
+// PublicKeyAlgorithmAndHash should be used instead.
 func (si SignerInfo) X509SignatureAlgorithm() x509.SignatureAlgorithm {
 	var (
 		sigOID    = si.SignatureAlgorithm.Algorithm.String()
@@ -501,9 +506,58 @@ func (si SignerInfo) X509SignatureAlgorithm() x509.SignatureAlgorithm {
 		return sa
 	}
 
+	// RSASSA-PSS signatures carry their hash in the algorithm parameters,
+	// which must match the digest algorithm.
+	if si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS) {
+		params, err := ParseRSASSAPSSParams(si.SignatureAlgorithm)
+		if err != nil {
+			return x509.UnknownSignatureAlgorithm
+		}
+		if hash, err := params.Hash(); err != nil || oid.CryptoHashToDigestAlgorithm[hash].String() != digestOID {
+			return x509.UnknownSignatureAlgorithm
+		}
+
+		return oid.DigestAlgorithmToX509RSAPSSSignatureAlgorithm[digestOID]
+	}
+
 	return oid.PublicKeyAndDigestAlgorithmToX509SignatureAlgorithm[sigOID][digestOID]
 }
 
+// PublicKeyAlgorithmAndHash gets the public key algorithm and hash of this
+// SignerInfo's RSA or ECDSA signature. Unlike X509SignatureAlgorithm, this
+// supports any digest algorithm known to DigestAlgorithmToCryptoHash. A
+// signature algorithm that names a digest algorithm must match the
+// SignerInfo's DigestAlgorithm.
+func (si SignerInfo) PublicKeyAlgorithmAndHash() (x509.PublicKeyAlgorithm, crypto.Hash, error) {
+	sigOID := si.SignatureAlgorithm.Algorithm.String()
+
+	pkAlgo, ok := oid.SignatureAlgorithmToX509PublicKeyAlgorithm[sigOID]
+	if !ok {
+		return x509.UnknownPublicKeyAlgorithm, 0, ErrUnsupported
+	}
+
+	hash, err := si.Hash()
+	if err != nil {
+		return x509.UnknownPublicKeyAlgorithm, 0, err
+	}
+
+	if digestOID, ok := oid.SignatureAlgorithmToDigestAlgorithm[sigOID]; ok && !digestOID.Equal(si.DigestAlgorithm.Algorithm) {
+		return x509.UnknownPublicKeyAlgorithm, 0, ErrUnsupported
+	}
+
+	if si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS) {
+		params, err := ParseRSASSAPSSParams(si.SignatureAlgorithm)
+		if err != nil {
+			return x509.UnknownPublicKeyAlgorithm, 0, ErrUnsupported
+		}
+		if pssHash, err := params.Hash(); err != nil || pssHash != hash {
+			return x509.UnknownPublicKeyAlgorithm, 0, ErrUnsupported
+		}
+	}
+
+	return pkAlgo, hash, nil
+}
+
 // GetContentTypeAttribute gets the signed ContentType attribute from the
 // SignerInfo.
 func (si SignerInfo) GetContentTypeAttribute() (asn1.ObjectIdentifier, error) {
@@ -626,6 +680,56 @@ func NewSignedData(eci EncapsulatedContentInfo) (*SignedData, error) {
 
 // AddSignerInfo adds a SignerInfo to the SignedData.
 func (sd *SignedData) AddSignerInfo(chain []*x509.Certificate, signer crypto.Signer) error {
+	// Get the message
+	content, err := sd.EncapContentInfo.EContentValue()
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		return errors.New("already detached")
+	}
+
+	return sd.addSignerInfo(digestReader(bytes.NewReader(content)), chain, signer)
+}
+
+// AddDetachedSignerInfo adds a SignerInfo to a SignedData without
+// encapsulated content. The message digest is computed over the content read
+// from r, so the content never needs to be held in memory.
+func (sd *SignedData) AddDetachedSignerInfo(r io.Reader, chain []*x509.Certificate, signer crypto.Signer) error {
+	if sd.EncapContentInfo.EContent.Bytes != nil {
+		return errors.New("not detached")
+	}
+
+	return sd.addSignerInfo(digestReader(r), chain, signer)
+}
+
+// AddSignerInfoDigest adds a SignerInfo with a message digest that was already
+// computed by the caller, for example while the content was written by
+// WriteBER. The digest must use the algorithm given by
+// DigestAlgorithmForSigner for the signer.
+func (sd *SignedData) AddSignerInfoDigest(digest []byte, chain []*x509.Certificate, signer crypto.Signer) error {
+	return sd.addSignerInfo(func(hash crypto.Hash) ([]byte, error) {
+		if len(digest) != hash.Size() {
+			return nil, errors.New("digest has wrong length for digest algorithm")
+		}
+		return digest, nil
+	}, chain, signer)
+}
+
+// digestReader returns a function that computes the message digest over the
+// content read from r.
+func digestReader(r io.Reader) func(crypto.Hash) ([]byte, error) {
+	return func(hash crypto.Hash) ([]byte, error) {
+		md := hash.New()
+		if _, err := io.Copy(md, r); err != nil {
+			return nil, err
+		}
+		return md.Sum(nil), nil
+	}
+}
+
+// addSignerInfo adds a SignerInfo whose message digest is computed by digest.
+func (sd *SignedData) addSignerInfo(digest func(crypto.Hash) ([]byte, error), chain []*x509.Certificate, signer crypto.Signer) error {
 	// figure out which certificate is associated with signer.
 	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
 	if err != nil {
@@ -659,14 +763,29 @@ func (sd *SignedData) AddSignerInfo(chain []*x509.Certificate, signer crypto.Sig
 		return err
 	}
 
-	digestAlgorithmID := digestAlgorithmForPublicKey(pub)
-
-	signatureAlgorithmOID, ok := oid.X509PublicKeyAndDigestAlgorithmToSignatureAlgorithm[cert.PublicKeyAlgorithm][digestAlgorithmID.Algorithm.String()]
-	if !ok {
-		return errors.New("unsupported certificate public key algorithm")
+	digestAlgorithmID, err := DigestAlgorithmForSigner(signer)
+	if err != nil {
+		return err
 	}
 
-	signatureAlgorithmID := pkix.AlgorithmIdentifier{Algorithm: signatureAlgorithmOID}
+	var signatureAlgorithmID pkix.AlgorithmIdentifier
+	if usesRSAPSS(signer) {
+		if cert.PublicKeyAlgorithm != x509.RSA {
+			return errors.New("RSASSA-PSS requires an RSA certificate")
+		}
+
+		hash := oid.DigestAlgorithmToCryptoHash[digestAlgorithmID.Algorithm.String()]
+		if signatureAlgorithmID, err = NewRSASSAPSSAlgorithmIdentifier(hash); err != nil {
+			return err
+		}
+	} else {
+		signatureAlgorithmOID, ok := oid.X509PublicKeyAndDigestAlgorithmToSignatureAlgorithm[cert.PublicKeyAlgorithm][digestAlgorithmID.Algorithm.String()]
+		if !ok {
+			return errors.New("unsupported certificate public key or digest algorithm")
+		}
+
+		signatureAlgorithmID = pkix.AlgorithmIdentifier{Algorithm: signatureAlgorithmOID}
+	}
 
 	si := SignerInfo{
 		Version:            1,
@@ -678,22 +797,13 @@ func (sd *SignedData) AddSignerInfo(chain []*x509.Certificate, signer crypto.Sig
 		UnsignedAttrs:      nil,
 	}
 
-	// Get the message
-	content, err := sd.EncapContentInfo.EContentValue()
-	if err != nil {
-		return err
-	}
-	if content == nil {
-		return errors.New("already detached")
-	}
-
 	// Digest the message.
 	hash, err := si.Hash()
 	if err != nil {
 		return err
 	}
-	md := hash.New()
-	if _, err = md.Write(content); err != nil {
+	messageDigest, err := digest(hash)
+	if err != nil {
 		return err
 	}
 
@@ -702,7 +812,7 @@ func (sd *SignedData) AddSignerInfo(chain []*x509.Certificate, signer crypto.Sig
 	if err != nil {
 		return err
 	}
-	mdAttr, err := NewAttribute(oid.AttributeMessageDigest, md.Sum(nil))
+	mdAttr, err := NewAttribute(oid.AttributeMessageDigest, messageDigest)
 	if err != nil {
 		return err
 	}
@@ -722,12 +832,20 @@ func (sd *SignedData) AddSignerInfo(chain []*x509.Certificate, signer crypto.Sig
 	if err != nil {
 		return err
 	}
-	smd := hash.New()
-	if _, errr := smd.Write(sm); errr != nil {
-		return errr
-	}
-	if si.Signature, err = signer.Sign(rand.Reader, smd.Sum(nil), hash); err != nil {
-		return err
+	if cert.PublicKeyAlgorithm == x509.Ed25519 {
+		// Ed25519 signs the marshaled attributes themselves rather than their
+		// digest (RFC 8419).
+		if si.Signature, err = signer.Sign(rand.Reader, sm, crypto.Hash(0)); err != nil {
+			return err
+		}
+	} else {
+		smd := hash.New()
+		if _, errr := smd.Write(sm); errr != nil {
+			return errr
+		}
+		if si.Signature, err = signer.Sign(rand.Reader, smd.Sum(nil), hash); err != nil {
+			return err
+		}
 	}
 
 	sd.addDigestAlgorithm(si.DigestAlgorithm)
@@ -750,21 +868,76 @@ func sortAttributes(attrs ...Attribute) ([]Attribute, error) {
 	return attrs, nil
 }
 
-// algorithmsForPublicKey takes an opinionated stance on what algorithms to use
-// for the given public key.
-func digestAlgorithmForPublicKey(pub crypto.PublicKey) pkix.AlgorithmIdentifier {
-	if ecPub, ok := pub.(*ecdsa.PublicKey); ok {
-		switch ecPub.Curve {
+// DigestAlgorithmForPublicKey takes an opinionated stance on what digest
+// algorithm to use for the given public key. Ed25519 keys always use SHA-512,
+// as required by RFC 8419.
+func DigestAlgorithmForPublicKey(pub crypto.PublicKey) pkix.AlgorithmIdentifier {
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
 		case elliptic.P384():
 			return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA384}
 		case elliptic.P521():
 			return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA512}
 		}
+	case ed25519.PublicKey:
+		return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA512}
 	}
 
 	return pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA256}
 }
 
+// DigestSigner wraps a crypto.Signer so that SignerInfos added with it use
+// Hash as their digest algorithm, rather than the one chosen by
+// DigestAlgorithmForPublicKey.
+type DigestSigner struct {
+	crypto.Signer
+	Hash crypto.Hash
+}
+
+// DigestAlgorithmForSigner gets the digest algorithm to use for SignerInfos
+// added with signer. This is the Hash of a DigestSigner, which may itself be
+// wrapped in an RSAPSSSigner, or DigestAlgorithmForPublicKey's choice
+// otherwise.
+func DigestAlgorithmForSigner(signer crypto.Signer) (pkix.AlgorithmIdentifier, error) {
+	for wrapped := true; wrapped; {
+		if ds, ok := signer.(DigestSigner); ok {
+			digestOID, ok := oid.CryptoHashToDigestAlgorithm[ds.Hash]
+			if !ok || !ds.Hash.Available() {
+				return pkix.AlgorithmIdentifier{}, ErrUnsupported
+			}
+			return pkix.AlgorithmIdentifier{Algorithm: digestOID}, nil
+		}
+		signer, wrapped = unwrapSigner(signer)
+	}
+
+	return DigestAlgorithmForPublicKey(signer.Public()), nil
+}
+
+// usesRSAPSS checks if signer is an RSAPSSSigner, or wraps one.
+func usesRSAPSS(signer crypto.Signer) bool {
+	for wrapped := true; wrapped; {
+		if _, ok := signer.(RSAPSSSigner); ok {
+			return true
+		}
+		signer, wrapped = unwrapSigner(signer)
+	}
+
+	return false
+}
+
+// unwrapSigner gets the signer wrapped by an RSAPSSSigner or DigestSigner.
+func unwrapSigner(signer crypto.Signer) (crypto.Signer, bool) {
+	switch s := signer.(type) {
+	case RSAPSSSigner:
+		return s.Signer, true
+	case DigestSigner:
+		return s.Signer, true
+	}
+
+	return signer, false
+}
+
 // ClearCertificates removes all certificates.
 func (sd *SignedData) ClearCertificates() {
 	sd.Certificates = []asn1.RawValue{}
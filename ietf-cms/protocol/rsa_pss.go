@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+)
+
+// RSASSAPSSParams ::= SEQUENCE {
+//   hashAlgorithm [0] HashAlgorithm DEFAULT sha1Identifier,
+//   maskGenAlgorithm [1] MaskGenAlgorithm DEFAULT mgf1SHA1Identifier,
+//   saltLength [2] INTEGER DEFAULT 20,
+//   trailerField [3] INTEGER DEFAULT 1 }
+//
+// These are the parameters of the RSASSA-PSS signature algorithm, from RFC
+// 4055. Missing algorithms are left empty when parsing, so callers should use
+// PSSOptions, which applies the defaults.
+type RSASSAPSSParams struct {
+	HashAlgorithm    pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MaskGenAlgorithm pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	SaltLength       int                      `asn1:"optional,explicit,tag:2,default:20"`
+	TrailerField     int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
+
+// NewRSASSAPSSAlgorithmIdentifier creates the signature AlgorithmIdentifier for
+// RSASSA-PSS with the given hash, using MGF1 with the same hash and a salt as
+// long as the digest, as recommended by RFC 4056.
+func NewRSASSAPSSAlgorithmIdentifier(hash crypto.Hash) (pkix.AlgorithmIdentifier, error) {
+	digestOID, ok := oid.CryptoHashToDigestAlgorithm[hash]
+	if !ok {
+		return pkix.AlgorithmIdentifier{}, ErrUnsupported
+	}
+
+	hashAlg := pkix.AlgorithmIdentifier{Algorithm: digestOID, Parameters: asn1.NullRawValue}
+
+	mgfParams, err := marshalRawValue(hashAlg, "")
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	params, err := marshalRawValue(RSASSAPSSParams{
+		HashAlgorithm:    hashAlg,
+		MaskGenAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid.MaskGenerationFunctionMGF1, Parameters: mgfParams},
+		SaltLength:       hash.Size(),
+		TrailerField:     1,
+	}, "")
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	return pkix.AlgorithmIdentifier{Algorithm: oid.SignatureAlgorithmRSAPSS, Parameters: params}, nil
+}
+
+// ParseRSASSAPSSParams parses the parameters of an RSASSA-PSS signature
+// AlgorithmIdentifier.
+func ParseRSASSAPSSParams(alg pkix.AlgorithmIdentifier) (params RSASSAPSSParams, err error) {
+	if !alg.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS) {
+		err = ErrWrongType
+		return
+	}
+
+	// Absent parameters mean all the defaults.
+	if len(alg.Parameters.FullBytes) == 0 {
+		params.SaltLength = 20
+		params.TrailerField = 1
+		return
+	}
+
+	var rest []byte
+	if rest, err = asn1.Unmarshal(alg.Parameters.FullBytes, &params); err == nil && len(rest) > 0 {
+		err = ErrTrailingData
+	}
+
+	return
+}
+
+// Hash gets the crypto.Hash of the parameters' hash algorithm. SHA-1 is
+// returned if it is missing.
+func (p RSASSAPSSParams) Hash() (crypto.Hash, error) {
+	if len(p.HashAlgorithm.Algorithm) == 0 {
+		return crypto.SHA1, nil
+	}
+
+	hash := oid.DigestAlgorithmToCryptoHash[p.HashAlgorithm.Algorithm.String()]
+	if hash == 0 || !hash.Available() {
+		return 0, ErrUnsupported
+	}
+
+	return hash, nil
+}
+
+// PSSOptions gets the *rsa.PSSOptions for verifying signatures made with these
+// parameters. The standard library always uses MGF1 with the same hash as the
+// signature, so other mask generation functions are unsupported.
+func (p RSASSAPSSParams) PSSOptions() (*rsa.PSSOptions, error) {
+	hash, err := p.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	mgfHash := crypto.SHA1
+	if len(p.MaskGenAlgorithm.Algorithm) > 0 {
+		if !p.MaskGenAlgorithm.Algorithm.Equal(oid.MaskGenerationFunctionMGF1) {
+			return nil, ErrUnsupported
+		}
+
+		var mgfAlg pkix.AlgorithmIdentifier
+		if rest, err := asn1.Unmarshal(p.MaskGenAlgorithm.Parameters.FullBytes, &mgfAlg); err != nil {
+			return nil, err
+		} else if len(rest) > 0 {
+			return nil, ErrTrailingData
+		}
+
+		mgfHash = oid.DigestAlgorithmToCryptoHash[mgfAlg.Algorithm.String()]
+	}
+
+	if mgfHash != hash || p.TrailerField != 1 || p.SaltLength < 0 {
+		return nil, ErrUnsupported
+	}
+
+	return &rsa.PSSOptions{SaltLength: p.SaltLength, Hash: hash}, nil
+}
+
+// RSAPSSSigner wraps an RSA crypto.Signer, such as a certstore identity's, so
+// that SignerInfos added with it use RSASSA-PSS rather than PKCS #1 v1.5.
+// Signatures are made by passing *rsa.PSSOptions, with a salt as long as the
+// digest, to the wrapped signer.
+type RSAPSSSigner struct {
+	crypto.Signer
+}
+
+// Sign implements the crypto.Signer interface.
+func (s RSAPSSSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.Signer.Sign(rand, digest, &rsa.PSSOptions{
+		SaltLength: rsa.PSSSaltLengthEqualsHash,
+		Hash:       opts.HashFunc(),
+	})
+}
@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+)
+
+func TestRSASSAPSSParams(t *testing.T) {
+	alg, err := NewRSASSAPSSAlgorithmIdentifier(crypto.SHA384)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params, err := ParseRSASSAPSSParams(alg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := params.PSSOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Hash != crypto.SHA384 || opts.SaltLength != 48 {
+		t.Fatalf("unexpected options: %+v", opts)
+	}
+
+	// Absent and empty parameters both mean SHA-1 with a 20 byte salt.
+	for _, params := range []asn1.RawValue{{}, {FullBytes: []byte{0x30, 0x00}}} {
+		p, err := ParseRSASSAPSSParams(pkix.AlgorithmIdentifier{Algorithm: oid.SignatureAlgorithmRSAPSS, Parameters: params})
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts, err := p.PSSOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.Hash != crypto.SHA1 || opts.SaltLength != 20 {
+			t.Fatalf("unexpected default options: %+v", opts)
+		}
+	}
+
+	// MGF1 must use the same hash as the signature.
+	params.HashAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA256, Parameters: asn1.NullRawValue}
+	if _, err = params.PSSOptions(); err != ErrUnsupported {
+		t.Fatalf("expected ErrUnsupported, got %v", err)
+	}
+
+	if _, err = ParseRSASSAPSSParams(pkix.AlgorithmIdentifier{Algorithm: oid.SignatureAlgorithmSHA256WithRSA}); err != ErrWrongType {
+		t.Fatalf("expected ErrWrongType, got %v", err)
+	}
+}
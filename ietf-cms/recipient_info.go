@@ -0,0 +1,411 @@
+package cms
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
+)
+
+// ErrNotRecipient is returned when decrypting a message that wasn't encrypted
+// to the given certificate.
+var ErrNotRecipient = errors.New("certificate is not a recipient")
+
+// KeyAgreer is implemented by EC private keys that can compute ECDH shared
+// secrets, like *ecdh.PrivateKey or keys held by the operating system.
+type KeyAgreer interface {
+	// ECDH computes the raw shared secret with the remote public key.
+	ECDH(remote *ecdh.PublicKey) ([]byte, error)
+}
+
+// newRecipientInfos encrypts the content encryption key to each of the
+// recipient certificates. RSA recipients get a KeyTransRecipientInfo and EC
+// recipients get a KeyAgreeRecipientInfo.
+func newRecipientInfos(cek []byte, recipients []*x509.Certificate, oaep bool) ([]asn1.RawValue, error) {
+	ris := make([]asn1.RawValue, 0, len(recipients))
+
+	for _, cert := range recipients {
+		var (
+			ri  asn1.RawValue
+			err error
+		)
+
+		switch pub := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			ri, err = newKeyTransRecipientInfo(cek, cert, pub, oaep)
+		case *ecdsa.PublicKey:
+			ri, err = newKeyAgreeRecipientInfo(cek, cert, pub)
+		default:
+			err = errors.New("unsupported recipient public key algorithm")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ris = append(ris, ri)
+	}
+
+	return ris, nil
+}
+
+// newKeyTransRecipientInfo encrypts the content encryption key to an RSA
+// recipient.
+func newKeyTransRecipientInfo(cek []byte, cert *x509.Certificate, pub *rsa.PublicKey, oaep bool) (asn1.RawValue, error) {
+	rid, err := protocol.NewIssuerAndSerialNumber(cert)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	var (
+		alg pkix.AlgorithmIdentifier
+		ek  []byte
+	)
+
+	if oaep {
+		hashAlg := pkix.AlgorithmIdentifier{Algorithm: oid.DigestAlgorithmSHA256, Parameters: asn1.NullRawValue}
+
+		mgfParams, err := marshalRawValue(hashAlg)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+
+		params, err := marshalRawValue(protocol.RSAESOAEPParams{
+			HashFunc:    hashAlg,
+			MaskGenFunc: pkix.AlgorithmIdentifier{Algorithm: oid.MaskGenerationFunctionMGF1, Parameters: mgfParams},
+		})
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+
+		alg = pkix.AlgorithmIdentifier{Algorithm: oid.KeyEncryptionAlgorithmRSAOAEP, Parameters: params}
+		if ek, err = rsa.EncryptOAEP(crypto.SHA256.New(), rand.Reader, pub, cek, nil); err != nil {
+			return asn1.RawValue{}, err
+		}
+	} else {
+		alg = pkix.AlgorithmIdentifier{Algorithm: oid.KeyEncryptionAlgorithmRSA, Parameters: asn1.NullRawValue}
+		if ek, err = rsa.EncryptPKCS1v15(rand.Reader, pub, cek); err != nil {
+			return asn1.RawValue{}, err
+		}
+	}
+
+	return protocol.KeyTransRecipientInfo{
+		Version:                0,
+		RID:                    rid,
+		KeyEncryptionAlgorithm: alg,
+		EncryptedKey:           ek,
+	}.RawValue()
+}
+
+// newKeyAgreeRecipientInfo encrypts the content encryption key to an EC
+// recipient, using ephemeral-static ECDH as described in RFC 5753.
+func newKeyAgreeRecipientInfo(cek []byte, cert *x509.Certificate, pub *ecdsa.PublicKey) (asn1.RawValue, error) {
+	// The KDF hash follows the curve, and the key wrap algorithm follows the
+	// content encryption key, as suggested by RFC 5008.
+	var kdfOID asn1.ObjectIdentifier
+	switch pub.Curve {
+	case elliptic.P256():
+		kdfOID = oid.KeyAgreementAlgorithmECDHSHA256KDF
+	case elliptic.P384():
+		kdfOID = oid.KeyAgreementAlgorithmECDHSHA384KDF
+	case elliptic.P521():
+		kdfOID = oid.KeyAgreementAlgorithmECDHSHA512KDF
+	default:
+		return asn1.RawValue{}, errors.New("unsupported recipient curve")
+	}
+
+	var wrapOID asn1.ObjectIdentifier
+	switch len(cek) {
+	case 16:
+		wrapOID = oid.KeyWrapAlgorithmAES128
+	case 24:
+		wrapOID = oid.KeyWrapAlgorithmAES192
+	default:
+		wrapOID = oid.KeyWrapAlgorithmAES256
+	}
+
+	rid, err := protocol.NewIssuerAndSerialNumber(cert)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	remote, err := pub.ECDH()
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	ephemeral, err := remote.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	secret, err := ephemeral.ECDH(remote)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	keyInfo := pkix.AlgorithmIdentifier{Algorithm: wrapOID}
+
+	kek, err := deriveKEK(kdfOID, keyInfo, nil, secret)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	ek, err := aesKeyWrap(kek, cek)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	ephemeralBytes := ephemeral.PublicKey().Bytes()
+	originator, err := protocol.OriginatorPublicKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid.PublicKeyAlgorithmECDSA},
+		PublicKey: asn1.BitString{Bytes: ephemeralBytes, BitLength: 8 * len(ephemeralBytes)},
+	}.OriginatorRawValue()
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	params, err := marshalRawValue(keyInfo)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+
+	return protocol.KeyAgreeRecipientInfo{
+		Version:                3,
+		Originator:             originator,
+		KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: kdfOID, Parameters: params},
+		RecipientEncryptedKeys: []protocol.RecipientEncryptedKey{{RID: rid, EncryptedKey: ek}},
+	}.RawValue()
+}
+
+// hasRecipient checks if any of the RecipientInfos is for cert.
+func hasRecipient(ris []asn1.RawValue, cert *x509.Certificate) bool {
+	for _, ri := range ris {
+		if ktri, err := protocol.ParseKeyTransRecipientInfo(ri); err == nil && ktri.MatchesCertificate(cert) {
+			return true
+		}
+
+		if kari, err := protocol.ParseKeyAgreeRecipientInfo(ri); err == nil {
+			for _, rek := range kari.RecipientEncryptedKeys {
+				if rek.MatchesCertificate(cert) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// decryptKey finds the RecipientInfo for cert and decrypts the content
+// encryption key with the recipient's private key. keySize is the size of the
+// content encryption key in bytes.
+func decryptKey(ris []asn1.RawValue, cert *x509.Certificate, key crypto.PrivateKey, keySize int) ([]byte, error) {
+	for _, ri := range ris {
+		if ktri, err := protocol.ParseKeyTransRecipientInfo(ri); err == nil && ktri.MatchesCertificate(cert) {
+			return decryptKeyTrans(ktri, key, keySize)
+		}
+
+		if kari, err := protocol.ParseKeyAgreeRecipientInfo(ri); err == nil {
+			for _, rek := range kari.RecipientEncryptedKeys {
+				if rek.MatchesCertificate(cert) {
+					return decryptKeyAgree(kari, rek, cert, key, keySize)
+				}
+			}
+		}
+	}
+
+	return nil, ErrNotRecipient
+}
+
+// decryptKeyTrans decrypts the content encryption key from a
+// KeyTransRecipientInfo.
+func decryptKeyTrans(ktri protocol.KeyTransRecipientInfo, key crypto.PrivateKey, keySize int) ([]byte, error) {
+	decrypter, ok := key.(crypto.Decrypter)
+	if !ok {
+		return nil, errors.New("private key can't decrypt")
+	}
+
+	var opts crypto.DecrypterOpts
+
+	switch alg := ktri.KeyEncryptionAlgorithm; {
+	case alg.Algorithm.Equal(oid.KeyEncryptionAlgorithmRSA):
+		// With SessionKeyLen set, a random key is returned rather than an error
+		// if the padding is wrong, which avoids Bleichenbacher's attack.
+		opts = &rsa.PKCS1v15DecryptOptions{SessionKeyLen: keySize}
+	case alg.Algorithm.Equal(oid.KeyEncryptionAlgorithmRSAOAEP):
+		oaepOpts, err := parseOAEPParams(alg.Parameters)
+		if err != nil {
+			return nil, err
+		}
+		opts = oaepOpts
+	default:
+		return nil, errors.New("unsupported key encryption algorithm")
+	}
+
+	cek, err := decrypter.Decrypt(rand.Reader, ktri.EncryptedKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(cek) != keySize {
+		return nil, errors.New("bad content encryption key length")
+	}
+
+	return cek, nil
+}
+
+// parseOAEPParams parses RSAES-OAEP-params into options for decryption,
+// applying the defaults for missing fields.
+func parseOAEPParams(params asn1.RawValue) (*rsa.OAEPOptions, error) {
+	opts := &rsa.OAEPOptions{Hash: crypto.SHA1, MGFHash: crypto.SHA1}
+
+	if len(params.FullBytes) == 0 || (params.Class == asn1.ClassUniversal && params.Tag == asn1.TagNull) {
+		return opts, nil
+	}
+
+	var p protocol.RSAESOAEPParams
+	if rest, err := asn1.Unmarshal(params.FullBytes, &p); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, protocol.ErrTrailingData
+	}
+
+	if len(p.HashFunc.Algorithm) > 0 {
+		hash, ok := oid.DigestAlgorithmToCryptoHash[p.HashFunc.Algorithm.String()]
+		if !ok || !hash.Available() {
+			return nil, errors.New("unsupported OAEP hash")
+		}
+		opts.Hash = hash
+	}
+
+	if len(p.MaskGenFunc.Algorithm) > 0 {
+		if !p.MaskGenFunc.Algorithm.Equal(oid.MaskGenerationFunctionMGF1) {
+			return nil, errors.New("unsupported OAEP mask generation function")
+		}
+
+		var mgfHash pkix.AlgorithmIdentifier
+		if _, err := asn1.Unmarshal(p.MaskGenFunc.Parameters.FullBytes, &mgfHash); err != nil {
+			return nil, err
+		}
+
+		hash, ok := oid.DigestAlgorithmToCryptoHash[mgfHash.Algorithm.String()]
+		if !ok || !hash.Available() {
+			return nil, errors.New("unsupported OAEP mask generation hash")
+		}
+		opts.MGFHash = hash
+	}
+
+	if len(p.PSourceFunc.Algorithm) > 0 {
+		if !p.PSourceFunc.Algorithm.Equal(oid.PSourceAlgorithmPSpecified) {
+			return nil, errors.New("unsupported OAEP label source")
+		}
+
+		if _, err := asn1.Unmarshal(p.PSourceFunc.Parameters.FullBytes, &opts.Label); err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+// decryptKeyAgree decrypts the content encryption key from a
+// KeyAgreeRecipientInfo.
+func decryptKeyAgree(kari protocol.KeyAgreeRecipientInfo, rek protocol.RecipientEncryptedKey, cert *x509.Certificate, key crypto.PrivateKey, keySize int) ([]byte, error) {
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("key agreement recipient must have an EC key")
+	}
+
+	local, err := pub.ECDH()
+	if err != nil {
+		return nil, err
+	}
+
+	originator, err := kari.OriginatorKey()
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := local.Curve().NewPublicKey(originator.PublicKey.RightAlign())
+	if err != nil {
+		return nil, err
+	}
+
+	var secret []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		priv, err := k.ECDH()
+		if err != nil {
+			return nil, err
+		}
+		if secret, err = priv.ECDH(remote); err != nil {
+			return nil, err
+		}
+	case KeyAgreer:
+		if secret, err = k.ECDH(remote); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("private key can't do key agreement")
+	}
+
+	var keyInfo pkix.AlgorithmIdentifier
+	if rest, err := asn1.Unmarshal(kari.KeyEncryptionAlgorithm.Parameters.FullBytes, &keyInfo); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, protocol.ErrTrailingData
+	}
+
+	kek, err := deriveKEK(kari.KeyEncryptionAlgorithm.Algorithm, keyInfo, kari.UKM, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	cek, err := aesKeyUnwrap(kek, rek.EncryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(cek) != keySize {
+		return nil, errors.New("bad content encryption key length")
+	}
+
+	return cek, nil
+}
+
+// deriveKEK derives the key encryption key for the key wrap algorithm in
+// keyInfo from an ECDH shared secret, using the ECC-CMS-SharedInfo from
+// RFC 5753.
+func deriveKEK(kdfOID asn1.ObjectIdentifier, keyInfo pkix.AlgorithmIdentifier, ukm, secret []byte) ([]byte, error) {
+	hash, ok := oid.KeyAgreementAlgorithmToKDFHash[kdfOID.String()]
+	if !ok || !hash.Available() {
+		return nil, errors.New("unsupported key agreement algorithm")
+	}
+
+	kekSize, ok := oid.KeyWrapAlgorithmToKeySize[keyInfo.Algorithm.String()]
+	if !ok {
+		return nil, errors.New("unsupported key wrap algorithm")
+	}
+
+	suppPubInfo := make([]byte, 4)
+	binary.BigEndian.PutUint32(suppPubInfo, uint32(8*kekSize))
+
+	sharedInfo, err := asn1.Marshal(protocol.ECCCMSSharedInfo{
+		KeyInfo:     keyInfo,
+		EntityUInfo: ukm,
+		SuppPubInfo: suppPubInfo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return x963KDF(hash, secret, sharedInfo, kekSize), nil
+}
@@ -3,6 +3,11 @@ package cms
 import (
 	"crypto"
 	"crypto/x509"
+	"errors"
+	"io"
+
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
 )
 
 // Sign creates a CMS SignedData from the content and signs it with signer. At
@@ -41,9 +46,95 @@ func SignDetached(data []byte, chain []*x509.Certificate, signer crypto.Signer)
 	return sd.ToDER()
 }
 
+// SignDetachedReader is like SignDetached, but reads the content from r rather
+// than holding it in memory, so it can be used for signing large files.
+func SignDetachedReader(r io.Reader, chain []*x509.Certificate, signer crypto.Signer) ([]byte, error) {
+	sd, err := NewDetachedSignedData()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = sd.SignReader(r, chain, signer); err != nil {
+		return nil, err
+	}
+
+	return sd.ToDER()
+}
+
+// SignStream creates an attached CMS SignedData from the content read from r,
+// signs it with signer and writes it to w. The content is hashed and written as
+// it is read, so it is never held in memory. The message is BER encoded with
+// indefinite lengths rather than DER encoded. At minimum, chain must contain the
+// leaf certificate associated with the signer. Any additional intermediates
+// will also be added to the SignedData.
+func SignStream(w io.Writer, r io.Reader, chain []*x509.Certificate, signer crypto.Signer) error {
+	sd, err := NewDetachedSignedData()
+	if err != nil {
+		return err
+	}
+
+	return sd.SignStream(w, r, chain, signer, nil)
+}
+
+// RSAPSSSigner wraps an RSA signer so that signatures made with it use
+// RSASSA-PSS (RFC 4056) rather than PKCS #1 v1.5. The wrapped signer is passed
+// *rsa.PSSOptions with a salt as long as the digest.
+func RSAPSSSigner(signer crypto.Signer) crypto.Signer {
+	return protocol.RSAPSSSigner{Signer: signer}
+}
+
+// DigestSigner wraps a signer so that signatures made with it use hash as
+// their digest algorithm, rather than the default for the signer's public key.
+// It can be combined with RSAPSSSigner.
+func DigestSigner(signer crypto.Signer, hash crypto.Hash) crypto.Signer {
+	return protocol.DigestSigner{Signer: signer, Hash: hash}
+}
+
 // Sign adds a signature to the SignedData.At minimum, chain must contain the
 // leaf certificate associated with the signer. Any additional intermediates
 // will also be added to the SignedData.
 func (sd *SignedData) Sign(chain []*x509.Certificate, signer crypto.Signer) error {
 	return sd.psd.AddSignerInfo(chain, signer)
 }
+
+// SignReader adds a signature over the content read from r to a SignedData
+// created by NewDetachedSignedData. The content is hashed as it is read. Each
+// signature consumes r, so the content must be read again for each additional
+// signer. At minimum, chain must contain the leaf certificate associated with
+// the signer. Any additional intermediates will also be added to the
+// SignedData.
+func (sd *SignedData) SignReader(r io.Reader, chain []*x509.Certificate, signer crypto.Signer) error {
+	return sd.psd.AddDetachedSignerInfo(r, chain, signer)
+}
+
+// SignStream signs the content read from r with signer, writing the SignedData
+// with the content encapsulated to w, like the SignStream function. sd must be
+// created by NewDetachedSignedData and not yet signed. finish, if not nil, is
+// called once the signature has been made, before it is written, and may
+// change the SignedData's certificates. Afterwards sd holds the signature, but
+// not the content.
+func (sd *SignedData) SignStream(w io.Writer, r io.Reader, chain []*x509.Certificate, signer crypto.Signer, finish func() error) error {
+	// The digest algorithm is written before the content, so it must be known
+	// up front.
+	digestAlgorithm, err := protocol.DigestAlgorithmForSigner(signer)
+	if err != nil {
+		return err
+	}
+	hash, ok := oid.DigestAlgorithmToCryptoHash[digestAlgorithm.Algorithm.String()]
+	if !ok || !hash.Available() {
+		return errors.New("unsupported digest algorithm")
+	}
+	sd.psd.DigestAlgorithms = append(sd.psd.DigestAlgorithms, digestAlgorithm)
+
+	md := hash.New()
+
+	return sd.psd.WriteBER(w, io.TeeReader(r, md), func() error {
+		if err := sd.psd.AddSignerInfoDigest(md.Sum(nil), chain, signer); err != nil {
+			return err
+		}
+		if finish != nil {
+			return finish()
+		}
+		return nil
+	})
+}
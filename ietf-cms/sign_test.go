@@ -1,13 +1,25 @@
 package cms
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"testing"
 	"time"
+
+	"github.com/github/smimesign/fakeca"
+	"github.com/github/smimesign/ietf-cms/oid"
+	"github.com/github/smimesign/ietf-cms/protocol"
 )
 
 var (
@@ -103,6 +115,71 @@ func TestSignDetached(t *testing.T) {
 	}
 }
 
+func TestSignDetachedReader(t *testing.T) {
+	data := []byte("hello, world!")
+
+	ci, err := SignDetachedReader(bytes.NewReader(data), leaf.Chain(), leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ParseSignedData(ci)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sd.IsDetached() {
+		t.Fatal("expected detached signature")
+	}
+
+	if _, err = sd.VerifyDetached(data, rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = sd.VerifyDetached([]byte("goodbye, world!"), rootOpts); err == nil {
+		t.Fatal("expected error verifying with wrong data")
+	}
+
+	// Attached SignedData can't be signed from a reader.
+	attached, err := NewSignedData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = attached.SignReader(bytes.NewReader(data), leaf.Chain(), leaf.PrivateKey); err == nil {
+		t.Fatal("expected error signing attached SignedData from reader")
+	}
+}
+
+func TestSignStream(t *testing.T) {
+	data := bytes.Repeat([]byte("hello, world!\n"), 1000)
+
+	var ber bytes.Buffer
+	if err := SignStream(&ber, bytes.NewReader(data), leaf.Chain(), leaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ParseSignedData(ber.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sd.IsDetached() {
+		t.Fatal("expected attached signature")
+	}
+
+	content, err := sd.GetData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, data) {
+		t.Fatal("expected signed content to match")
+	}
+
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestSignDetachedWithOpenSSL(t *testing.T) {
 	// Do not require this test to pass if openssl is not in the path
 	opensslPath, err := exec.LookPath("openssl")
@@ -219,3 +296,422 @@ func TestSignRemoveHeaders(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSignRSAPSS(t *testing.T) {
+	data := []byte("hello, world!")
+
+	der, err := SignDetached(data, leaf.Chain(), RSAPSSSigner(leaf.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si := sd.psd.SignerInfos[0]
+	if !si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS) {
+		t.Fatalf("expected RSASSA-PSS, got %s", si.SignatureAlgorithm.Algorithm)
+	}
+	if sigAlg := si.X509SignatureAlgorithm(); sigAlg != x509.SHA256WithRSAPSS {
+		t.Fatalf("expected SHA256WithRSAPSS, got %s", sigAlg)
+	}
+
+	params, err := protocol.ParseRSASSAPSSParams(si.SignatureAlgorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.SaltLength != 32 {
+		t.Fatalf("expected 32 byte salt, got %d", params.SaltLength)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         root.ChainPool(),
+		Intermediates: leaf.ChainPool(),
+	}
+	if _, err = sd.VerifyDetached(data, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// PSS signatures don't verify as PKCS #1 v1.5 signatures.
+	sd.psd.SignerInfos[0].SignatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oid.SignatureAlgorithmSHA256WithRSA}
+	if _, err = sd.VerifyDetached(data, opts); err == nil {
+		t.Fatal("expected verification error")
+	}
+
+	// EC keys can't make PSS signatures.
+	if _, err = SignDetached(data, p256Leaf.Chain(), RSAPSSSigner(p256Leaf.PrivateKey)); err == nil {
+		t.Fatal("expected error signing with EC key")
+	}
+}
+
+func TestSignRSAPSSWithOpenSSL(t *testing.T) {
+	// Do not require this test to pass if openssl is not in the path
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("could not find openssl in path")
+	}
+
+	data := []byte("hello, world!")
+
+	dataFile, err := ioutil.TempFile("", "TestSignRSAPSSWithOpenSSL_data_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dataFile.Name())
+	dataFile.Write(data)
+	dataFile.Close()
+
+	certFile := writeTempPEM(t, "CERTIFICATE", leaf.Certificate.Raw)
+	defer os.Remove(certFile)
+
+	caFile := writeTempPEM(t, "CERTIFICATE", root.Certificate.Raw)
+	defer os.Remove(caFile)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := writeTempPEM(t, "PRIVATE KEY", keyDER)
+	defer os.Remove(keyFile)
+
+	// openssl verifies what we sign.
+	der, err := SignDetached(data, leaf.Chain(), RSAPSSSigner(leaf.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sigFile := writeTempPEM(t, "CMS", der)
+	defer os.Remove(sigFile)
+
+	intermediates := writeTempPEM(t, "CERTIFICATE", intermediate.Certificate.Raw)
+	defer os.Remove(intermediates)
+
+	out, err := exec.Command(opensslPath, "cms", "-verify", "-binary", "-inform", "PEM",
+		"-in", sigFile, "-content", dataFile.Name(), "-CAfile", caFile,
+		"-certfile", intermediates, "-purpose", "any").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	// And we verify what openssl signs.
+	der, err = exec.Command(opensslPath, "cms", "-sign", "-binary", "-outform", "DER",
+		"-in", dataFile.Name(), "-signer", certFile, "-inkey", keyFile,
+		"-keyopt", "rsa_padding_mode:pss", "-nodetach").Output()
+	if err != nil {
+		t.Skip("openssl doesn't support RSASSA-PSS")
+	}
+
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sd.psd.SignerInfos[0].SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS) {
+		t.Fatal("expected openssl to sign with RSASSA-PSS")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         root.ChainPool(),
+		Intermediates: leaf.ChainPool(),
+	}
+	if _, err = sd.Verify(opts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignECDSADigestAlgorithm(t *testing.T) {
+	p521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p521Leaf := intermediate.Issue(fakeca.PrivateKey(p521Key))
+
+	// The digest algorithm matches the strength of the signer's curve.
+	tests := []struct {
+		name   string
+		ident  *fakeca.Identity
+		digest asn1.ObjectIdentifier
+		sigAlg asn1.ObjectIdentifier
+	}{
+		{"p256", p256Leaf, oid.DigestAlgorithmSHA256, oid.SignatureAlgorithmECDSAWithSHA256},
+		{"p384", p384Leaf, oid.DigestAlgorithmSHA384, oid.SignatureAlgorithmECDSAWithSHA384},
+		{"p521", p521Leaf, oid.DigestAlgorithmSHA512, oid.SignatureAlgorithmECDSAWithSHA512},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			der, err := Sign([]byte("hello, world!"), test.ident.Chain(), test.ident.PrivateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sd, err := ParseSignedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			si := sd.psd.SignerInfos[0]
+			if !si.DigestAlgorithm.Algorithm.Equal(test.digest) {
+				t.Fatalf("expected digest algorithm %s, got %s", test.digest, si.DigestAlgorithm.Algorithm)
+			}
+			if !si.SignatureAlgorithm.Algorithm.Equal(test.sigAlg) {
+				t.Fatalf("expected signature algorithm %s, got %s", test.sigAlg, si.SignatureAlgorithm.Algorithm)
+			}
+
+			if _, err = sd.Verify(x509.VerifyOptions{Roots: root.ChainPool(), Intermediates: test.ident.ChainPool()}); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSignEd25519(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edLeaf := intermediate.Issue(fakeca.PrivateKey(priv))
+
+	data := []byte("hello, world!")
+
+	der, err := Sign(data, edLeaf.Chain(), edLeaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	si := sd.psd.SignerInfos[0]
+	if !si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmEd25519) {
+		t.Fatalf("expected Ed25519, got %s", si.SignatureAlgorithm.Algorithm)
+	}
+	if !si.DigestAlgorithm.Algorithm.Equal(oid.DigestAlgorithmSHA512) {
+		t.Fatalf("expected SHA-512, got %s", si.DigestAlgorithm.Algorithm)
+	}
+	if sigAlg := si.X509SignatureAlgorithm(); sigAlg != x509.PureEd25519 {
+		t.Fatalf("expected PureEd25519, got %s", sigAlg)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         root.ChainPool(),
+		Intermediates: edLeaf.ChainPool(),
+	}
+	if _, err = sd.Verify(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The signature is over the signed attributes, not their digest.
+	sm, err := si.SignedAttrs.MarshaledForVerification()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(priv.Public().(ed25519.PublicKey), sm, si.Signature) {
+		t.Fatal("expected signature over signed attributes")
+	}
+
+	// Ed25519 must be used with SHA-512.
+	si.DigestAlgorithm.Algorithm = oid.DigestAlgorithmSHA256
+	if sigAlg := si.X509SignatureAlgorithm(); sigAlg != x509.UnknownSignatureAlgorithm {
+		t.Fatalf("expected UnknownSignatureAlgorithm, got %s", sigAlg)
+	}
+
+	// Detached signatures work too.
+	if der, err = SignDetached(data, edLeaf.Chain(), edLeaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyDetached(data, opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyDetached([]byte("hello, world?"), opts); err == nil {
+		t.Fatal("expected verification error")
+	}
+}
+
+func TestSignDigestAlgorithms(t *testing.T) {
+	data := []byte("hello, world!")
+
+	tests := []struct {
+		name   string
+		ident  *fakeca.Identity
+		hash   crypto.Hash
+		pss    bool
+		sigOID asn1.ObjectIdentifier
+	}{
+		{"rsa-sha224", leaf, crypto.SHA224, false, oid.SignatureAlgorithmSHA224WithRSA},
+		{"rsa-sha512-256", leaf, crypto.SHA512_256, false, oid.SignatureAlgorithmSHA512_256WithRSA},
+		{"rsa-sha3-256", leaf, crypto.SHA3_256, false, oid.SignatureAlgorithmSHA3_256WithRSA},
+		{"rsa-sha3-384", leaf, crypto.SHA3_384, false, oid.SignatureAlgorithmSHA3_384WithRSA},
+		{"rsa-sha3-512", leaf, crypto.SHA3_512, false, oid.SignatureAlgorithmSHA3_512WithRSA},
+		{"rsa-pss-sha3-256", leaf, crypto.SHA3_256, true, oid.SignatureAlgorithmRSAPSS},
+		{"rsa-sha1", leaf, crypto.SHA1, false, oid.SignatureAlgorithmSHA1WithRSA},
+		{"p256-sha224", p256Leaf, crypto.SHA224, false, oid.SignatureAlgorithmECDSAWithSHA224},
+		{"p256-sha3-256", p256Leaf, crypto.SHA3_256, false, oid.SignatureAlgorithmECDSAWithSHA3_256},
+		{"p384-sha3-384", p384Leaf, crypto.SHA3_384, false, oid.SignatureAlgorithmECDSAWithSHA3_384},
+		{"p384-sha3-512", p384Leaf, crypto.SHA3_512, false, oid.SignatureAlgorithmECDSAWithSHA3_512},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signer := DigestSigner(test.ident.PrivateKey, test.hash)
+			if test.pss {
+				signer = RSAPSSSigner(signer)
+			}
+
+			sd, err := NewSignedData(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = sd.Sign(test.ident.Chain(), signer); err != nil {
+				t.Fatal(err)
+			}
+			if err = sd.AddTimestamps("https://google.com"); err != nil {
+				t.Fatal(err)
+			}
+
+			si := sd.psd.SignerInfos[0]
+			if !si.SignatureAlgorithm.Algorithm.Equal(test.sigOID) {
+				t.Fatalf("expected signature algorithm %s, got %s", test.sigOID, si.SignatureAlgorithm.Algorithm)
+			}
+			if hash, err := si.Hash(); err != nil {
+				t.Fatal(err)
+			} else if hash != test.hash {
+				t.Fatalf("expected %s, got %s", test.hash, hash)
+			}
+
+			tsti, err := getTimestamp(si, intermediateOpts, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash, err := tsti.MessageImprint.Hash(); err != nil {
+				t.Fatal(err)
+			} else if hash != test.hash {
+				t.Fatalf("expected timestamp %s, got %s", test.hash, hash)
+			}
+
+			der, err := sd.ToDER()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sd, err = ParseSignedData(der); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := x509.VerifyOptions{
+				Roots:         root.ChainPool(),
+				Intermediates: test.ident.ChainPool(),
+			}
+
+			// SHA-1 is only accepted without the default policy.
+			sd.Policy = &DefaultAlgorithmPolicy
+			if _, err = sd.Verify(opts); test.hash == crypto.SHA1 {
+				if _, isPolicyErr := err.(AlgorithmPolicyError); !isPolicyErr {
+					t.Fatalf("expected AlgorithmPolicyError, got %v", err)
+				}
+				sd.Policy = nil
+				_, err = sd.Verify(opts)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Changing the signed attributes breaks the signature.
+			sd.psd.SignerInfos[0].SignedAttrs[0].RawValue.Bytes[len(sd.psd.SignerInfos[0].SignedAttrs[0].RawValue.Bytes)-1] ^= 1
+			if _, err = sd.Verify(opts); err == nil {
+				t.Fatal("expected verification error")
+			}
+		})
+	}
+
+	// There is no ECDSA signature algorithm for SHA-512/256.
+	if _, err := Sign(data, p256Leaf.Chain(), DigestSigner(p256Leaf.PrivateKey, crypto.SHA512_256)); err == nil {
+		t.Fatal("expected error signing with ECDSA and SHA-512/256")
+	}
+
+	// Ed25519 must use SHA-512.
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	edLeaf := intermediate.Issue(fakeca.PrivateKey(priv))
+	if _, err := Sign(data, edLeaf.Chain(), DigestSigner(priv, crypto.SHA3_512)); err == nil {
+		t.Fatal("expected error signing with Ed25519 and SHA3-512")
+	}
+
+	// Streamed signatures use the digest algorithm too.
+	buf := new(bytes.Buffer)
+	if err := SignStream(buf, bytes.NewReader(data), leaf.Chain(), DigestSigner(leaf.PrivateKey, crypto.SHA3_256)); err != nil {
+		t.Fatal(err)
+	}
+	sd, err := ParseSignedData(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sd.psd.DigestAlgorithms[0].Algorithm.Equal(oid.DigestAlgorithmSHA3_256) {
+		t.Fatalf("expected SHA3-256, got %s", sd.psd.DigestAlgorithms[0].Algorithm)
+	}
+	if _, err = sd.Verify(x509.VerifyOptions{Roots: root.ChainPool(), Intermediates: leaf.ChainPool()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyDigestAlgorithmsWithOpenSSL(t *testing.T) {
+	// Do not require this test to pass if openssl is not in the path
+	opensslPath, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("could not find openssl in path")
+	}
+
+	data := []byte("hello, world!")
+
+	dataFile, err := ioutil.TempFile("", "TestVerifyDigestAlgorithmsWithOpenSSL_data_*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dataFile.Name())
+	dataFile.Write(data)
+	dataFile.Close()
+
+	for _, ident := range []*fakeca.Identity{leaf, p256Leaf} {
+		certFile := writeTempPEM(t, "CERTIFICATE", ident.Certificate.Raw)
+		defer os.Remove(certFile)
+
+		keyDER, err := x509.MarshalPKCS8PrivateKey(ident.PrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyFile := writeTempPEM(t, "PRIVATE KEY", keyDER)
+		defer os.Remove(keyFile)
+
+		for _, md := range []string{"sha224", "sha512-256", "sha3-256", "sha3-384", "sha3-512"} {
+			if md == "sha512-256" && ident == p256Leaf {
+				continue
+			}
+
+			der, err := exec.Command(opensslPath, "cms", "-sign", "-binary", "-outform", "DER", "-md", md,
+				"-in", dataFile.Name(), "-signer", certFile, "-inkey", keyFile, "-nodetach").Output()
+			if err != nil {
+				// Older versions of openssl can't sign with every digest
+				// algorithm, or with ECDSA and SHA-3.
+				t.Logf("openssl can't sign with %s and %s", ident.Certificate.PublicKeyAlgorithm, md)
+				continue
+			}
+
+			sd, err := ParseSignedData(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := x509.VerifyOptions{
+				Roots:         root.ChainPool(),
+				Intermediates: ident.ChainPool(),
+			}
+			if _, err = sd.Verify(opts); err != nil {
+				t.Fatalf("%s: %v", md, err)
+			}
+		}
+	}
+}
@@ -4,12 +4,17 @@ import (
 	"crypto/x509"
 	"encoding/asn1"
 
+	"github.com/github/smimesign/ietf-cms/oid"
 	"github.com/github/smimesign/ietf-cms/protocol"
 )
 
 // SignedData represents a signed message or detached signature.
 type SignedData struct {
 	psd *protocol.SignedData
+
+	// Policy restricts the algorithms accepted when verifying signatures and
+	// timestamps. Nil accepts any supported algorithm.
+	Policy *AlgorithmPolicy
 }
 
 // NewSignedData creates a new SignedData from the given data.
@@ -24,7 +29,20 @@ func NewSignedData(data []byte) (*SignedData, error) {
 		return nil, err
 	}
 
-	return &SignedData{psd}, nil
+	return &SignedData{psd: psd}, nil
+}
+
+// NewDetachedSignedData creates a new SignedData for a detached signature over
+// data content that is read while signing. Use SignReader to sign it.
+func NewDetachedSignedData() (*SignedData, error) {
+	eci := protocol.EncapsulatedContentInfo{EContentType: oid.ContentTypeData}
+
+	psd, err := protocol.NewSignedData(eci)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignedData{psd: psd}, nil
 }
 
 // ParseSignedData parses a SignedData from BER encoded data.
@@ -39,7 +57,7 @@ func ParseSignedData(ber []byte) (*SignedData, error) {
 		return nil, err
 	}
 
-	return &SignedData{psd}, nil
+	return &SignedData{psd: psd}, nil
 }
 
 // GetData gets the encapsulated data from the SignedData. Nil will be returned
@@ -54,6 +72,12 @@ func (sd *SignedData) GetCertificates() ([]*x509.Certificate, error) {
 	return sd.psd.X509Certificates()
 }
 
+// GetSignerInfos gets the SignedData's SignerInfos, in the same order as the
+// chains returned by Verify. The SignerInfos are not verified.
+func (sd *SignedData) GetSignerInfos() []protocol.SignerInfo {
+	return sd.psd.SignerInfos
+}
+
 // SetCertificates replaces the certificates stored in the SignedData with new
 // ones.
 func (sd *SignedData) SetCertificates(certs []*x509.Certificate) error {
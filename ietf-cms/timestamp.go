@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/x509"
 	"errors"
+	"time"
 
 	"github.com/github/smimesign/ietf-cms/oid"
 	"github.com/github/smimesign/ietf-cms/protocol"
@@ -13,23 +14,32 @@ import (
 // AddTimestamps adds a timestamp to the SignedData using the RFC3161
 // timestamping service at the given URL. This timestamp proves that the signed
 // message existed the time of generation, allowing verifiers to have more trust
-// in old messages signed with revoked keys.
+// in old messages signed with revoked keys. SignerInfos that already have a
+// timestamp are left alone, so this may be called on previously timestamped
+// signatures. Signed attributes are never modified.
 func (sd *SignedData) AddTimestamps(url string) error {
-	var (
-		attrs = make([]protocol.Attribute, len(sd.psd.SignerInfos))
-		err   error
-	)
+	attrs := make([]*protocol.Attribute, len(sd.psd.SignerInfos))
 
 	// Fetch all timestamp tokens before adding any to sd. This avoids a partial
 	// failure.
-	for i := range attrs {
-		if attrs[i], err = fetchTS(url, sd.psd.SignerInfos[i]); err != nil {
+	for i, si := range sd.psd.SignerInfos {
+		if hasTS, err := hasTimestamp(si); err != nil {
 			return err
+		} else if hasTS {
+			continue
 		}
+
+		attr, err := fetchTS(url, si)
+		if err != nil {
+			return err
+		}
+		attrs[i] = &attr
 	}
 
 	for i := range attrs {
-		sd.psd.SignerInfos[i].UnsignedAttrs = append(sd.psd.SignerInfos[i].UnsignedAttrs, attrs[i])
+		if attrs[i] != nil {
+			sd.psd.SignerInfos[i].UnsignedAttrs = append(sd.psd.SignerInfos[i].UnsignedAttrs, *attrs[i])
+		}
 	}
 
 	return nil
@@ -76,8 +86,9 @@ func tsRequest(si protocol.SignerInfo) (timestamp.Request, error) {
 	}, nil
 }
 
-// getTimestamp verifies and returns the timestamp.Info from the SignerInfo.
-func getTimestamp(si protocol.SignerInfo, opts x509.VerifyOptions) (timestamp.Info, error) {
+// getTimestamp verifies and returns the timestamp.Info from the SignerInfo. The
+// timestamp token's algorithms must be allowed by policy.
+func getTimestamp(si protocol.SignerInfo, opts x509.VerifyOptions, policy *AlgorithmPolicy) (timestamp.Info, error) {
 	rawValue, err := si.UnsignedAttrs.GetOnlyAttributeValueBytes(oid.AttributeTimeStampToken)
 	if err != nil {
 		return timestamp.Info{}, err
@@ -98,6 +109,7 @@ func getTimestamp(si protocol.SignerInfo, opts x509.VerifyOptions) (timestamp.In
 	}
 
 	// verify timestamp signature and certificate chain..
+	tst.Policy = policy
 	if _, err = tst.Verify(opts); err != nil {
 		return timestamp.Info{}, err
 	}
@@ -107,6 +119,9 @@ func getTimestamp(si protocol.SignerInfo, opts x509.VerifyOptions) (timestamp.In
 	if err != nil {
 		return timestamp.Info{}, err
 	}
+	if err = policy.checkHash(hash); err != nil {
+		return timestamp.Info{}, err
+	}
 	mi, err := timestamp.NewMessageImprint(hash, bytes.NewReader(si.Signature))
 	if err != nil {
 		return timestamp.Info{}, err
@@ -118,6 +133,65 @@ func getTimestamp(si protocol.SignerInfo, opts x509.VerifyOptions) (timestamp.In
 	return tsti, nil
 }
 
+// Timestamps gets the time from each SignerInfo's timestamp, in the same order
+// as the chains returned by Verify. The timestamps' signatures and certificate
+// chains are verified using opts. The zero time is given for SignerInfos
+// without a timestamp.
+func (sd *SignedData) Timestamps(opts x509.VerifyOptions) ([]time.Time, error) {
+	certs, err := sd.psd.X509Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Intermediates = intermediatesWith(opts.Intermediates, certs)
+
+	opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}
+
+	times := make([]time.Time, len(sd.psd.SignerInfos))
+
+	for i, si := range sd.psd.SignerInfos {
+		if times[i], err = signerTimestamp(si, opts, sd.Policy); err != nil {
+			return nil, err
+		}
+	}
+
+	return times, nil
+}
+
+// SignerTimestamp is like Timestamps, but only gets the time from the
+// timestamp of the SignerInfo at index i.
+func (sd *SignedData) SignerTimestamp(i int, opts x509.VerifyOptions) (time.Time, error) {
+	if i < 0 || i >= len(sd.psd.SignerInfos) {
+		return time.Time{}, errors.New("no such signer")
+	}
+
+	certs, err := sd.psd.X509Certificates()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	opts.Intermediates = intermediatesWith(opts.Intermediates, certs)
+
+	opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}
+
+	return signerTimestamp(sd.psd.SignerInfos[i], opts, sd.Policy)
+}
+
+// signerTimestamp gets the verified time from si's timestamp, or the zero time
+// if si doesn't have a timestamp.
+func signerTimestamp(si protocol.SignerInfo, opts x509.VerifyOptions, policy *AlgorithmPolicy) (time.Time, error) {
+	if hasTS, err := hasTimestamp(si); err != nil || !hasTS {
+		return time.Time{}, err
+	}
+
+	tsti, err := getTimestamp(si, opts, policy)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return tsti.GenTime, nil
+}
+
 // hasTimestamp checks if si has a timestamp.
 func hasTimestamp(si protocol.SignerInfo) (bool, error) {
 	vals, err := si.UnsignedAttrs.GetValues(oid.AttributeTimeStampToken)
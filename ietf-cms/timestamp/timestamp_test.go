@@ -163,6 +163,18 @@ func TestMessageImprint(t *testing.T) {
 		t.Fatal("expected m1!=m2")
 	}
 
+	// SHA-3 digest
+	mi2, err = NewMessageImprint(crypto.SHA3_256, bytes.NewReader(m))
+	if err != nil {
+		panic(err)
+	}
+	if hash, err := mi2.Hash(); err != nil || hash != crypto.SHA3_256 {
+		t.Fatalf("expected SHA3-256, got %v, %v", hash, err)
+	}
+	if mi1.Equal(mi2) {
+		t.Fatal("expected m1!=m2")
+	}
+
 	// different message
 	mi2, err = NewMessageImprint(crypto.SHA256, bytes.NewReader([]byte("wrong")))
 	if err != nil {
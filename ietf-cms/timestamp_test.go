@@ -1,6 +1,7 @@
 package cms
 
 import (
+	"bytes"
 	"crypto/rsa"
 	"crypto/x509"
 	"strings"
@@ -24,7 +25,7 @@ func TestAddTimestamps(t *testing.T) {
 	if _, err := sd.Verify(intermediateOpts); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err != nil {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -64,6 +65,89 @@ func TestAddTimestamps(t *testing.T) {
 	}
 }
 
+func TestAddTimestampsExistingSignature(t *testing.T) {
+	tsa.Clear()
+	sd, _ := NewSignedData([]byte("hi"))
+	sd.Sign(leaf.Chain(), leaf.PrivateKey)
+	der, err := sd.ToDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Timestamp a parsed signature, as would be done for a historic signature.
+	sd, err = ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedAttrs, err := sd.psd.SignerInfos[0].SignedAttrs.MarshaledForVerification()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.AddTimestamps("https://google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if der, err = sd.ToDER(); err != nil {
+		t.Fatal(err)
+	}
+	if sd, err = ParseSignedData(der); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.Verify(intermediateOpts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = getTimestamp(sd.psd.SignerInfos[0], intermediateOpts, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed attributes must be untouched.
+	newSignedAttrs, err := sd.psd.SignerInfos[0].SignedAttrs.MarshaledForVerification()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(signedAttrs, newSignedAttrs) {
+		t.Fatal("expected signed attributes to be unchanged")
+	}
+
+	// Timestamping again shouldn't add a second token.
+	if err = sd.AddTimestamps("https://google.com"); err != nil {
+		t.Fatal(err)
+	}
+	if vals, err := sd.psd.SignerInfos[0].UnsignedAttrs.GetValues(oid.AttributeTimeStampToken); err != nil {
+		t.Fatal(err)
+	} else if len(vals) != 1 {
+		t.Fatalf("expected 1 timestamp, got %d", len(vals))
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	tsa.Clear()
+	sd, _ := NewSignedData([]byte("hi"))
+	sd.Sign(leaf.Chain(), leaf.PrivateKey)
+
+	times, err := sd.Timestamps(intermediateOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 1 || !times[0].IsZero() {
+		t.Fatalf("expected one zero time, got %v", times)
+	}
+
+	if err = sd.AddTimestamps("https://google.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if times, err = sd.Timestamps(intermediateOpts); err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 1 || times[0].Before(time.Now().Add(-time.Minute)) || times[0].After(time.Now()) {
+		t.Fatalf("expected one recent time, got %v", times)
+	}
+
+	if _, err = sd.Timestamps(otherRootOpts); err == nil {
+		t.Fatal("expected error verifying timestamp with wrong root")
+	}
+}
+
 func TestTimestampsVerifications(t *testing.T) {
 	getTimestampedSignedData := func() *SignedData {
 		sd, _ := NewSignedData([]byte("hi"))
@@ -78,7 +162,7 @@ func TestTimestampsVerifications(t *testing.T) {
 	// Good timestamp
 	tsa.Clear()
 	sd := getTimestampedSignedData()
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err != nil {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts, nil); err != nil {
 		t.Fatal(err)
 	}
 	if _, err := sd.Verify(intermediateOpts); err != nil {
@@ -97,7 +181,7 @@ func TestTimestampsVerifications(t *testing.T) {
 		return info
 	})
 	sd = getTimestampedSignedData()
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err != nil {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts, nil); err != nil {
 		t.Fatal(err)
 	}
 	if _, err := sd.Verify(intermediateOpts); err == nil || !strings.HasPrefix(err.Error(), "x509: certificate has expired") {
@@ -116,7 +200,7 @@ func TestTimestampsVerifications(t *testing.T) {
 		return info
 	})
 	sd = getTimestampedSignedData()
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err != nil {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts, nil); err != nil {
 		t.Fatal(err)
 	}
 	if _, err := sd.Verify(intermediateOpts); err != nil {
@@ -135,7 +219,7 @@ func TestTimestampsVerifications(t *testing.T) {
 		return info
 	})
 	sd = getTimestampedSignedData()
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err != nil {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts, nil); err != nil {
 		t.Fatal(err)
 	}
 	if _, err := sd.Verify(intermediateOpts); err == nil || !strings.HasPrefix(err.Error(), "x509: certificate has expired") {
@@ -154,7 +238,7 @@ func TestTimestampsVerifications(t *testing.T) {
 		return info
 	})
 	sd = getTimestampedSignedData()
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err != nil {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts, nil); err != nil {
 		t.Fatal(err)
 	}
 	if _, err := sd.Verify(intermediateOpts); err != nil {
@@ -167,7 +251,7 @@ func TestTimestampsVerifications(t *testing.T) {
 		return info
 	})
 	sd = getTimestampedSignedData()
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err == nil || err.Error() != "invalid message imprint" {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts, nil); err == nil || err.Error() != "invalid message imprint" {
 		t.Fatalf("expected 'invalid message imprint', got %v", err)
 	}
 
@@ -179,7 +263,7 @@ func TestTimestampsVerifications(t *testing.T) {
 		return tst
 	})
 	sd = getTimestampedSignedData()
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err == nil {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts, nil); err == nil {
 		t.Fatal("expected error")
 	} else if _, ok := err.(x509.UnknownAuthorityError); !ok {
 		t.Fatalf("expected x509.UnknownAuthorityError, got %v", err)
@@ -191,7 +275,7 @@ func TestTimestampsVerifications(t *testing.T) {
 		return tst
 	})
 	sd = getTimestampedSignedData()
-	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts); err != rsa.ErrVerification {
+	if _, err := getTimestamp(sd.psd.SignerInfos[0], intermediateOpts, nil); err != rsa.ErrVerification {
 		t.Fatalf("expected %v, got %v", rsa.ErrVerification, err)
 	}
 }
@@ -2,9 +2,15 @@ package cms
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
 	"errors"
+	"hash"
+	"io"
 
+	"github.com/github/smimesign/ietf-cms/oid"
 	"github.com/github/smimesign/ietf-cms/protocol"
 )
 
@@ -41,7 +47,63 @@ func (sd *SignedData) VerifyDetached(message []byte, opts x509.VerifyOptions) ([
 	return sd.verify(message, opts)
 }
 
+// CheckSignatures checks the SignerInfos' signatures over the encapsulated
+// content, without verifying the signers' certificates. The certificates whose
+// keys made the signatures are returned. A BadSignatureError is returned if a
+// signature doesn't match the content.
+//
+// WARNING: the returned certificates aren't trusted. Use VerifyChains or Verify
+// to verify them.
+func (sd *SignedData) CheckSignatures() ([]*x509.Certificate, error) {
+	econtent, err := sd.psd.EncapContentInfo.EContentValue()
+	if err != nil {
+		return nil, err
+	}
+	if econtent == nil {
+		return nil, errors.New("detached signature")
+	}
+
+	return sd.checkSignatures(econtent)
+}
+
+// CheckSignaturesDetached checks the SignerInfos' detached signatures over the
+// provided data message, without verifying the signers' certificates. The
+// certificates whose keys made the signatures are returned. A
+// BadSignatureError is returned if a signature doesn't match the message.
+//
+// WARNING: the returned certificates aren't trusted. Use VerifyChains or
+// VerifyDetached to verify them.
+func (sd *SignedData) CheckSignaturesDetached(message []byte) ([]*x509.Certificate, error) {
+	if sd.psd.EncapContentInfo.EContent.Bytes != nil {
+		return nil, errors.New("signature not detached")
+	}
+	return sd.checkSignatures(message)
+}
+
+// BadSignatureError is returned by CheckSignatures and CheckSignaturesDetached
+// when a SignerInfo's signature or message digest doesn't match the signed
+// data. Cert is the certificate of the signer whose signature is bad.
+type BadSignatureError struct {
+	Cert *x509.Certificate
+	Err  error
+}
+
+func (err BadSignatureError) Error() string {
+	return err.Err.Error()
+}
+
 func (sd *SignedData) verify(econtent []byte, opts x509.VerifyOptions) ([][][]*x509.Certificate, error) {
+	if _, err := sd.checkSignatures(econtent); err != nil {
+		if bse, ok := err.(BadSignatureError); ok {
+			return nil, bse.Err
+		}
+		return nil, err
+	}
+
+	return sd.VerifyChains(opts)
+}
+
+func (sd *SignedData) checkSignatures(econtent []byte) ([]*x509.Certificate, error) {
 	if len(sd.psd.SignerInfos) == 0 {
 		return nil, protocol.ASN1Error{Message: "no signatures found"}
 	}
@@ -51,124 +113,447 @@ func (sd *SignedData) verify(econtent []byte, opts x509.VerifyOptions) ([][][]*x
 		return nil, err
 	}
 
-	if opts.Intermediates == nil {
-		opts.Intermediates = x509.NewCertPool()
+	signers := make([]*x509.Certificate, 0, len(sd.psd.SignerInfos))
+
+	for _, si := range sd.psd.SignerInfos {
+		cert, err := sd.checkSignature(si, econtent, certs)
+		if err != nil {
+			return nil, err
+		}
+
+		signers = append(signers, cert)
 	}
 
-	for _, cert := range certs {
-		opts.Intermediates.AddCert(cert)
+	return signers, nil
+}
+
+// CheckSignerSignature checks the signature of the SignerInfo at index i,
+// without verifying the signer's certificate. message is the signed data for
+// detached signatures and must be nil for attached signatures. This allows
+// each signer of a multi-signer signature to be checked separately. The
+// certificate whose key made the signature is returned. A BadSignatureError is
+// returned if the signature doesn't match the signed data.
+//
+// WARNING: the returned certificate isn't trusted. Use VerifySignerChains to
+// verify it.
+func (sd *SignedData) CheckSignerSignature(i int, message []byte) (*x509.Certificate, error) {
+	if i < 0 || i >= len(sd.psd.SignerInfos) {
+		return nil, errors.New("no such signer")
 	}
 
-	// Use provided verification options for timestamp verification also, but
-	// explicitly ask for key-usage=timestamping.
-	tsOpts := opts
-	tsOpts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}
+	if message == nil {
+		var err error
+		if message, err = sd.psd.EncapContentInfo.EContentValue(); err != nil {
+			return nil, err
+		}
+		if message == nil {
+			return nil, errors.New("detached signature")
+		}
+	} else if sd.psd.EncapContentInfo.EContent.Bytes != nil {
+		return nil, errors.New("signature not detached")
+	}
 
-	chains := make([][][]*x509.Certificate, 0, len(sd.psd.SignerInfos))
+	certs, err := sd.psd.X509Certificates()
+	if err != nil {
+		return nil, err
+	}
 
-	for _, si := range sd.psd.SignerInfos {
-		var signedMessage []byte
-
-		// SignedAttrs is optional if EncapContentInfo eContentType isn't id-data.
-		if si.SignedAttrs == nil {
-			// SignedAttrs may only be absent if EncapContentInfo eContentType is
-			// id-data.
-			if !sd.psd.EncapContentInfo.IsTypeData() {
-				return nil, protocol.ASN1Error{Message: "missing SignedAttrs"}
-			}
+	return sd.checkSignature(sd.psd.SignerInfos[i], message, certs)
+}
 
-			// If SignedAttrs is absent, the signature is over the original
-			// encapsulated content itself.
-			signedMessage = econtent
-		} else {
-			// If SignedAttrs is present, we validate the mandatory ContentType and
-			// MessageDigest attributes.
-			siContentType, err := si.GetContentTypeAttribute()
-			if err != nil {
-				return nil, err
-			}
-			if !siContentType.Equal(sd.psd.EncapContentInfo.EContentType) {
-				return nil, protocol.ASN1Error{Message: "invalid SignerInfo ContentType attribute"}
-			}
+// checkSignature checks a single SignerInfo's signature over econtent,
+// returning the signer's certificate.
+func (sd *SignedData) checkSignature(si protocol.SignerInfo, econtent []byte, certs []*x509.Certificate) (*x509.Certificate, error) {
+	// SignedAttrs is optional if EncapContentInfo eContentType isn't id-data.
+	if si.SignedAttrs == nil {
+		// SignedAttrs may only be absent if EncapContentInfo eContentType is
+		// id-data.
+		if !sd.psd.EncapContentInfo.IsTypeData() {
+			return nil, protocol.ASN1Error{Message: "missing SignedAttrs"}
+		}
 
-			// Calculate the digest over the actual message.
-			hash, err := si.Hash()
-			if err != nil {
-				return nil, err
-			}
-			actualMessageDigest := hash.New()
-			if _, err = actualMessageDigest.Write(econtent); err != nil {
-				return nil, err
-			}
+		// If SignedAttrs is absent, the signature is over the original
+		// encapsulated content itself.
+		return sd.checkSignedMessage(si, econtent, nil, certs)
+	}
 
-			// Get the digest from the SignerInfo.
-			messageDigestAttr, err := si.GetMessageDigestAttribute()
-			if err != nil {
-				return nil, err
-			}
+	// Calculate the digest over the actual message.
+	hash, err := si.Hash()
+	if err != nil {
+		return nil, err
+	}
+	if err = sd.Policy.checkHash(hash); err != nil {
+		return nil, err
+	}
+	actualMessageDigest := hash.New()
+	if _, err = actualMessageDigest.Write(econtent); err != nil {
+		return nil, err
+	}
 
-			// Make sure message digests match.
-			if !bytes.Equal(messageDigestAttr, actualMessageDigest.Sum(nil)) {
-				return nil, errors.New("invalid message digest")
-			}
+	return sd.checkSignatureDigest(si, actualMessageDigest.Sum(nil), certs)
+}
 
-			// The signature is over the DER encoded signed attributes, minus the
-			// leading class/tag/length bytes. This includes the digest of the
-			// original message, so it is implicitly signed too.
-			if signedMessage, err = si.SignedAttrs.MarshaledForVerification(); err != nil {
-				return nil, err
-			}
-		}
+// checkSignatureDigest checks a single SignerInfo's signature, given the
+// digest of the content calculated with the SignerInfo's digest algorithm. The
+// SignerInfo must have SignedAttrs, since the signature is otherwise over the
+// content itself.
+func (sd *SignedData) checkSignatureDigest(si protocol.SignerInfo, actualMessageDigest []byte, certs []*x509.Certificate) (*x509.Certificate, error) {
+	if si.SignedAttrs == nil {
+		return nil, errors.New("can't check signature without SignedAttrs from a digest")
+	}
 
-		cert, err := si.FindCertificate(certs)
-		if err != nil {
+	// If SignedAttrs is present, we validate the mandatory ContentType and
+	// MessageDigest attributes.
+	siContentType, err := si.GetContentTypeAttribute()
+	if err != nil {
+		return nil, err
+	}
+	if !siContentType.Equal(sd.psd.EncapContentInfo.EContentType) {
+		return nil, protocol.ASN1Error{Message: "invalid SignerInfo ContentType attribute"}
+	}
+
+	hash, err := si.Hash()
+	if err != nil {
+		return nil, err
+	}
+	if err = sd.Policy.checkHash(hash); err != nil {
+		return nil, err
+	}
+
+	// Get the digest from the SignerInfo.
+	messageDigestAttr, err := si.GetMessageDigestAttribute()
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure message digests match. This is reported once we've found the
+	// signer's certificate.
+	var digestErr error
+	if !bytes.Equal(messageDigestAttr, actualMessageDigest) {
+		digestErr = errors.New("invalid message digest")
+	}
+
+	// The signature is over the DER encoded signed attributes, minus the
+	// leading class/tag/length bytes. This includes the digest of the
+	// original message, so it is implicitly signed too.
+	signedMessage, err := si.SignedAttrs.MarshaledForVerification()
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.checkSignedMessage(si, signedMessage, digestErr, certs)
+}
+
+// checkSignedMessage checks a single SignerInfo's signature over
+// signedMessage, returning the signer's certificate. A non-nil digestErr is
+// returned as a BadSignatureError once the signer's certificate is found.
+func (sd *SignedData) checkSignedMessage(si protocol.SignerInfo, signedMessage []byte, digestErr error, certs []*x509.Certificate) (*x509.Certificate, error) {
+	cert, err := si.FindCertificate(certs)
+	if err != nil {
+		return nil, err
+	}
+
+	if digestErr != nil {
+		return nil, BadSignatureError{cert, digestErr}
+	}
+
+	var (
+		algo   = si.X509SignatureAlgorithm()
+		pkAlgo x509.PublicKeyAlgorithm
+		hash   crypto.Hash
+	)
+
+	if algo != x509.UnknownSignatureAlgorithm {
+		err = sd.Policy.checkSignatureAlgorithm(algo)
+	} else if pkAlgo, hash, err = si.PublicKeyAlgorithmAndHash(); err == nil {
+		// The x509 package doesn't know this signature algorithm, so we
+		// check it ourselves.
+		err = sd.Policy.checkHash(hash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := sd.Policy.checkPublicKey(cert.PublicKey); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case si.SignatureAlgorithm.Algorithm.Equal(oid.SignatureAlgorithmRSAPSS):
+		err = checkPSSSignature(cert, si, signedMessage)
+	case algo == x509.UnknownSignatureAlgorithm:
+		err = checkHashSignature(cert, pkAlgo, hash, signedMessage, si.Signature)
+	default:
+		err = cert.CheckSignature(algo, signedMessage, si.Signature)
+	}
+	if err != nil {
+		if _, isInsecure := err.(x509.InsecureAlgorithmError); isInsecure || err == x509.ErrUnsupportedAlgorithm {
 			return nil, err
 		}
+		return nil, BadSignatureError{cert, err}
+	}
+
+	return cert, nil
+}
+
+// checkPSSSignature checks a SignerInfo's RSASSA-PSS signature over
+// signedMessage. Unlike x509.Certificate.CheckSignature, this uses the salt
+// length from the signature algorithm's parameters.
+func checkPSSSignature(cert *x509.Certificate, si protocol.SignerInfo, signedMessage []byte) error {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return x509.ErrUnsupportedAlgorithm
+	}
+
+	params, err := protocol.ParseRSASSAPSSParams(si.SignatureAlgorithm)
+	if err != nil {
+		return err
+	}
 
-		algo := si.X509SignatureAlgorithm()
-		if algo == x509.UnknownSignatureAlgorithm {
-			return nil, protocol.ErrUnsupported
+	opts, err := params.PSSOptions()
+	if err != nil {
+		return x509.ErrUnsupportedAlgorithm
+	}
+
+	h := opts.Hash.New()
+	h.Write(signedMessage)
+
+	return rsa.VerifyPSS(pub, opts.Hash, h.Sum(nil), si.Signature, opts)
+}
+
+// checkHashSignature checks an RSA PKCS #1 v1.5 or ECDSA signature over
+// signedMessage, made with the given public key algorithm and hash. This is used
+// for signature algorithms that have no x509.SignatureAlgorithm.
+func checkHashSignature(cert *x509.Certificate, pkAlgo x509.PublicKeyAlgorithm, hash crypto.Hash, signedMessage, signature []byte) error {
+	if cert.PublicKeyAlgorithm != pkAlgo {
+		return errors.New("signature algorithm doesn't match certificate public key")
+	}
+
+	h := hash.New()
+	h.Write(signedMessage)
+	digest := h.Sum(nil)
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, signature) {
+			return errors.New("ECDSA verification failure")
 		}
+		return nil
+	}
 
-		if err := cert.CheckSignature(algo, signedMessage, si.Signature); err != nil {
-			return nil, err
+	return x509.ErrUnsupportedAlgorithm
+}
+
+// MessageDigests holds digests of detached content, keyed by digest algorithm.
+// They are calculated by DigestDetached.
+type MessageDigests map[crypto.Hash][]byte
+
+// DigestDetached reads the detached content from r, calculating its digest with
+// each distinct digest algorithm used by the SignerInfos in a single pass. This
+// allows large content to be verified without holding it in memory. Digest
+// algorithms that aren't supported are skipped, leaving the SignerInfos that
+// use them to fail verification.
+func (sd *SignedData) DigestDetached(r io.Reader) (MessageDigests, error) {
+	if sd.psd.EncapContentInfo.EContent.Bytes != nil {
+		return nil, errors.New("signature not detached")
+	}
+
+	var (
+		hashes  = map[crypto.Hash]hash.Hash{}
+		writers []io.Writer
+	)
+
+	for _, si := range sd.psd.SignerInfos {
+		h, err := si.Hash()
+		if err != nil {
+			continue
 		}
+		if _, ok := hashes[h]; !ok {
+			hashes[h] = h.New()
+			writers = append(writers, hashes[h])
+		}
+	}
 
-		// If the caller didn't specify the signature time, we'll use the verified
-		// timestamp. If there's no timestamp we use the current time when checking
-		// the cert validity window. This isn't perfect because the signature may
-		// have been created before the cert's not-before date, but this is the best
-		// we can do. We update a copy of opts because we are verifying multiple
-		// signatures in a loop and only want the timestamp to affect this one.
-		optsCopy := opts
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
 
-		if hasTS, err := hasTimestamp(si); err != nil {
-			return nil, err
-		} else if hasTS {
-			tsti, err := getTimestamp(si, tsOpts)
-			if err != nil {
-				return nil, err
-			}
+	digests := make(MessageDigests, len(hashes))
+	for h, md := range hashes {
+		digests[h] = md.Sum(nil)
+	}
 
-			// This check is slightly redundant, given that the cert validity times
-			// are checked by cert.Verify. We take the timestamp accuracy into account
-			// here though, whereas cert.Verify will not.
-			if !tsti.Before(cert.NotAfter) || !tsti.After(cert.NotBefore) {
-				return nil, x509.CertificateInvalidError{Cert: cert, Reason: x509.Expired, Detail: ""}
-			}
+	return digests, nil
+}
 
-			if optsCopy.CurrentTime.IsZero() {
-				optsCopy.CurrentTime = tsti.GenTime
+// VerifyDetachedReader is like VerifyDetached, but reads the message from r
+// rather than holding it in memory. The message is only read once, however
+// many SignerInfos there are. SignerInfos must have signed attributes.
+//
+// WARNING: this function doesn't do any revocation checking.
+func (sd *SignedData) VerifyDetachedReader(r io.Reader, opts x509.VerifyOptions) ([][][]*x509.Certificate, error) {
+	if len(sd.psd.SignerInfos) == 0 {
+		return nil, protocol.ASN1Error{Message: "no signatures found"}
+	}
+
+	digests, err := sd.DigestDetached(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sd.psd.SignerInfos {
+		if _, err := sd.CheckSignerDigests(i, digests); err != nil {
+			if bse, ok := err.(BadSignatureError); ok {
+				return nil, bse.Err
 			}
+			return nil, err
 		}
+	}
+
+	return sd.VerifyChains(opts)
+}
+
+// CheckSignerDigests is like CheckSignerSignature, but checks the signature of
+// the SignerInfo at index i against digests of the detached content, as
+// calculated by DigestDetached.
+//
+// WARNING: the returned certificate isn't trusted. Use VerifySignerChains to
+// verify it.
+func (sd *SignedData) CheckSignerDigests(i int, digests MessageDigests) (*x509.Certificate, error) {
+	if i < 0 || i >= len(sd.psd.SignerInfos) {
+		return nil, errors.New("no such signer")
+	}
+	si := sd.psd.SignerInfos[i]
+
+	hash, err := si.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	digest, ok := digests[hash]
+	if !ok {
+		return nil, errors.New("missing message digest")
+	}
+
+	certs, err := sd.psd.X509Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.checkSignatureDigest(si, digest, certs)
+}
 
-		if chain, err := cert.Verify(optsCopy); err != nil {
+// VerifyChains verifies the certificate chains of the SignerInfos'
+// certificates, without checking the signatures themselves. Each certificate
+// is verified at the time of its SignerInfo's verified timestamp, if it has
+// one. Nil may be provided to use system roots. The full chains for the
+// signers' certificates are returned, in the same order as the SignerInfos.
+//
+// WARNING: this function doesn't do any revocation checking.
+func (sd *SignedData) VerifyChains(opts x509.VerifyOptions) ([][][]*x509.Certificate, error) {
+	if len(sd.psd.SignerInfos) == 0 {
+		return nil, protocol.ASN1Error{Message: "no signatures found"}
+	}
+
+	certs, err := sd.psd.X509Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	chains := make([][][]*x509.Certificate, 0, len(sd.psd.SignerInfos))
+
+	for _, si := range sd.psd.SignerInfos {
+		chain, err := sd.verifyChains(si, certs, opts)
+		if err != nil {
 			return nil, err
-		} else {
-			chains = append(chains, chain)
 		}
+
+		chains = append(chains, chain)
 	}
 
 	// OK
 	return chains, nil
 }
+
+// VerifySignerChains is like VerifyChains, but only verifies the certificate
+// of the SignerInfo at index i.
+//
+// WARNING: this function doesn't do any revocation checking.
+func (sd *SignedData) VerifySignerChains(i int, opts x509.VerifyOptions) ([][]*x509.Certificate, error) {
+	if i < 0 || i >= len(sd.psd.SignerInfos) {
+		return nil, errors.New("no such signer")
+	}
+
+	certs, err := sd.psd.X509Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.verifyChains(sd.psd.SignerInfos[i], certs, opts)
+}
+
+// verifyChains verifies the certificate chains of a single SignerInfo's
+// certificate, using the certificates from the SignedData as intermediates.
+func (sd *SignedData) verifyChains(si protocol.SignerInfo, certs []*x509.Certificate, opts x509.VerifyOptions) ([][]*x509.Certificate, error) {
+	opts.Intermediates = intermediatesWith(opts.Intermediates, certs)
+
+	// Use provided verification options for timestamp verification also, but
+	// explicitly ask for key-usage=timestamping.
+	tsOpts := opts
+	tsOpts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping}
+
+	cert, err := si.FindCertificate(certs)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the caller didn't specify the signature time, we'll use the verified
+	// timestamp. If there's no timestamp we use the current time when checking
+	// the cert validity window. This isn't perfect because the signature may
+	// have been created before the cert's not-before date, but this is the best
+	// we can do.
+	if hasTS, err := hasTimestamp(si); err != nil {
+		return nil, err
+	} else if hasTS {
+		tsti, err := getTimestamp(si, tsOpts, sd.Policy)
+		if err != nil {
+			return nil, err
+		}
+
+		// This check is slightly redundant, given that the cert validity times
+		// are checked by cert.Verify. We take the timestamp accuracy into account
+		// here though, whereas cert.Verify will not.
+		if !tsti.Before(cert.NotAfter) || !tsti.After(cert.NotBefore) {
+			return nil, x509.CertificateInvalidError{Cert: cert, Reason: x509.Expired, Detail: ""}
+		}
+
+		if opts.CurrentTime.IsZero() {
+			opts.CurrentTime = tsti.GenTime
+		}
+	}
+
+	chain, err := cert.Verify(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return sd.Policy.filterChains(chain)
+}
+
+// intermediatesWith returns a copy of the intermediates pool, or a new pool if
+// it is nil, with certs added. The caller's pool is left alone, so that the
+// certificates from one signature aren't used for verifying another.
+func intermediatesWith(pool *x509.CertPool, certs []*x509.Certificate) *x509.CertPool {
+	if pool == nil {
+		pool = x509.NewCertPool()
+	} else {
+		pool = pool.Clone()
+	}
+
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+
+	return pool
+}
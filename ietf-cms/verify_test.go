@@ -2,6 +2,10 @@ package cms
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
@@ -9,6 +13,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/github/smimesign/fakeca"
 	"github.com/github/smimesign/ietf-cms/protocol"
 	"golang.org/x/xerrors"
 )
@@ -207,6 +212,214 @@ func TestVerifyChain(t *testing.T) {
 	}
 }
 
+func TestCheckSignaturesAndVerifyChains(t *testing.T) {
+	der, err := SignDetached([]byte("hello, world!"), leaf.Chain(), leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := sd.CheckSignaturesDetached([]byte("hello, world!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(leaf.Certificate) {
+		t.Fatal("expected leaf certificate")
+	}
+
+	_, err = sd.CheckSignaturesDetached([]byte("goodbye, world!"))
+	if bse, ok := err.(BadSignatureError); !ok {
+		t.Fatalf("expected BadSignatureError, got %v", err)
+	} else if !bse.Cert.Equal(leaf.Certificate) {
+		t.Fatal("expected leaf certificate")
+	}
+
+	if _, err = sd.CheckSignatures(); err == nil {
+		t.Fatal("expected error checking detached signature as attached")
+	}
+
+	if _, err = sd.VerifyChains(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = sd.VerifyChains(otherRootOpts); err == nil {
+		t.Fatal("expected chain verification error")
+	}
+}
+
+func TestVerifyChainsIntermediatesNotShared(t *testing.T) {
+	withChain, err := Sign([]byte("hello, world!"), leaf.Chain(), leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafOnly, err := Sign([]byte("hello, world!"), []*x509.Certificate{leaf.Certificate}, leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := x509.VerifyOptions{Roots: root.ChainPool(), Intermediates: x509.NewCertPool()}
+
+	sd, err := ParseSignedData(withChain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyChains(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.Timestamps(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// The intermediate from the first signature isn't available to the second.
+	if sd, err = ParseSignedData(leafOnly); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifyChains(opts); err == nil {
+		t.Fatal("expected chain verification error")
+	}
+}
+
+func TestCheckSignerSignatureAndVerifySignerChains(t *testing.T) {
+	other := otherRoot.Issue()
+
+	sd, err := NewSignedData([]byte("hello, world!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.Sign(leaf.Chain(), leaf.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = sd.Sign(other.Chain(), other.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, ident := range []*fakeca.Identity{leaf, other} {
+		cert, err := sd.CheckSignerSignature(i, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.Equal(ident.Certificate) {
+			t.Fatalf("expected certificate of signer %d", i)
+		}
+	}
+
+	if _, err = sd.CheckSignerSignature(0, []byte("hello, world!")); err == nil {
+		t.Fatal("expected error checking attached signature as detached")
+	}
+	if _, err = sd.CheckSignerSignature(2, nil); err == nil {
+		t.Fatal("expected error checking missing signer")
+	}
+
+	// Only the first signer chains to root.
+	if _, err = sd.VerifySignerChains(0, rootOpts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sd.VerifySignerChains(1, rootOpts); err == nil {
+		t.Fatal("expected chain verification error")
+	}
+	if _, err = sd.VerifyChains(rootOpts); err == nil {
+		t.Fatal("expected chain verification error")
+	}
+
+	if ts, err := sd.SignerTimestamp(1, rootOpts); err != nil {
+		t.Fatal(err)
+	} else if !ts.IsZero() {
+		t.Fatal("expected zero time for signer without timestamp")
+	}
+}
+
+func TestVerifyDetachedReader(t *testing.T) {
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Leaf := intermediate.Issue(fakeca.PrivateKey(p384Key))
+
+	// The signers use SHA-256 and SHA-384.
+	sd, err := NewDetachedSignedData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ident := range []*fakeca.Identity{leaf, p384Leaf} {
+		if err = sd.SignReader(strings.NewReader("hello, world!"), ident.Chain(), ident.PrivateKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	digests, err := sd.DigestDetached(strings.NewReader("hello, world!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(digests) != 2 || digests[crypto.SHA256] == nil || digests[crypto.SHA384] == nil {
+		t.Fatalf("expected SHA-256 and SHA-384 digests, got %v", digests)
+	}
+
+	for i, ident := range []*fakeca.Identity{leaf, p384Leaf} {
+		cert, err := sd.CheckSignerDigests(i, digests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.Equal(ident.Certificate) {
+			t.Fatalf("expected certificate of signer %d", i)
+		}
+	}
+
+	if _, err = sd.VerifyDetachedReader(strings.NewReader("hello, world!"), rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = sd.VerifyDetachedReader(strings.NewReader("goodbye, world!"), rootOpts); err == nil {
+		t.Fatal("expected error verifying with wrong data")
+	}
+
+	attached, err := NewSignedData([]byte("hello, world!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = attached.DigestDetached(strings.NewReader("hello, world!")); err == nil {
+		t.Fatal("expected error digesting attached signature")
+	}
+}
+
+func TestVerifyAlgorithmPolicy(t *testing.T) {
+	der, err := Sign([]byte("hello, world!"), leaf.Chain(), leaf.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd, err := ParseSignedData(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sd.Policy = &DefaultAlgorithmPolicy
+	if _, err = sd.Verify(rootOpts); err != nil {
+		t.Fatal(err)
+	}
+
+	policies := []AlgorithmPolicy{
+		// signature digest
+		{Hashes: []crypto.Hash{crypto.SHA512}},
+		// leaf and root keys
+		{MinRSABits: 4096},
+		// intermediate key
+		{Curves: []elliptic.Curve{elliptic.P384()}},
+	}
+
+	for _, policy := range policies {
+		sd.Policy = &policy
+		if _, err = sd.Verify(rootOpts); err == nil {
+			t.Fatalf("expected policy %v to reject signature", policy)
+		} else if _, ok := err.(AlgorithmPolicyError); !ok {
+			t.Fatalf("expected AlgorithmPolicyError, got %v", err)
+		}
+	}
+}
+
 func TestVerifyDSAWithSHA1(t *testing.T) {
 	// Created with the following openssl commands:
 	// openssl dsaparam -out dsakey.pem -genkey 1024
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements diagnostic logging, modeled after gpg's --verbose,
+// --debug-level, --logger-fd and --log-file options. Log messages may include
+// certificate subjects, fingerprints and URLs, but must never include private
+// keys, PINs or other key material.
+
+// Debug levels, as accepted by --debug-level. Each level includes the messages
+// of the levels below it.
+const (
+	debugNone = iota
+	debugBasic
+	debugAdvanced
+	debugExpert
+	debugGuru
+)
+
+var debugLevelNames = map[string]int{
+	"none":     debugNone,
+	"basic":    debugBasic,
+	"advanced": debugAdvanced,
+	"expert":   debugExpert,
+	"guru":     debugGuru,
+}
+
+var (
+	// logOut is where log messages are written. It is nil if logging is off.
+	logOut io.Writer
+
+	// logLevel is the debug level from --debug-level. --verbose messages are
+	// logged at debugNone.
+	logLevel = debugNone
+
+	// logTimestamps adds the time and process ID to log messages, which is
+	// useful when appending to a log file from several processes.
+	logTimestamps bool
+)
+
+// setupLog configures logging from --verbose, --debug-level, --logger-fd and
+// --log-file. Logging is off unless --verbose or a debug level is given. Log
+// messages go to stderr unless --log-file or --logger-fd is given.
+func setupLog() error {
+	level, err := parseDebugLevel(*debugLevelOpt)
+	if err != nil {
+		return err
+	}
+
+	logOut, logLevel, logTimestamps = nil, level, false
+
+	if !*verboseFlag && level == debugNone {
+		return nil
+	}
+
+	switch {
+	case len(*logFileOpt) > 0:
+		f, err := os.OpenFile(*logFileOpt, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open log file (%s)", *logFileOpt)
+		}
+		logOut, logTimestamps = f, true
+	case *loggerFdOpt == 1:
+		// Stdout carries signatures and decrypted messages.
+		return errors.New("logger-fd cannot be stdout")
+	case *loggerFdOpt == 2 || *loggerFdOpt < 0:
+		logOut = stderr
+	default:
+		logOut = os.NewFile(uintptr(*loggerFdOpt), "logger")
+	}
+
+	return nil
+}
+
+// parseDebugLevel parses a --debug-level, which is either a level name or a
+// number like gpg's.
+func parseDebugLevel(level string) (int, error) {
+	if len(level) == 0 {
+		return debugNone, nil
+	}
+
+	if l, ok := debugLevelNames[level]; ok {
+		return l, nil
+	}
+
+	n, err := strconv.Atoi(level)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad debug level: %s", level)
+	}
+
+	switch {
+	case n == 0:
+		return debugNone, nil
+	case n <= 2:
+		return debugBasic, nil
+	case n <= 5:
+		return debugAdvanced, nil
+	case n <= 8:
+		return debugExpert, nil
+	default:
+		return debugGuru, nil
+	}
+}
+
+// logf writes a log message if logging is enabled.
+func logf(format string, args ...interface{}) {
+	if logOut == nil {
+		return
+	}
+
+	prefix := "smimesign: "
+	if logTimestamps {
+		prefix = fmt.Sprintf("%s smimesign[%d]: ", time.Now().Format("2006-01-02 15:04:05"), os.Getpid())
+	}
+
+	fmt.Fprintf(logOut, prefix+format+"\n", args...)
+}
+
+// debugf writes a log message if the debug level is at least level.
+func debugf(level int, format string, args ...interface{}) {
+	if logLevel >= level {
+		logf(format, args...)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDebugLevel(t *testing.T) {
+	for level, want := range map[string]int{
+		"":         debugNone,
+		"none":     debugNone,
+		"0":        debugNone,
+		"basic":    debugBasic,
+		"2":        debugBasic,
+		"advanced": debugAdvanced,
+		"5":        debugAdvanced,
+		"expert":   debugExpert,
+		"guru":     debugGuru,
+		"42":       debugGuru,
+	} {
+		got, err := parseDebugLevel(level)
+		require.NoError(t, err, level)
+		require.Equal(t, want, got, level)
+	}
+
+	for _, bad := range []string{"-1", "loud"} {
+		_, err := parseDebugLevel(bad)
+		require.Error(t, err, bad)
+	}
+}
+
+func TestLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "log")
+
+	sig, err := cms.Sign([]byte("hello, world!"), leaf.Chain(), leaf.PrivateKey)
+	require.NoError(t, err)
+
+	verify := func(args ...string) {
+		defer testSetup(t, append([]string{"--verify", "--trust-local-certs", "--log-file", logFile}, args...)...)()
+		defer func() { logOut = nil }()
+		require.NoError(t, setupLog())
+
+		stdinBuf.Write(sig)
+		require.NoError(t, commandVerify())
+	}
+
+	// Nothing is logged by default.
+	verify()
+	_, err = os.Stat(logFile)
+	require.True(t, os.IsNotExist(err))
+
+	verify("--verbose")
+	data, err := ioutil.ReadFile(logFile)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "checking signature 1 of 1")
+	require.NotContains(t, string(data), "found chain")
+
+	verify("--debug-level", "advanced")
+	data, err = ioutil.ReadFile(logFile)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "found chain")
+}
+
+func TestLoggerFd(t *testing.T) {
+	sig, err := cms.Sign([]byte("hello, world!"), leaf.Chain(), leaf.PrivateKey)
+	require.NoError(t, err)
+
+	// Logs go to stderr by default, which tests capture.
+	func() {
+		defer testSetup(t, "--verify", "--trust-local-certs", "--verbose")()
+		defer func() { logOut = nil }()
+		require.NoError(t, setupLog())
+
+		stdinBuf.Write(sig)
+		require.NoError(t, commandVerify())
+		require.Contains(t, stderrBuf.String(), "checking signature 1 of 1")
+	}()
+
+	// Stdout carries the command's output, so logs can't go there.
+	func() {
+		defer testSetup(t, "--verify", "--verbose", "--logger-fd", "1")()
+		defer func() { logOut = nil }()
+		require.EqualError(t, setupLog(), "logger-fd cannot be stdout")
+	}()
+}
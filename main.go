@@ -22,20 +22,49 @@ var (
 	defaultTSA = ""
 
 	// Action flags
-	helpFlag     = getopt.BoolLong("help", 'h', "print this help message")
-	versionFlag  = getopt.BoolLong("version", 'v', "print the version number")
-	signFlag     = getopt.BoolLong("sign", 's', "make a signature")
-	verifyFlag   = getopt.BoolLong("verify", 0, "verify a signature")
-	listKeysFlag = getopt.BoolLong("list-keys", 0, "show keys")
+	helpFlag           = getopt.BoolLong("help", 'h', "print this help message")
+	versionFlag        = getopt.BoolLong("version", 'v', "print the version number")
+	signFlag           = getopt.BoolLong("sign", 's', "make a signature")
+	verifyFlag         = getopt.BoolLong("verify", 0, "verify a signature")
+	listKeysFlag       = getopt.BoolLong("list-keys", 0, "show keys")
+	addTSFlag          = getopt.BoolLong("add-timestamp", 0, "add a timestamp to an existing signature")
+	verifyCommitsFlag  = getopt.BoolLong("verify-commits", 0, "verify the signatures of commits and tags in a rev-range")
+	verifyAuditLogFlag = getopt.BoolLong("verify-audit-log", 0, "check that signature files are recorded in the audit log")
+	encryptFlag        = getopt.BoolLong("encrypt", 'e', "encrypt a message")
+	decryptFlag        = getopt.BoolLong("decrypt", 'd', "decrypt a message")
 
 	// Option flags
-	localUserOpt    = getopt.StringLong("local-user", 'u', "", "use USER-ID to sign", "USER-ID")
-	detachSignFlag  = getopt.BoolLong("detach-sign", 'b', "make a detached signature")
-	armorFlag       = getopt.BoolLong("armor", 'a', "create ascii armored output")
-	statusFdOpt     = getopt.IntLong("status-fd", 0, -1, "write special status strings to the file descriptor n.", "n")
-	keyFormatOpt    = getopt.EnumLong("keyid-format", 0, []string{"long"}, "long", "select  how  to  display key IDs.", "{long}")
-	tsaOpt          = getopt.StringLong("timestamp-authority", 't', defaultTSA, "URL of RFC3161 timestamp authority to use for timestamping", "url")
-	includeCertsOpt = getopt.IntLong("include-certs", 0, -2, "-3 is the same as -2, but ommits issuer when cert has Authority Information Access extension. -2 includes all certs except root. -1 includes all certs. 0 includes no certs. 1 includes leaf cert. >1 includes n from the leaf. Default -2.", "n")
+	localUserOpt           = getopt.StringLong("local-user", 'u', "", "use USER-ID to sign", "USER-ID")
+	recipientOpt           = getopt.ListLong("recipient", 'r', "encrypt for USER-ID", "USER-ID")
+	recipientCertsOpt      = getopt.ListLong("recipient-certs", 0, "look for recipient certificates in these PEM files or directories", "path,...")
+	rsaOAEPFlag            = getopt.BoolLong("rsa-oaep", 0, "encrypt for RSA recipients with RSAES-OAEP instead of PKCS #1 v1.5")
+	detachSignFlag         = getopt.BoolLong("detach-sign", 'b', "make a detached signature")
+	rsaPSSFlag             = getopt.BoolLong("rsa-pss", 0, "sign with RSASSA-PSS instead of PKCS #1 v1.5 when using an RSA key")
+	digestAlgoOpt          = getopt.StringLong("digest-algo", 0, "", "digest algorithm for signing, instead of the default for the signing key", "name")
+	armorFlag              = getopt.BoolLong("armor", 'a', "create ascii armored output")
+	statusFdOpt            = getopt.IntLong("status-fd", 0, -1, "write special status strings to the file descriptor n.", "n")
+	keyFormatOpt           = getopt.EnumLong("keyid-format", 0, []string{"long"}, "long", "select  how  to  display key IDs.", "{long}")
+	tsaOpt                 = getopt.StringLong("timestamp-authority", 't', defaultTSA, "URL of RFC3161 timestamp authority to use for timestamping", "url")
+	allowedSignersOpt      = getopt.StringLong("allowed-signers", 0, os.Getenv("SMIMESIGN_ALLOWED_SIGNERS"), "only accept signatures from signers allowed by this file. Defaults to $SMIMESIGN_ALLOWED_SIGNERS", "file")
+	committerCheckOpt      = getopt.EnumLong("committer-check", 0, []string{"none", "warn", "require"}, "warn", "check that the signer certificate matches the committer or tagger email of a git payload", "{none|warn|require}")
+	algorithmPolicyOpt     = getopt.StringLong("algorithm-policy", 0, os.Getenv("SMIMESIGN_ALGORITHM_POLICY"), "override the algorithms accepted for verification, e.g. \"digests=sha256,sha384,sha512 min-rsa-bits=2048 curves=P-256,P-384,P-521\". Defaults to $SMIMESIGN_ALGORITHM_POLICY", "policy")
+	ekusOpt                = getopt.StringLong("ekus", 0, "emailProtection,codeSigning", "accept signer certificates with any of these extended key usages, or \"any\"", "eku,...")
+	keyUsagesOpt           = getopt.StringLong("key-usages", 0, "digitalSignature,nonRepudiation", "accept signer certificates with any of these key usages. Empty to accept any", "usage,...")
+	requireSignersOpt      = getopt.StringLong("require-signers", 0, "all", "how many distinct signers of a signature must be valid and allowed: \"all\", \"any\" or a number", "{all|any|n}")
+	trustAnchorsOpt        = getopt.ListLong("trust-anchors", 0, "trust root certificates from these PEM files or directories", "path,...")
+	intermediatesOpt       = getopt.ListLong("intermediates", 0, "use intermediate certificates from these PEM files or directories for verification", "path,...")
+	noSystemRootsFlag      = getopt.BoolLong("no-system-roots", 0, "don't trust the system's root certificates")
+	trustLocalCertsFlag    = getopt.BoolLong("trust-local-certs", 0, "trust the certificates of local signing identities as roots")
+	fetchIntermediatesFlag = getopt.BoolLong("fetch-intermediates", 0, "download missing intermediate certificates from the issuer URLs in signer certificates")
+	checkCRLsFlag          = getopt.BoolLong("check-crls", 0, "check signer certificates against the CRLs in their CRL distribution points")
+	checkOCSPOpt           = getopt.EnumLong("check-ocsp", 0, []string{"none", "soft", "hard"}, "none", "check signer certificates with the OCSP responders in their AIA extension. soft only warns if a responder can't be reached", "{none|soft|hard}")
+	cacheDirOpt            = getopt.StringLong("cache-dir", 0, "", "directory for caching downloaded revocation information and certificates", "dir")
+	verboseFlag            = getopt.BoolLong("verbose", 0, "log what smimesign is doing")
+	debugLevelOpt          = getopt.StringLong("debug-level", 0, "", "log debugging information at this level", "{none|basic|advanced|expert|guru|n}")
+	loggerFdOpt            = getopt.IntLong("logger-fd", 0, -1, "write log output to the file descriptor n instead of stderr", "n")
+	logFileOpt             = getopt.StringLong("log-file", 0, "", "append log output to this file instead of stderr", "file")
+	auditLogOpt            = getopt.StringLong("audit-log", 0, os.Getenv("SMIMESIGN_AUDIT_LOG"), "append a JSON record of each signature made to this file. Defaults to $SMIMESIGN_AUDIT_LOG", "file")
+	includeCertsOpt        = getopt.IntLong("include-certs", 0, -2, "-3 is the same as -2, but ommits issuer when cert has Authority Information Access extension. -2 includes all certs except root. -1 includes all certs. 0 includes no certs. 1 includes leaf cert. >1 includes n from the leaf. Default -2.", "n")
 
 	// Remaining arguments
 	fileArgs []string
@@ -72,7 +101,13 @@ func runCommand() error {
 		return nil
 	}
 
+	if err := setupLog(); err != nil {
+		return err
+	}
+	debugf(debugBasic, "smimesign %s", versionString)
+
 	// Open certificate store
+	logf("opening certificate store")
 	store, err := certstore.Open()
 	if err != nil {
 		return errors.Wrap(err, "failed to open certificate store")
@@ -87,11 +122,14 @@ func runCommand() error {
 	for _, ident := range idents {
 		defer ident.Close()
 	}
+	logf("found %d identities in certificate store", len(idents))
+
+	if countTrue(*signFlag, *verifyFlag, *listKeysFlag, *addTSFlag, *verifyCommitsFlag, *verifyAuditLogFlag, *encryptFlag, *decryptFlag) != 1 {
+		return errActions
+	}
 
 	if *signFlag {
-		if *verifyFlag || *listKeysFlag {
-			return errors.New("specify --help, --sign, --verify, or --list-keys")
-		} else if len(*localUserOpt) == 0 {
+		if len(*localUserOpt) == 0 {
 			return errors.New("specify a USER-ID to sign with")
 		} else {
 			return commandSign()
@@ -99,23 +137,23 @@ func runCommand() error {
 	}
 
 	if *verifyFlag {
-		if *signFlag || *listKeysFlag {
-			return errors.New("specify --help, --sign, --verify, or --list-keys")
-		} else if len(*localUserOpt) > 0 {
+		if len(*localUserOpt) > 0 {
 			return errors.New("local-user cannot be specified for verification")
 		} else if *detachSignFlag {
 			return errors.New("detach-sign cannot be specified for verification")
 		} else if *armorFlag {
 			return errors.New("armor cannot be specified for verification")
+		} else if *rsaPSSFlag {
+			return errors.New("rsa-pss cannot be specified for verification")
+		} else if len(*digestAlgoOpt) > 0 {
+			return errors.New("digest-algo cannot be specified for verification")
 		} else {
 			return commandVerify()
 		}
 	}
 
 	if *listKeysFlag {
-		if *signFlag || *verifyFlag {
-			return errors.New("specify --help, --sign, --verify, or --list-keys")
-		} else if len(*localUserOpt) > 0 {
+		if len(*localUserOpt) > 0 {
 			return errors.New("local-user cannot be specified for list-keys")
 		} else if *detachSignFlag {
 			return errors.New("detach-sign cannot be specified for list-keys")
@@ -126,5 +164,78 @@ func runCommand() error {
 		}
 	}
 
-	return errors.New("specify --help, --sign, --verify, or --list-keys")
+	if *addTSFlag {
+		if len(*tsaOpt) == 0 {
+			return errors.New("specify a timestamp-authority to add a timestamp with")
+		} else if len(*localUserOpt) > 0 {
+			return errors.New("local-user cannot be specified for add-timestamp")
+		} else if *detachSignFlag {
+			return errors.New("detach-sign cannot be specified for add-timestamp")
+		} else {
+			return commandTimestamp()
+		}
+	}
+
+	if *verifyCommitsFlag {
+		if len(fileArgs) == 0 {
+			return errors.New("specify a rev-range to verify")
+		} else if len(*localUserOpt) > 0 {
+			return errors.New("local-user cannot be specified for verify-commits")
+		} else if *detachSignFlag {
+			return errors.New("detach-sign cannot be specified for verify-commits")
+		} else if *armorFlag {
+			return errors.New("armor cannot be specified for verify-commits")
+		} else {
+			return commandVerifyCommits()
+		}
+	}
+
+	if *verifyAuditLogFlag {
+		if len(*auditLogOpt) == 0 {
+			return errors.New("specify an audit-log to verify")
+		} else if len(fileArgs) == 0 {
+			return errors.New("specify signature files to check against the audit log")
+		} else if len(*localUserOpt) > 0 {
+			return errors.New("local-user cannot be specified for verify-audit-log")
+		} else {
+			return commandVerifyAuditLog()
+		}
+	}
+
+	if *encryptFlag {
+		if len(*localUserOpt) > 0 {
+			return errors.New("local-user cannot be specified for encrypt")
+		} else if *detachSignFlag {
+			return errors.New("detach-sign cannot be specified for encrypt")
+		} else {
+			return commandEncrypt()
+		}
+	}
+
+	if *decryptFlag {
+		if len(*localUserOpt) > 0 {
+			return errors.New("local-user cannot be specified for decrypt")
+		} else if *detachSignFlag {
+			return errors.New("detach-sign cannot be specified for decrypt")
+		} else if *armorFlag {
+			return errors.New("armor cannot be specified for decrypt")
+		} else {
+			return commandDecrypt()
+		}
+	}
+
+	return errActions
+}
+
+var errActions = errors.New("specify --help, --sign, --verify, --list-keys, --add-timestamp, --verify-commits, --verify-audit-log, --encrypt, or --decrypt")
+
+// countTrue counts how many of the given flags are set.
+func countTrue(flags ...bool) int {
+	var n int
+	for _, f := range flags {
+		if f {
+			n++
+		}
+	}
+	return n
 }
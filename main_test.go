@@ -3,8 +3,12 @@ package main
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"crypto/x509"
 	"errors"
+	"io"
 	"os"
 	"testing"
 
@@ -40,12 +44,42 @@ func (i identity) Signer() (crypto.Signer, error) {
 	return i.PrivateKey, nil
 }
 
+func (i identity) Decrypter() (crypto.Decrypter, error) {
+	switch k := i.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return ecDecrypter{k}, nil
+	default:
+		return nil, errors.New("unsupported key type")
+	}
+}
+
 func (i identity) Delete() error {
 	return errors.New("not implemented")
 }
 
 func (i identity) Close() {}
 
+// ecDecrypter makes an *ecdsa.PrivateKey implement certstore.KeyAgreer, like
+// the EC identities from the system stores.
+type ecDecrypter struct {
+	*ecdsa.PrivateKey
+}
+
+func (d ecDecrypter) Decrypt(_ io.Reader, _ []byte, _ crypto.DecrypterOpts) ([]byte, error) {
+	return nil, errors.New("EC keys can't decrypt")
+}
+
+func (d ecDecrypter) ECDH(remote *ecdh.PublicKey) ([]byte, error) {
+	priv, err := d.PrivateKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+
+	return priv.ECDH(remote)
+}
+
 func TestMain(m *testing.M) {
 	resetIO()
 	os.Exit(m.Run())
@@ -95,6 +129,7 @@ func testSetup(t *testing.T, args ...string) func() {
 	}
 
 	getopt.CommandLine.Parse(append([]string{"smimesign"}, args...))
+	fileArgs = getopt.Args()
 
 	idents = []certstore.Identity{
 		wrappedLeaf,
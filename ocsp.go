@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	contentTypeOCSPRequest  = "application/ocsp-request"
+	contentTypeOCSPResponse = "application/ocsp-response"
+
+	// maxOCSPResponseSize is the largest OCSP response we're willing to
+	// download.
+	maxOCSPResponseSize = 1 << 20
+
+	ocspNonceBytes = 16
+)
+
+var oidOCSPNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+// checkOCSP checks each certificate in chain, except for the root, with the
+// OCSP responders listed in its AIA extension. A certificate revoked before the
+// signature was made at signingTime causes a revokedError to be returned.
+// Certificates without OCSP responders aren't checked. In soft-fail mode,
+// failures to get a valid response only produce a warning, and
+// errRevocationUnknown is returned once the rest of the chain is checked.
+func checkOCSP(chain []*x509.Certificate, signingTime time.Time) error {
+	var unknown bool
+
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		if len(cert.OCSPServer) == 0 {
+			continue
+		}
+
+		resp, err := getOCSPResponse(cert, issuer)
+		if err == nil && resp.Status == ocsp.Unknown {
+			err = errors.New("responder doesn't know certificate")
+		}
+		if err != nil {
+			if *checkOCSPOpt == "soft" {
+				fmt.Fprintf(stderr, "smimesign: WARNING: failed to check OCSP status of \"%s\": %s\n", cert.Subject.String(), err)
+				unknown = true
+				continue
+			}
+
+			return errors.Wrapf(err, "failed to check OCSP status of \"%s\"", cert.Subject.String())
+		}
+
+		if resp.Status == ocsp.Revoked && !signingTime.Before(resp.RevokedAt) {
+			return revokedError{cert, resp.RevokedAt}
+		}
+	}
+
+	if unknown {
+		return errRevocationUnknown
+	}
+
+	return nil
+}
+
+// getOCSPResponse gets the OCSP response for cert, using the cached response if
+// it is still current.
+func getOCSPResponse(cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	cachePath := ocspCachePath(cert, issuer)
+
+	if der, err := ioutil.ReadFile(cachePath); err == nil {
+		if resp, err := parseOCSPResponse(der, cert, issuer); err == nil && !resp.NextUpdate.IsZero() {
+			debugf(debugAdvanced, "using cached OCSP response from %s", cachePath)
+			return resp, nil
+		}
+	}
+
+	req, nonce, err := ocspRequest(cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, url := range cert.OCSPServer {
+		logf("requesting OCSP status of \"%s\" from %s", cert.Subject.String(), url)
+		der, err := fetchOCSPResponse(url, req)
+		if err != nil {
+			debugf(debugBasic, "OCSP request to %s failed: %s", url, err)
+			lastErr = err
+			continue
+		}
+
+		resp, err := parseOCSPResponse(der, cert, issuer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if err = checkOCSPNonce(resp, nonce); err != nil {
+			lastErr = err
+			continue
+		}
+
+		// Caching is best-effort. Responses without a nextUpdate time aren't
+		// cached, since we can't tell when they go stale.
+		if !resp.NextUpdate.IsZero() {
+			if err = os.MkdirAll(filepath.Dir(cachePath), 0700); err == nil {
+				ioutil.WriteFile(cachePath, der, 0600)
+			}
+		}
+
+		return resp, nil
+	}
+
+	return nil, lastErr
+}
+
+// parseOCSPResponse parses an OCSP response for cert and validates it. The
+// response must be signed by issuer or by a responder certificate issued by
+// issuer for OCSP signing. The response must also be current.
+func parseOCSPResponse(der []byte, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	// ParseResponseForCert checks that a delegated responder certificate was
+	// issued by the issuer, but not that it was issued for OCSP signing.
+	if resp.Certificate != nil && !resp.Certificate.Equal(issuer) {
+		responder := resp.Certificate
+
+		var ocspSigning bool
+		for _, eku := range responder.ExtKeyUsage {
+			if eku == x509.ExtKeyUsageOCSPSigning {
+				ocspSigning = true
+			}
+		}
+		if !ocspSigning {
+			return nil, errors.New("OCSP responder certificate isn't authorized for OCSP signing")
+		}
+
+		if now := time.Now(); now.Before(responder.NotBefore) || now.After(responder.NotAfter) {
+			return nil, errors.New("OCSP responder certificate is expired or not yet valid")
+		}
+	}
+
+	now := time.Now()
+	if resp.ThisUpdate.After(now.Add(5 * time.Minute)) {
+		return nil, errors.New("OCSP response is from the future")
+	}
+	if !resp.NextUpdate.IsZero() && now.After(resp.NextUpdate) {
+		return nil, errors.New("OCSP response is stale")
+	}
+
+	return resp, nil
+}
+
+// ocspTBSResponseData is the part of ResponseData that x/crypto/ocsp doesn't
+// expose.
+//
+//	ResponseData ::= SEQUENCE {
+//		version              [0] EXPLICIT Version DEFAULT v1,
+//		responderID              ResponderID,
+//		producedAt               GeneralizedTime,
+//		responses                SEQUENCE OF SingleResponse,
+//		responseExtensions   [1] EXPLICIT Extensions OPTIONAL }
+type ocspTBSResponseData struct {
+	Version            int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID        asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          asn1.RawValue
+	ResponseExtensions []pkix.Extension `asn1:"optional,explicit,tag:1"`
+}
+
+// checkOCSPNonce checks that the nonce in the response, if there is one,
+// matches the one from our request. Many responders serve pre-generated
+// responses without nonces, so a missing nonce is allowed.
+func checkOCSPNonce(resp *ocsp.Response, nonce []byte) error {
+	var data ocspTBSResponseData
+	if _, err := asn1.Unmarshal(resp.TBSResponseData, &data); err != nil {
+		return err
+	}
+
+	exts := append(data.ResponseExtensions, resp.Extensions...)
+	for _, ext := range exts {
+		if ext.Id.Equal(oidOCSPNonce) && !bytes.Equal(ext.Value, nonce) {
+			return errors.New("OCSP response nonce doesn't match request")
+		}
+	}
+
+	return nil
+}
+
+// ocspRequestASN1 is an OCSPRequest with the fields we need.
+//
+//	OCSPRequest ::= SEQUENCE {
+//		tbsRequest                  TBSRequest,
+//		optionalSignature   [0]     EXPLICIT Signature OPTIONAL }
+//
+//	TBSRequest ::= SEQUENCE {
+//		version             [0]     EXPLICIT Version DEFAULT v1,
+//		requestorName       [1]     EXPLICIT GeneralName OPTIONAL,
+//		requestList                 SEQUENCE OF Request,
+//		requestExtensions   [2]     EXPLICIT Extensions OPTIONAL }
+//
+//	Request ::= SEQUENCE {
+//		reqCert                     CertID,
+//		singleRequestExtensions     [0] EXPLICIT Extensions OPTIONAL }
+//
+//	CertID ::= SEQUENCE {
+//		hashAlgorithm       AlgorithmIdentifier,
+//		issuerNameHash      OCTET STRING,
+//		issuerKeyHash       OCTET STRING,
+//		serialNumber        CertificateSerialNumber }
+type ocspRequestASN1 struct {
+	TBSRequest struct {
+		RequestList []struct {
+			ReqCert struct {
+				HashAlgorithm  pkix.AlgorithmIdentifier
+				IssuerNameHash []byte
+				IssuerKeyHash  []byte
+				SerialNumber   *big.Int
+			}
+		}
+		RequestExtensions []pkix.Extension `asn1:"optional,explicit,tag:2"`
+	}
+}
+
+// ocspRequest creates a DER encoded OCSP request with a random nonce for cert.
+// The DER encoded nonce extension value is also returned.
+func ocspRequest(cert, issuer *x509.Certificate) ([]byte, []byte, error) {
+	// x/crypto/ocsp can't add request extensions, so we reuse its CertID and add
+	// the nonce ourselves.
+	simple, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var req ocspRequestASN1
+	if rest, err := asn1.Unmarshal(simple, &req); err != nil {
+		return nil, nil, err
+	} else if len(rest) > 0 {
+		return nil, nil, errors.New("trailing data in OCSP request")
+	}
+
+	buf := make([]byte, ocspNonceBytes)
+	if _, err = rand.Read(buf); err != nil {
+		return nil, nil, err
+	}
+
+	nonce, err := asn1.Marshal(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.TBSRequest.RequestExtensions = []pkix.Extension{{Id: oidOCSPNonce, Value: nonce}}
+
+	der, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return der, nonce, nil
+}
+
+// fetchOCSPResponse POSTs an OCSP request to the responder at url.
+func fetchOCSPResponse(url string, req []byte) ([]byte, error) {
+	resp, err := revocationHTTPClient.Post(url, contentTypeOCSPRequest, bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad HTTP status: %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != contentTypeOCSPResponse {
+		return nil, fmt.Errorf("bad content-type: %s", ct)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err = io.Copy(buf, io.LimitReader(resp.Body, maxOCSPResponseSize+1)); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxOCSPResponseSize {
+		return nil, errors.New("OCSP response too large")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ocspCachePath gets the path at which the OCSP response for cert is cached.
+func ocspCachePath(cert, issuer *x509.Certificate) string {
+	h := sha256.New()
+	h.Write(issuer.RawSubjectPublicKeyInfo)
+	h.Write(cert.SerialNumber.Bytes())
+	return filepath.Join(cacheDir(), "ocsp", hex.EncodeToString(h.Sum(nil)))
+}
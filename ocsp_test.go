@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/github/smimesign/fakeca"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+var ocspLeaf = crlCA.Issue(fakeca.OCSPServer("http://ocsp.example"))
+
+// serveOCSP makes revocationHTTPClient respond to OCSP requests for ocspLeaf
+// with a response signed by responder. The response echoes the request's nonce
+// if echoNonce is set. The returned function restores the original client.
+func serveOCSP(t *testing.T, responder *fakeca.Identity, status int, revokedAt time.Time, echoNonce bool) func() {
+	t.Helper()
+
+	orig := revocationHTTPClient
+	revocationHTTPClient = &http.Client{Transport: roundTripper(func(req *http.Request) (*http.Response, error) {
+		body, err := ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		var ocspReq ocspRequestASN1
+		_, err = asn1.Unmarshal(body, &ocspReq)
+		require.NoError(t, err)
+
+		template := ocsp.Response{
+			Status:       status,
+			SerialNumber: ocspLeaf.Certificate.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Hour),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    revokedAt,
+		}
+		if !responder.Certificate.Equal(crlCA.Certificate) {
+			template.Certificate = responder.Certificate
+		}
+		if echoNonce {
+			template.ExtraExtensions = ocspReq.TBSRequest.RequestExtensions
+		} else {
+			template.ExtraExtensions = []pkix.Extension{{Id: oidOCSPNonce, Value: []byte{4, 1, 0}}}
+		}
+
+		der, err := ocsp.CreateResponse(crlCA.Certificate, responder.Certificate, template, responder.PrivateKey)
+		require.NoError(t, err)
+
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": []string{contentTypeOCSPResponse}},
+			Body:       ioutil.NopCloser(bytes.NewReader(der)),
+		}, nil
+	})}
+
+	return func() { revocationHTTPClient = orig }
+}
+
+func TestCheckOCSP(t *testing.T) {
+	var (
+		chain     = ocspLeaf.Chain()
+		revokedAt = time.Now().Add(-time.Minute).Truncate(time.Second)
+	)
+
+	func() {
+		defer testCacheDir(t, "--verify", "--check-ocsp", "hard")()
+		defer serveOCSP(t, crlCA, ocsp.Good, time.Time{}, true)()
+
+		require.NoError(t, checkOCSP(chain, time.Now()))
+	}()
+
+	func() {
+		defer testCacheDir(t, "--verify", "--check-ocsp", "hard")()
+		defer serveOCSP(t, crlCA, ocsp.Revoked, revokedAt, true)()
+
+		err := checkOCSP(chain, time.Now())
+		require.True(t, isRevoked(err), err)
+
+		// Signatures made before the revocation are still good.
+		require.NoError(t, checkOCSP(chain, revokedAt.Add(-time.Minute)))
+	}()
+
+	// Responses with the wrong nonce are rejected.
+	func() {
+		defer testCacheDir(t, "--verify", "--check-ocsp", "hard")()
+		defer serveOCSP(t, crlCA, ocsp.Revoked, revokedAt, false)()
+
+		err := checkOCSP(chain, time.Now())
+		require.Error(t, err)
+		require.False(t, isRevoked(err))
+	}()
+
+	// Unknown certificates fail in hard mode, but not in soft mode.
+	func() {
+		defer testCacheDir(t, "--verify", "--check-ocsp", "hard")()
+		defer serveOCSP(t, crlCA, ocsp.Unknown, time.Time{}, true)()
+
+		require.Error(t, checkOCSP(chain, time.Now()))
+	}()
+
+	func() {
+		defer testCacheDir(t, "--verify", "--check-ocsp", "soft")()
+		defer serveOCSP(t, crlCA, ocsp.Unknown, time.Time{}, true)()
+
+		require.Equal(t, errRevocationUnknown, checkOCSP(chain, time.Now()))
+		require.Contains(t, stderrBuf.String(), "WARNING")
+	}()
+}
+
+func TestCheckOCSPDelegatedResponder(t *testing.T) {
+	var (
+		ocspSigningEKU = fakeca.ExtKeyUsage(x509.ExtKeyUsageOCSPSigning)
+		authorized     = crlCA.Issue(ocspSigningEKU)
+		unauthorized   = crlCA.Issue()
+		otherCA        = fakeca.New(fakeca.IsCA)
+		foreign        = otherCA.Issue(ocspSigningEKU)
+	)
+
+	func() {
+		defer testCacheDir(t, "--verify", "--check-ocsp", "hard")()
+		defer serveOCSP(t, authorized, ocsp.Revoked, time.Now().Add(-time.Minute), true)()
+
+		require.True(t, isRevoked(checkOCSP(ocspLeaf.Chain(), time.Now())))
+	}()
+
+	func() {
+		defer testCacheDir(t, "--verify", "--check-ocsp", "hard")()
+		defer serveOCSP(t, unauthorized, ocsp.Revoked, time.Now().Add(-time.Minute), true)()
+
+		err := checkOCSP(ocspLeaf.Chain(), time.Now())
+		require.Error(t, err)
+		require.False(t, isRevoked(err))
+	}()
+
+	func() {
+		defer testCacheDir(t, "--verify", "--check-ocsp", "hard")()
+		defer serveOCSP(t, foreign, ocsp.Revoked, time.Now().Add(-time.Minute), true)()
+
+		err := checkOCSP(ocspLeaf.Chain(), time.Now())
+		require.Error(t, err)
+		require.False(t, isRevoked(err))
+	}()
+}
+
+func TestCheckOCSPCache(t *testing.T) {
+	defer testCacheDir(t, "--verify", "--check-ocsp", "hard")()
+
+	func() {
+		defer serveOCSP(t, crlCA, ocsp.Revoked, time.Now().Add(-time.Minute), true)()
+		require.True(t, isRevoked(checkOCSP(ocspLeaf.Chain(), time.Now())))
+	}()
+
+	// The cached response is used while offline.
+	func() {
+		defer serveRevocation(t, nil)()
+		require.True(t, isRevoked(checkOCSP(ocspLeaf.Chain(), time.Now())))
+	}()
+
+	// Certificates without OCSP responders aren't checked.
+	require.NoError(t, checkOCSP(leaf.Chain(), time.Now()))
+}
+
+func TestCheckOCSPSoftFailOffline(t *testing.T) {
+	func() {
+		defer testCacheDir(t, "--verify", "--check-ocsp", "hard")()
+		defer serveRevocation(t, nil)()
+
+		require.Error(t, checkOCSP(ocspLeaf.Chain(), time.Now()))
+	}()
+
+	func() {
+		defer testCacheDir(t, "--verify", "--check-ocsp", "soft")()
+		defer serveRevocation(t, nil)()
+
+		require.Equal(t, errRevocationUnknown, checkOCSP(ocspLeaf.Chain(), time.Now()))
+		require.Contains(t, stderrBuf.String(), "failed to check OCSP status")
+	}()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxPayloadHeader is how much of a detached message is kept for finding the
+// committer or tagger of git payloads. Commit and tag headers come first and
+// are much smaller than this.
+const maxPayloadHeader = 1 << 20
+
+// prefixBuffer is an io.Writer that keeps the first max bytes written to it,
+// discarding the rest.
+type prefixBuffer struct {
+	buf bytes.Buffer
+	max int
+}
+
+// Write implements io.Writer.
+func (pb *prefixBuffer) Write(p []byte) (int, error) {
+	if room := pb.max - pb.buf.Len(); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		pb.buf.Write(p[:room])
+	}
+
+	return len(p), nil
+}
+
+// Bytes gets the bytes that were kept.
+func (pb *prefixBuffer) Bytes() []byte {
+	return pb.buf.Bytes()
+}
+
+var errEmailMismatch = errors.New("signer certificate doesn't match committer or tagger email")
+
+// payloadEmail extracts the committer email from a commit or the tagger email
+// from a tag. An empty string is returned if the payload doesn't look like
+// either, so signatures over arbitrary data aren't affected.
+func payloadEmail(payload []byte) string {
+	for _, line := range bytes.Split(payload, []byte("\n")) {
+		// Headers end at the first empty line.
+		if len(line) == 0 {
+			break
+		}
+
+		var ident string
+		if bytes.HasPrefix(line, []byte("committer ")) {
+			ident = string(line[len("committer "):])
+		} else if bytes.HasPrefix(line, []byte("tagger ")) {
+			ident = string(line[len("tagger "):])
+		} else {
+			continue
+		}
+
+		// Name <email> timestamp tz
+		start := strings.IndexByte(ident, '<')
+		end := strings.LastIndexByte(ident, '>')
+		if start < 0 || end < start {
+			return ""
+		}
+
+		return strings.TrimSpace(ident[start+1 : end])
+	}
+
+	return ""
+}
+
+// checkSigner applies the --committer-check and --allowed-signers policies to
+// the signer of a verified payload. When the payload is a commit or tag, the
+// allowed-signers file is checked against the committer or tagger email
+// rather than the emails in the signer's certificate. Validity windows in the
+// allowed-signers file are checked at signingTime, the verified timestamp of
+// the signature, or at the current time if it has none.
+func checkSigner(payload []byte, chains [][]*x509.Certificate, allowed allowedSigners, signingTime time.Time) error {
+	var (
+		cert   = chains[0][0]
+		emails = certEmails(cert)
+	)
+
+	if email := payloadEmail(payload); len(email) > 0 {
+		if !containsEmail(emails, email) {
+			switch *committerCheckOpt {
+			case "require":
+				return errEmailMismatch
+			case "warn":
+				fmt.Fprintf(stderr, "smimesign: WARNING: certificate \"%s\" doesn't match email <%s>\n", cert.Subject.String(), email)
+			}
+		}
+
+		emails = []string{email}
+	}
+
+	return allowed.check(emails, chains, signingTime)
+}
+
+// containsEmail checks if emails contains email, ignoring case.
+func containsEmail(emails []string, email string) bool {
+	for _, other := range emails {
+		if strings.EqualFold(other, email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// trustNeverToken gets the TRUST_NEVER error token for an error returned by
+// checkSigner.
+func trustNeverToken(err error) string {
+	if err == errEmailMismatch {
+		return "email_mismatch"
+	}
+
+	return "not_allowed"
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayloadEmail(t *testing.T) {
+	require.Equal(t, "bob@example.com", payloadEmail([]byte("tree abc\nauthor Alice <alice@example.com> 1500000000 +0000\ncommitter Bob <bob@example.com> 1500000000 +0000\n\nmessage\n")))
+	require.Equal(t, "carol@example.com", payloadEmail([]byte("object abc\ntype commit\ntag v1\ntagger Carol <carol@example.com> 1500000000 +0000\n\nv1\n")))
+	require.Equal(t, "", payloadEmail([]byte("tree abc\n\ncommitter Bob <bob@example.com> 1500000000 +0000\n")))
+	require.Equal(t, "", payloadEmail([]byte("hello, world!")))
+}
+
+func TestCheckSigner(t *testing.T) {
+	var (
+		chains = [][]*x509.Certificate{aliceLeaf.Chain()}
+		alice  = []byte("tree abc\ncommitter Alice <Alice@example.com> 1500000000 +0000\n\nmessage\n")
+		bob    = []byte("tree abc\ncommitter Bob <bob@example.com> 1500000000 +0000\n\nmessage\n")
+	)
+
+	func() {
+		defer testSetup(t, "--verify", "--committer-check=require")()
+
+		require.NoError(t, checkSigner(alice, chains, nil, time.Time{}))
+		require.NoError(t, checkSigner([]byte("hello, world!"), chains, nil, time.Time{}))
+		require.Equal(t, errEmailMismatch, checkSigner(bob, chains, nil, time.Time{}))
+	}()
+
+	func() {
+		defer testSetup(t, "--verify", "--committer-check=warn")()
+
+		require.NoError(t, checkSigner(bob, chains, nil, time.Time{}))
+		require.Contains(t, stderrBuf.String(), "<bob@example.com>")
+	}()
+
+	func() {
+		defer testSetup(t, "--verify", "--committer-check=none")()
+
+		// The allowed-signers file is checked against the committer's email.
+		as, err := parseAllowedSigners(strings.NewReader("alice@example.com subject=*"))
+		require.NoError(t, err)
+		require.NoError(t, checkSigner(alice, chains, as, time.Time{}))
+		require.Equal(t, errNotAllowed, checkSigner(bob, chains, as, time.Time{}))
+		require.Empty(t, stderrBuf.String())
+	}()
+}
+
+func TestPrefixBuffer(t *testing.T) {
+	pb := &prefixBuffer{max: 8}
+
+	for _, chunk := range []string{"hello", ", world", "!"} {
+		n, err := pb.Write([]byte(chunk))
+		require.NoError(t, err)
+		require.Equal(t, len(chunk), n)
+	}
+
+	require.Equal(t, "hello, w", string(pb.Bytes()))
+}
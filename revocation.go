@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/pkg/errors"
+)
+
+// revocationHTTPClient is used for fetching revocation information. It is
+// changed in tests.
+var revocationHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// revokedError is returned when a certificate has been revoked.
+type revokedError struct {
+	cert *x509.Certificate
+	at   time.Time
+}
+
+// Error implements the error interface.
+func (err revokedError) Error() string {
+	return fmt.Sprintf("certificate \"%s\" was revoked at %s", err.cert.Subject.String(), err.at.Format(time.RFC3339))
+}
+
+// errRevocationUnknown is returned when the revocation status of a certificate
+// couldn't be determined, but revocation checking is soft-fail.
+var errRevocationUnknown = errors.New("revocation status unknown")
+
+// isRevoked checks if an error indicates that a certificate was revoked.
+func isRevoked(err error) bool {
+	_, ok := errors.Cause(err).(revokedError)
+	return ok
+}
+
+// checkSignerRevocation checks the revocation status of the certificate chain
+// of the i'th signer, if revocation checking is enabled. Revocation is
+// evaluated at the time of the signer's timestamp, or at the current time if
+// the signer has no timestamp.
+func checkSignerRevocation(sd *cms.SignedData, i int, chains [][]*x509.Certificate, opts x509.VerifyOptions) error {
+	if !*checkCRLsFlag && *checkOCSPOpt == "none" {
+		return nil
+	}
+
+	signingTime, err := sd.SignerTimestamp(i, opts)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify timestamp")
+	}
+	if signingTime.IsZero() {
+		signingTime = time.Now()
+	}
+
+	var unknown bool
+
+	if *checkCRLsFlag {
+		if err = checkCRLs(chains[0], signingTime); err == errRevocationUnknown {
+			unknown = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if *checkOCSPOpt != "none" {
+		if err = checkOCSP(chains[0], signingTime); err != nil {
+			return err
+		}
+	}
+
+	if unknown {
+		return errRevocationUnknown
+	}
+
+	return nil
+}
+
+// derFromMaybePEM returns the contents of the first PEM block in data, or data
+// itself if it isn't PEM encoded.
+func derFromMaybePEM(data []byte) []byte {
+	if blk, _ := pem.Decode(data); blk != nil {
+		return blk.Bytes
+	}
+
+	return data
+}
+
+// cacheDir gets the directory in which downloaded revocation information and
+// certificates are cached.
+func cacheDir() string {
+	if len(*cacheDirOpt) > 0 {
+		return *cacheDirOpt
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+
+	return filepath.Join(dir, "smimesign")
+}
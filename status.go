@@ -2,14 +2,20 @@ package main
 
 import (
 	"crypto"
+	"crypto/sha1"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/openpgp/packet"
-	"golang.org/x/crypto/openpgp/s2k"
 )
 
 // This file implements gnupg's "status protocol". When the --status-fd argument
@@ -25,6 +31,53 @@ const (
 	//   indication that all requested secret keys are ready for use.
 	sBeginSigning status = "BEGIN_SIGNING"
 
+	// KEY_CONSIDERED <fpr> <flags>
+	//   Issued to explain the lookup of a key. FPR is the hexified
+	//   fingerprint of the primary key. The bit values for FLAGS are:
+	//
+	//   - 1 :: The key has been rejected as not usable.
+	//   - 2 :: All subkeys of the key are not usable.
+	sKeyConsidered status = "KEY_CONSIDERED"
+
+	// INV_SGNR <reason> <requested_sender>
+	//   This status line is issued for invalid signing keys. The reason codes
+	//   are:
+	//
+	//   - 0 :: No specific reason given
+	//   - 1 :: Not Found
+	//   - 2 :: Ambiguous specification
+	//   - 3 :: Wrong key usage
+	//   - 4 :: Key revoked
+	//   - 5 :: Key expired
+	//   - 6 :: No CRL known
+	//   - 7 :: CRL too old
+	//   - 8 :: Policy mismatch
+	//   - 9 :: Not a secret key
+	//   - 10 :: Key not trusted
+	//   - 11 :: Missing certificate
+	//   - 12 :: Missing issuer certificate
+	//   - 13 :: Key disabled
+	//   - 14 :: Syntax error in specification
+	sInvSgnr status = "INV_SGNR"
+
+	// NO_SECKEY <keyid>
+	//   The key is not available. The fingerprint may be used instead of the
+	//   keyid if it is available.
+	sNoSecKey status = "NO_SECKEY"
+
+	// PINENTRY_LAUNCHED <pid>:<flavor>:<version>:<tty>:<ttytype>:<display>
+	//   This status line is emitted by gpg to notify a client that a Pinentry
+	//   has been launched. smimesign never launches a Pinentry. Smart cards
+	//   and keychains prompt for PINs through the operating system's own
+	//   dialogs, which smimesign can't observe, so this status isn't emitted.
+
+	// FAILURE <location> <error_code>
+	//   This is the counterpart to SUCCESS and used to indicate a program
+	//   failure. It is used similar to ERROR but error codes are only emitted
+	//   once for the whole operation. LOCATION is the function that failed
+	//   and ERROR_CODE is the libgpg-error value.
+	sFailure status = "FAILURE"
+
 	// SIG_CREATED <type> <pk_algo> <hash_algo> <class> <timestamp> <keyfpr>
 	//   A signature has been created using these parameters.
 	//   Values for type <type> are:
@@ -59,6 +112,39 @@ const (
 	//   also be available for OpenPGP.
 	sGoodSig status = "GOODSIG"
 
+	// SIG_ID <radix64_string> <sig_creation_date> <sig-timestamp>
+	//   This is emitted only for signatures of class 0 or 1 which have been
+	//   verified okay.  The string is a signature id and may be used in
+	//   applications to detect replay attacks of signed messages.  Note that
+	//   only DLP algorithms give unique ids - others may yield duplicated ones
+	//   when they have been created in the same second.
+	//
+	//   Note, that SIG-TIMESTAMP may either be a number of seconds since Epoch
+	//   or an ISO 8601 string which can be detected by the presence of the
+	//   letter 'T'.
+	sSigID status = "SIG_ID"
+
+	// VALIDSIG <args>
+	//   The args are:
+	//
+	//   - <fingerprint_in_hex>
+	//   - <sig_creation_date>
+	//   - <sig-timestamp>
+	//   - <expire-timestamp>
+	//   - <sig-version>
+	//   - <reserved>
+	//   - <pubkey-algo>
+	//   - <hash-algo>
+	//   - <sig-class>
+	//   - [ <primary-key-fpr> ]
+	//
+	//   This status indicates that the signature is cryptographically valid.
+	//   This is similar to GOODSIG, EXPSIG, EXPKEYSIG, or REVKEYSIG but
+	//   GOODSIG, etc. are only emitted for the first signature. For CMS, the
+	//   expire-timestamp is the signer certificate's expiry and the
+	//   primary-key-fpr is the fingerprint of the certificate's issuer.
+	sValidSig status = "VALIDSIG"
+
 	// BADSIG <long_keyid_or_fpr> <username>
 	//   The signature with the keyid has not been verified okay. The username is
 	//   the primary one encoded in UTF-8 and %XX escaped. The fingerprint may be
@@ -66,6 +152,20 @@ const (
 	//   CMS and might eventually also be available for OpenPGP.
 	sBadSig status = "BADSIG"
 
+	// EXPKEYSIG <keyid_or_fpr> <username>
+	//   The signature with the keyid is good, but the signature was made by an
+	//   expired key. The username is the primary one encoded in UTF-8 and %XX
+	//   escaped. The fingerprint may be used instead of the keyid if it is
+	//   available.
+	sExpKeySig status = "EXPKEYSIG"
+
+	// REVKEYSIG <keyid_or_fpr> <username>
+	//   The signature with the keyid is good, but the signature was made by a
+	//   revoked key. The username is the primary one encoded in UTF-8 and %XX
+	//   escaped. The fingerprint may be used instead of the keyid if it is
+	//   available.
+	sRevKeySig status = "REVKEYSIG"
+
 	// ERRSIG <keyid> <pkalgo> <hashalgo> <sig_class> <time> <rc>
 	//
 	//   It was not possible to check the signature. This may be caused by a
@@ -108,6 +208,112 @@ const (
 	//   Note that the term =TRUST_= in the status names is used for
 	//   historic reasons; we now speak of validity.
 	sTrustFully status = "TRUST_FULLY"
+
+	// TRUST_UNDEFINED <error_token>
+	//   See TRUST_ above.
+	sTrustUndefined status = "TRUST_UNDEFINED"
+
+	// TRUST_NEVER <error_token>
+	//   See TRUST_ above.
+	sTrustNever status = "TRUST_NEVER"
+
+	// TRUST_MARGINAL [0 [<validation_model>]]
+	//   See TRUST_ above.
+	sTrustMarginal status = "TRUST_MARGINAL"
+
+	// INV_RECP <reason> <requested_recipient>
+	//   Issued for each unusable recipient. The reasons codes currently in use
+	//   are the same as for INV_SGNR.
+	sInvRecp status = "INV_RECP"
+
+	// BEGIN_ENCRYPTION <mdc_method> <sym_algo>
+	//   Mark the start of the actual encryption process. MDC_METHOD shall be
+	//   0 if an MDC is used. SYM_ALGO is the symmetric algorithm ID as used in
+	//   OpenPGP.
+	sBeginEncryption status = "BEGIN_ENCRYPTION"
+
+	// END_ENCRYPTION
+	//   Mark the end of the actual encryption process.
+	sEndEncryption status = "END_ENCRYPTION"
+
+	// BEGIN_DECRYPTION
+	//   Mark the start of the actual decryption process. These are also
+	//   emitted when in --list-only mode.
+	sBeginDecryption status = "BEGIN_DECRYPTION"
+
+	// END_DECRYPTION
+	//   Mark the end of the actual decryption process.
+	sEndDecryption status = "END_DECRYPTION"
+
+	// DECRYPTION_OKAY
+	//   The decryption process succeeded. This means, that either the
+	//   correct secret key has been used or the correct passphrase for a
+	//   symmetric with message was used.
+	sDecryptionOkay status = "DECRYPTION_OKAY"
+
+	// DECRYPTION_FAILED
+	//   The symmetric decryption failed - one reason could be a wrong
+	//   passphrase for a symmetrical encrypted message.
+	sDecryptionFailed status = "DECRYPTION_FAILED"
+)
+
+// pubKeyAlgoEdDSA is the OpenPGP public key algorithm ID for EdDSA, which the
+// openpgp packet package doesn't define.
+const pubKeyAlgoEdDSA = 22
+
+// hashAlgos maps digest algorithms to their OpenPGP hash algorithm IDs, from
+// RFC 9580. The openpgp s2k package doesn't know the IDs for SHA-224 and
+// SHA-3. SHA3-384 and SHA-512/256 don't have IDs.
+var hashAlgos = map[crypto.Hash]byte{
+	crypto.MD5:       1,
+	crypto.SHA1:      2,
+	crypto.RIPEMD160: 3,
+	crypto.SHA256:    8,
+	crypto.SHA384:    9,
+	crypto.SHA512:    10,
+	crypto.SHA224:    11,
+	crypto.SHA3_256:  12,
+	crypto.SHA3_512:  14,
+}
+
+// ERRSIG return codes.
+const (
+	// errSigGeneral is used for errors that aren't covered by the other codes.
+	errSigGeneral = 1
+
+	// errSigUnsupportedAlgorithm indicates an unknown or disallowed algorithm.
+	errSigUnsupportedAlgorithm = 4
+
+	// errSigMissingKey indicates that the signer's certificate couldn't be
+	// found.
+	errSigMissingKey = 9
+)
+
+// INV_SGNR and INV_RECP reason codes.
+const (
+	invSgnrNoReason      = 0
+	invSgnrNotFound      = 1
+	invSgnrWrongKeyUsage = 3
+	invSgnrNoSecretKey   = 9
+	invSgnrMissingCert   = 11
+	invSgnrSyntaxError   = 14
+)
+
+// libgpg-error codes for FAILURE. These are combined with gpgsm's error source
+// so that clients decode them the same way as gpgsm's errors.
+const (
+	gpgErrSourceGPGSM = 3 << 24
+
+	gpgErrGeneral         = 1
+	gpgErrDigestAlgo      = 5
+	gpgErrNoPubKey        = 9
+	gpgErrNoSecKey        = 17
+	gpgErrNotFound        = 27
+	gpgErrInvUserID       = 37
+	gpgErrWrongPubKeyAlgo = 41
+	gpgErrNoData          = 58
+	gpgErrDecryptFailed   = 152
+	gpgErrMissingCert     = 185
 )
 
 var (
@@ -134,8 +340,10 @@ func setupStatus() {
 		case unixStderr:
 			statusFile = os.Stderr
 		default:
-			// TODO: debugging output if this fails
 			statusFile = os.NewFile(uintptr(*statusFdOpt), "status")
+			if _, err := statusFile.Stat(); err != nil {
+				logf("can't write status output to fd %d: %s", *statusFdOpt, err)
+			}
 		}
 	})
 }
@@ -164,7 +372,10 @@ func (s status) emit() {
 	statusFile.WriteString(prefix + string(s) + "\n")
 }
 
-func emitSigCreated(cert *x509.Certificate, isDetached bool) {
+// emitSigCreated emits SIG_CREATED for the signature si just made with cert.
+// The algorithms are those of the signature itself, not of the certificate's
+// issuer.
+func emitSigCreated(cert *x509.Certificate, si protocol.SignerInfo, isDetached bool) {
 	// SIG_CREATED arguments
 	var (
 		sigType                    string
@@ -179,22 +390,9 @@ func emitSigCreated(cert *x509.Certificate, isDetached bool) {
 		sigType = "S"
 	}
 
-	switch cert.SignatureAlgorithm {
-	case x509.SHA1WithRSA, x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA:
-		pkAlgo = byte(packet.PubKeyAlgoRSA)
-	case x509.ECDSAWithSHA1, x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
-		pkAlgo = byte(packet.PubKeyAlgoECDSA)
-	}
-
-	switch cert.SignatureAlgorithm {
-	case x509.SHA1WithRSA, x509.ECDSAWithSHA1:
-		hashAlgo, _ = s2k.HashToHashId(crypto.SHA1)
-	case x509.SHA256WithRSA, x509.ECDSAWithSHA256:
-		hashAlgo, _ = s2k.HashToHashId(crypto.SHA256)
-	case x509.SHA384WithRSA, x509.ECDSAWithSHA384:
-		hashAlgo, _ = s2k.HashToHashId(crypto.SHA384)
-	case x509.SHA512WithRSA, x509.ECDSAWithSHA512:
-		hashAlgo, _ = s2k.HashToHashId(crypto.SHA512)
+	pkAlgo = pkAlgoForPublicKeyAlgorithm(cert.PublicKeyAlgorithm)
+	if hash, err := si.Hash(); err == nil {
+		hashAlgo = hashAlgos[hash]
 	}
 
 	// gpgsm seems to always use 0x00
@@ -205,22 +403,238 @@ func emitSigCreated(cert *x509.Certificate, isDetached bool) {
 	sSigCreated.emitf("%s %d %d %02x %d %s", sigType, pkAlgo, hashAlgo, sigClass, now, fpr)
 }
 
-func emitGoodSig(chains [][][]*x509.Certificate) {
-	cert := chains[0][0][0]
+func emitGoodSig(cert *x509.Certificate) {
 	subj := cert.Subject.String()
 	fpr := certHexFingerprint(cert)
 
 	sGoodSig.emitf("%s %s", fpr, subj)
 }
 
-func emitBadSig(chains [][][]*x509.Certificate) {
-	cert := chains[0][0][0]
-	subj := cert.Subject.String
+func emitBadSig(cert *x509.Certificate) {
+	subj := cert.Subject.String()
 	fpr := certHexFingerprint(cert)
 
 	sBadSig.emitf("%s %s", fpr, subj)
 }
 
+func emitExpKeySig(cert *x509.Certificate) {
+	subj := cert.Subject.String()
+	fpr := certHexFingerprint(cert)
+
+	sExpKeySig.emitf("%s %s", fpr, subj)
+}
+
+func emitRevKeySig(cert *x509.Certificate) {
+	subj := cert.Subject.String()
+	fpr := certHexFingerprint(cert)
+
+	sRevKeySig.emitf("%s %s", fpr, subj)
+}
+
+// emitSigID emits SIG_ID for a good signature made at signingTime. The ID is
+// derived from the signature value and signing time.
+func emitSigID(si protocol.SignerInfo, signingTime time.Time) {
+	date, ts := sigTimeFields(signingTime)
+
+	h := sha1.New()
+	h.Write(si.Signature)
+	fmt.Fprintf(h, "%d", ts)
+	id := base64.RawStdEncoding.EncodeToString(h.Sum(nil))
+
+	sSigID.emitf("%s %s %d", id, date, ts)
+}
+
+// sigTimeFields gets the creation date and timestamp fields of SIG_ID and
+// VALIDSIG for a signature made at signingTime. The zero time means that the
+// signature has no known time. Its timestamp is 0, as in ERRSIG, and its date
+// is the placeholder GnuPG uses for dates it can't show.
+func sigTimeFields(signingTime time.Time) (string, int64) {
+	if signingTime.IsZero() {
+		return "????-??-??", 0
+	}
+
+	return signingTime.UTC().Format("2006-01-02"), signingTime.Unix()
+}
+
+// emitValidSig emits VALIDSIG for a good signature made by cert at signingTime.
+// The issuer's fingerprint is given as the primary key fingerprint, or cert's
+// own if the issuer isn't known.
+func emitValidSig(cert, issuer *x509.Certificate, si protocol.SignerInfo, signingTime time.Time) {
+	var (
+		fpr        = certHexFingerprint(cert)
+		primaryFpr = fpr
+		pkAlgo     = pkAlgoForPublicKeyAlgorithm(cert.PublicKeyAlgorithm)
+		hashAlgo   byte
+	)
+
+	if issuer != nil {
+		primaryFpr = certHexFingerprint(issuer)
+	}
+
+	if hash, err := si.Hash(); err == nil {
+		hashAlgo = hashAlgos[hash]
+	}
+
+	date, ts := sigTimeFields(signingTime)
+
+	// gpgsm seems to always use 0 for the version and 0x00 for the class
+	sValidSig.emitf("%s %s %d %d %d %d %d %d %02x %s",
+		fpr,
+		date,
+		ts,
+		cert.NotAfter.Unix(),
+		0, 0,
+		pkAlgo, hashAlgo, 0,
+		primaryFpr,
+	)
+}
+
+// emitErrSig emits ERRSIG for the i'th SignerInfo in sd, whose signature
+// couldn't be checked because of err. The key ID, algorithms and signing time
+// come from the unverified SignerInfo. The key ID is the certificate's
+// fingerprint if the certificate is included in the signature. Otherwise it is
+// the subject key identifier or serial number from the SignerInfo.
+func emitErrSig(sd *cms.SignedData, i int, err error) {
+	var (
+		keyID            = "0000000000000000"
+		pkAlgo, hashAlgo byte
+		sigClass         byte
+		signingTime      int64
+		rc               = errSigRC(err)
+		signerInfos      = sd.GetSignerInfos()
+	)
+
+	if i < 0 || i >= len(signerInfos) {
+		sErrSig.emitf("%s %d %d %02x %d %d", keyID, pkAlgo, hashAlgo, sigClass, signingTime, rc)
+		return
+	}
+	si := signerInfos[i]
+
+	certs, _ := sd.GetCertificates()
+	if cert, err := si.FindCertificate(certs); err == nil {
+		keyID = certHexFingerprint(cert)
+		pkAlgo = pkAlgoForPublicKeyAlgorithm(cert.PublicKeyAlgorithm)
+	} else {
+		if ski, err := si.SubjectKeyIdentifierSID(); err == nil {
+			keyID = strings.ToUpper(hex.EncodeToString(ski))
+		} else if isn, err := si.IssuerAndSerialNumberSID(); err == nil {
+			keyID = strings.ToUpper(isn.SerialNumber.Text(16))
+		}
+		if algo, _, err := si.PublicKeyAlgorithmAndHash(); err == nil {
+			pkAlgo = pkAlgoForPublicKeyAlgorithm(algo)
+		} else {
+			pkAlgo = pkAlgoForSignatureAlgorithm(si.X509SignatureAlgorithm())
+		}
+	}
+
+	if hash, err := si.Hash(); err == nil {
+		hashAlgo = hashAlgos[hash]
+	}
+
+	if t, err := si.GetSigningTimeAttribute(); err == nil {
+		signingTime = t.Unix()
+	}
+
+	sErrSig.emitf("%s %d %d %02x %d %d", keyID, pkAlgo, hashAlgo, sigClass, signingTime, rc)
+}
+
+// errSigRC gets the ERRSIG return code for a verification error.
+func errSigRC(err error) int {
+	switch cause := errors.Cause(err).(type) {
+	case cms.AlgorithmPolicyError, x509.InsecureAlgorithmError:
+		return errSigUnsupportedAlgorithm
+	case protocol.ASN1Error:
+		if cause == protocol.ErrUnsupported {
+			return errSigUnsupportedAlgorithm
+		}
+	default:
+		if cause == x509.ErrUnsupportedAlgorithm {
+			return errSigUnsupportedAlgorithm
+		}
+		if cause == protocol.ErrNoCertificate {
+			return errSigMissingKey
+		}
+	}
+
+	return errSigGeneral
+}
+
+// pkAlgoForPublicKeyAlgorithm gets the OpenPGP public key algorithm ID for an
+// x509.PublicKeyAlgorithm, or 0 if there isn't one.
+func pkAlgoForPublicKeyAlgorithm(algo x509.PublicKeyAlgorithm) byte {
+	switch algo {
+	case x509.RSA:
+		return byte(packet.PubKeyAlgoRSA)
+	case x509.DSA:
+		return byte(packet.PubKeyAlgoDSA)
+	case x509.ECDSA:
+		return byte(packet.PubKeyAlgoECDSA)
+	case x509.Ed25519:
+		return pubKeyAlgoEdDSA
+	}
+
+	return 0
+}
+
+// pkAlgoForSignatureAlgorithm gets the OpenPGP public key algorithm ID for an
+// x509.SignatureAlgorithm, or 0 if there isn't one.
+func pkAlgoForSignatureAlgorithm(algo x509.SignatureAlgorithm) byte {
+	switch algo {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS:
+		return byte(packet.PubKeyAlgoRSA)
+	case x509.DSAWithSHA1, x509.DSAWithSHA256:
+		return byte(packet.PubKeyAlgoDSA)
+	case x509.ECDSAWithSHA1, x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return byte(packet.PubKeyAlgoECDSA)
+	case x509.PureEd25519:
+		return pubKeyAlgoEdDSA
+	}
+
+	return 0
+}
+
+// emitKeyConsidered emits KEY_CONSIDERED for the certificate matching the
+// user-id, flagging it if it can't be used for signing.
+func emitKeyConsidered(cert *x509.Certificate, usable bool) {
+	var flags int
+	if !usable {
+		flags = 1
+	}
+
+	sKeyConsidered.emitf("%s %d", certHexFingerprint(cert), flags)
+}
+
+// emitInvSgnr emits INV_SGNR for the user-id with the reason it can't be used
+// for signing.
+func emitInvSgnr(reason int) {
+	sInvSgnr.emitf("%d %s", reason, *localUserOpt)
+}
+
+// emitInvRecp emits INV_RECP for a recipient user-id with the reason it can't
+// be encrypted to.
+func emitInvRecp(reason int, recipient string) {
+	sInvRecp.emitf("%d %s", reason, recipient)
+}
+
+// emitFailure emits FAILURE for an operation that failed with the given
+// libgpg-error code.
+func emitFailure(location string, code int) {
+	sFailure.emitf("%s %d", location, gpgErrSourceGPGSM|code)
+}
+
 func emitTrustFully() {
 	sTrustFully.emitf("0 shell")
 }
+
+func emitTrustMarginal() {
+	sTrustMarginal.emitf("0 shell")
+}
+
+func emitTrustUndefined(errorToken string) {
+	sTrustUndefined.emitf("%s", errorToken)
+}
+
+func emitTrustNever(errorToken string) {
+	sTrustNever.emitf("%s", errorToken)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/github/smimesign/ietf-cms/protocol"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// captureStatus redirects status output to a temporary file. The returned
+// function gets the output written so far.
+func captureStatus(t *testing.T) (func() string, func()) {
+	t.Helper()
+
+	f, err := ioutil.TempFile("", "smimesign-status")
+	require.NoError(t, err)
+
+	setupStatus()
+	orig := statusFile
+	statusFile = f
+
+	read := func() string {
+		data, err := ioutil.ReadFile(f.Name())
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	reset := func() {
+		statusFile = orig
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	return read, reset
+}
+
+func TestErrSigRC(t *testing.T) {
+	require.Equal(t, errSigUnsupportedAlgorithm, errSigRC(protocol.ErrUnsupported))
+	require.Equal(t, errSigUnsupportedAlgorithm, errSigRC(pkgerrors.Wrap(protocol.ErrUnsupported, "foo")))
+	require.Equal(t, errSigUnsupportedAlgorithm, errSigRC(cms.AlgorithmPolicyError{Message: "foo"}))
+	require.Equal(t, errSigUnsupportedAlgorithm, errSigRC(x509.InsecureAlgorithmError(x509.MD5WithRSA)))
+	require.Equal(t, errSigUnsupportedAlgorithm, errSigRC(x509.ErrUnsupportedAlgorithm))
+	require.Equal(t, errSigUnsupportedAlgorithm, errSigRC(pkgerrors.Wrap(x509.ErrUnsupportedAlgorithm, "foo")))
+	require.Equal(t, errSigMissingKey, errSigRC(protocol.ErrNoCertificate))
+	require.Equal(t, errSigMissingKey, errSigRC(pkgerrors.Wrap(protocol.ErrNoCertificate, "foo")))
+	require.Equal(t, errSigGeneral, errSigRC(protocol.ErrWrongType))
+	require.Equal(t, errSigGeneral, errSigRC(errors.New("foo")))
+}
+
+func TestEmitErrSig(t *testing.T) {
+	defer testSetup(t, "--verify")()
+	read, reset := captureStatus(t)
+	defer reset()
+
+	der, err := cms.Sign([]byte("hello, world!"), leaf.Chain(), leaf.PrivateKey)
+	require.NoError(t, err)
+	sd, err := cms.ParseSignedData(der)
+	require.NoError(t, err)
+
+	signingTime, err := sd.GetSignerInfos()[0].GetSigningTimeAttribute()
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), signingTime, time.Minute)
+
+	// The certificate is included, so its fingerprint is used.
+	emitErrSig(sd, 0, errors.New("untrusted"))
+	require.Equal(t, fmt.Sprintf("[GNUPG:] ERRSIG %s 1 8 00 %d 1\n", certHexFingerprint(leaf.Certificate), signingTime.Unix()), read())
+
+	// Without the certificate, the serial number is used.
+	require.NoError(t, sd.SetCertificates(nil))
+	_, err = sd.Verify(x509.VerifyOptions{})
+	emitErrSig(sd, 0, err)
+
+	lines := strings.Split(strings.TrimSpace(read()), "\n")
+	serial := strings.ToUpper(leaf.Certificate.SerialNumber.Text(16))
+	require.Equal(t, fmt.Sprintf("[GNUPG:] ERRSIG %s 1 8 00 %d 9", serial, signingTime.Unix()), lines[1])
+}
+
+func TestEmitStatusSHA3(t *testing.T) {
+	defer testSetup(t, "--verify")()
+	read, reset := captureStatus(t)
+	defer reset()
+
+	der, err := cms.Sign([]byte("hello, world!"), leaf.Chain(), cms.DigestSigner(leaf.PrivateKey, crypto.SHA3_256))
+	require.NoError(t, err)
+	sd, err := cms.ParseSignedData(der)
+	require.NoError(t, err)
+	si := sd.GetSignerInfos()[0]
+	signingTime, err := si.GetSigningTimeAttribute()
+	require.NoError(t, err)
+
+	// SHA3-256 is OpenPGP hash algorithm 12.
+	emitValidSig(leaf.Certificate, nil, si, signingTime)
+	fields := strings.Fields(read())
+	require.Equal(t, []string{"1", "12"}, fields[8:10])
+
+	// The public key algorithm comes from the signature algorithm when the
+	// certificate is missing, even though x509 doesn't know it.
+	require.NoError(t, sd.SetCertificates(nil))
+	emitErrSig(sd, 0, protocol.ErrNoCertificate)
+	lines := strings.Split(strings.TrimSpace(read()), "\n")
+	serial := strings.ToUpper(leaf.Certificate.SerialNumber.Text(16))
+	require.Equal(t, fmt.Sprintf("[GNUPG:] ERRSIG %s 1 12 00 %d 9", serial, signingTime.Unix()), lines[1])
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// loadCerts loads PEM encoded certificates from the given files and
+// directories. Directories are not searched recursively and files in them that
+// don't contain any certificates are skipped.
+func loadCerts(paths []string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+
+	for _, path := range paths {
+		// getopt gives us an empty element for an unset list option.
+		if len(path) == 0 {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			fileCerts, err := loadCertsFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if len(fileCerts) == 0 {
+				return nil, fmt.Errorf("no certificates found in %s", path)
+			}
+
+			certs = append(certs, fileCerts...)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if !entry.Mode().IsRegular() {
+				continue
+			}
+
+			fileCerts, err := loadCertsFile(filepath.Join(path, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			certs = append(certs, fileCerts...)
+		}
+	}
+
+	return certs, nil
+}
+
+// loadCertsFile loads all the PEM encoded certificates in a file.
+func loadCertsFile(filename string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		certs []*x509.Certificate
+		blk   *pem.Block
+	)
+
+	for {
+		if blk, data = pem.Decode(data); blk == nil {
+			break
+		}
+		if blk.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(blk.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse certificate in %s", filename)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
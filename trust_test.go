@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertsFile writes the PEM encoded certs to a file in dir, returning its
+// path.
+func writeCertsFile(t *testing.T, dir string, name string, certs ...*x509.Certificate) string {
+	t.Helper()
+
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	return path
+}
+
+func TestLoadCerts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+	writeCertsFile(t, dir, "chain.pem", intermediate.Certificate, leaf.Certificate)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("hi"), 0644))
+
+	certs, err := loadCerts([]string{caFile})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(certs))
+	require.True(t, certs[0].Equal(ca.Certificate))
+
+	certs, err = loadCerts([]string{dir, ""})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(certs))
+
+	_, err = loadCerts([]string{filepath.Join(dir, "README")})
+	require.Error(t, err)
+
+	_, err = loadCerts([]string{filepath.Join(dir, "missing")})
+	require.Error(t, err)
+}
+
+func TestVerifyOpts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+	intFile := writeCertsFile(t, dir, "int.pem", intermediate.Certificate)
+
+	func() {
+		defer testSetup(t, "--verify", "--no-system-roots")()
+
+		opts, err := verifyOpts()
+		require.NoError(t, err)
+
+		// Local identities aren't trusted by default.
+		_, err = leaf.Certificate.Verify(opts)
+		require.Error(t, err)
+	}()
+
+	func() {
+		defer testSetup(t, "--verify", "--no-system-roots", "--trust-local-certs")()
+
+		opts, err := verifyOpts()
+		require.NoError(t, err)
+
+		_, err = leaf.Certificate.Verify(opts)
+		require.NoError(t, err)
+	}()
+
+	func() {
+		defer testSetup(t, "--verify", "--no-system-roots", "--trust-anchors", caFile, "--intermediates", intFile)()
+
+		opts, err := verifyOpts()
+		require.NoError(t, err)
+
+		_, err = leaf.Certificate.Verify(opts)
+		require.NoError(t, err)
+	}()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ekuNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"OCSPSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalSignature": x509.KeyUsageDigitalSignature,
+	"nonRepudiation":   x509.KeyUsageContentCommitment,
+	"keyEncipherment":  x509.KeyUsageKeyEncipherment,
+	"dataEncipherment": x509.KeyUsageDataEncipherment,
+	"keyAgreement":     x509.KeyUsageKeyAgreement,
+	"keyCertSign":      x509.KeyUsageCertSign,
+	"cRLSign":          x509.KeyUsageCRLSign,
+}
+
+// ekusFromOpt parses the extended key usages accepted for signer certificates
+// from --ekus. A certificate must allow at least one of them.
+func ekusFromOpt() ([]x509.ExtKeyUsage, error) {
+	var ekus []x509.ExtKeyUsage
+
+	for _, name := range splitList(*ekusOpt) {
+		eku, ok := ekuNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown extended key usage: %s", name)
+		}
+		ekus = append(ekus, eku)
+	}
+
+	if len(ekus) == 0 {
+		ekus = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	}
+
+	return ekus, nil
+}
+
+// keyUsageFromOpt parses the key usage bits accepted for signer certificates
+// from --key-usages. A certificate with a key usage extension must have at
+// least one of them.
+func keyUsageFromOpt() (x509.KeyUsage, error) {
+	var ku x509.KeyUsage
+
+	for _, name := range splitList(*keyUsagesOpt) {
+		bit, ok := keyUsageNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown key usage: %s", name)
+		}
+		ku |= bit
+	}
+
+	return ku, nil
+}
+
+// checkKeyUsage checks that a signer certificate's key usage extension, if it
+// has one, allows one of the key usages from --key-usages.
+func checkKeyUsage(cert *x509.Certificate) error {
+	ku, err := keyUsageFromOpt()
+	if err != nil {
+		return err
+	}
+
+	// Certificates without a key usage extension may be used for anything.
+	if ku == 0 || cert.KeyUsage == 0 || cert.KeyUsage&ku != 0 {
+		return nil
+	}
+
+	return errors.Errorf("certificate \"%s\" isn't valid for key usages %s", cert.Subject.String(), *keyUsagesOpt)
+}
+
+// checkEKU checks that a signer certificate's extended key usage extension, if
+// it has one, allows one of the extended key usages from --ekus. Unlike
+// x509.Certificate.Verify, this doesn't look at the rest of the chain.
+func checkEKU(cert *x509.Certificate) error {
+	ekus, err := ekusFromOpt()
+	if err != nil {
+		return err
+	}
+
+	// Certificates without an extended key usage extension may be used for
+	// anything.
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return nil
+	}
+
+	for _, want := range ekus {
+		if want == x509.ExtKeyUsageAny {
+			return nil
+		}
+		for _, have := range cert.ExtKeyUsage {
+			if have == x509.ExtKeyUsageAny || have == want {
+				return nil
+			}
+		}
+	}
+
+	return errors.Errorf("certificate \"%s\" isn't valid for extended key usages %s", cert.Subject.String(), *ekusOpt)
+}
+
+// warnUsage prints a warning if the signing certificate doesn't satisfy the
+// key usages that will be required when verifying the signature.
+func warnUsage(cert *x509.Certificate) {
+	for _, check := range []func(*x509.Certificate) error{checkEKU, checkKeyUsage} {
+		if err := check(cert); err != nil {
+			fmt.Fprintf(stderr, "smimesign: WARNING: %s\n", err)
+		}
+	}
+}
+
+// splitList splits a comma separated list, ignoring empty entries.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
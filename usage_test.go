@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/github/smimesign/certstore"
+	"github.com/github/smimesign/fakeca"
+	cms "github.com/github/smimesign/ietf-cms"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckKeyUsage(t *testing.T) {
+	defer testSetup(t, "--verify")()
+
+	require.NoError(t, checkKeyUsage(leaf.Certificate))
+	require.NoError(t, checkKeyUsage(intermediate.Issue(fakeca.KeyUsage(x509.KeyUsageDigitalSignature)).Certificate))
+	require.NoError(t, checkKeyUsage(intermediate.Issue(fakeca.KeyUsage(x509.KeyUsageContentCommitment)).Certificate))
+	require.Error(t, checkKeyUsage(intermediate.Issue(fakeca.KeyUsage(x509.KeyUsageKeyEncipherment)).Certificate))
+}
+
+func TestCheckEKU(t *testing.T) {
+	var (
+		tlsLeaf   = intermediate.Issue(fakeca.ExtKeyUsage(x509.ExtKeyUsageServerAuth))
+		emailLeaf = intermediate.Issue(fakeca.ExtKeyUsage(x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageEmailProtection))
+	)
+
+	func() {
+		defer testSetup(t, "--verify")()
+
+		require.NoError(t, checkEKU(leaf.Certificate))
+		require.NoError(t, checkEKU(emailLeaf.Certificate))
+		require.Error(t, checkEKU(tlsLeaf.Certificate))
+	}()
+
+	func() {
+		defer testSetup(t, "--verify", "--ekus", "serverAuth")()
+		require.NoError(t, checkEKU(tlsLeaf.Certificate))
+	}()
+
+	func() {
+		defer testSetup(t, "--verify", "--ekus", "bogus")()
+		require.Error(t, checkEKU(leaf.Certificate))
+	}()
+}
+
+func TestVerifyEKU(t *testing.T) {
+	tlsLeaf := intermediate.Issue(fakeca.ExtKeyUsage(x509.ExtKeyUsageServerAuth))
+
+	sig, err := cms.Sign([]byte("hello, world!"), tlsLeaf.Chain(), tlsLeaf.PrivateKey)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+
+	func() {
+		defer testSetup(t, "--verify", "--trust-anchors", caFile)()
+
+		stdinBuf.Write(sig)
+		require.Error(t, commandVerify())
+	}()
+
+	func() {
+		defer testSetup(t, "--verify", "--trust-anchors", caFile, "--ekus", "serverAuth,emailProtection")()
+
+		stdinBuf.Write(sig)
+		require.NoError(t, commandVerify())
+	}()
+}
+
+func TestVerifyKeyUsage(t *testing.T) {
+	encLeaf := intermediate.Issue(fakeca.KeyUsage(x509.KeyUsageKeyEncipherment))
+
+	sig, err := cms.Sign([]byte("hello, world!"), encLeaf.Chain(), encLeaf.PrivateKey)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "smimesign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := writeCertsFile(t, dir, "ca.pem", ca.Certificate)
+
+	func() {
+		defer testSetup(t, "--verify", "--trust-anchors", caFile)()
+
+		stdinBuf.Write(sig)
+		require.Error(t, commandVerify())
+	}()
+
+	func() {
+		defer testSetup(t, "--verify", "--trust-anchors", caFile, "--key-usages", "")()
+
+		stdinBuf.Write(sig)
+		require.NoError(t, commandVerify())
+	}()
+}
+
+func TestSignWarnUsage(t *testing.T) {
+	tlsLeaf := intermediate.Issue(fakeca.ExtKeyUsage(x509.ExtKeyUsageServerAuth))
+
+	defer testSetup(t, "--sign", "-u", certHexFingerprint(tlsLeaf.Certificate))()
+	idents = []certstore.Identity{identity{tlsLeaf}}
+
+	stdinBuf.WriteString("hello, world!")
+	require.NoError(t, commandSign())
+	require.Contains(t, stderrBuf.String(), "WARNING")
+	require.Contains(t, stderrBuf.String(), "extended key usages")
+}